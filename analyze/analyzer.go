@@ -0,0 +1,453 @@
+// Package analyze builds prompts from the files in CockroachDB statement
+// bundles and sends them to a language model for analysis.
+package analyze
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSystemPrompt is the system message sent to the model when
+// Options.SystemPrompt is empty.
+const DefaultSystemPrompt = "You are a database performance expert."
+
+// Providers supported by New.
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderGemini    = "gemini"
+)
+
+// Roles of the messages in a conversation.
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a single turn in a conversation with a language model.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Analyzer sends a prompt to a language model and returns its reply.
+type Analyzer interface {
+	Analyze(ctx context.Context, prompt string) (*Completion, error)
+	// Chat sends a conversation to the model and returns its next reply. The
+	// messages alternate between the user and the assistant, starting and
+	// ending with the user.
+	Chat(ctx context.Context, messages []Message) (*Completion, error)
+	// Model returns the name of the model that prompts are sent to.
+	Model() string
+}
+
+// Reasons that a model stops generating a reply, as reported in
+// Completion.FinishReason. Other providers' reasons are translated to these.
+const (
+	// FinishStop means that the reply is complete.
+	FinishStop = "stop"
+	// FinishLength means that the reply was cut off at the token limit.
+	FinishLength = "length"
+	// FinishContentFilter means that the reply was cut off by the
+	// provider's content filter.
+	FinishContentFilter = "content_filter"
+)
+
+// Completion is a language model's reply to a prompt.
+type Completion struct {
+	Content string
+	// Model is the model that generated the completion.
+	Model string
+	// FinishReason is the reason the model stopped generating, such as
+	// FinishStop or FinishLength, if reported.
+	FinishReason string
+	// Choices holds every candidate reply, starting with Content, when more
+	// than one was requested with Options.Choices.
+	Choices []string
+	// SystemFingerprint identifies the configuration of the backend that
+	// generated the completion, if reported. Completions requested with the
+	// same Options.Seed are only expected to match while it is unchanged.
+	SystemFingerprint string
+	Usage             Usage
+}
+
+// Options configures the requests made by an Analyzer.
+type Options struct {
+	Model    string
+	Endpoint string
+	// APIKey is the key used to authenticate requests. If empty, it is read
+	// from the provider's environment variables, such as OPENAI_API_KEY.
+	APIKey string
+	// Organization and Project, if set, are sent in the OpenAI-Organization
+	// and OpenAI-Project headers of OpenAI requests, to bill them to an
+	// organization and project other than the API key's default. If empty,
+	// they are read from OPENAI_ORG_ID and OPENAI_PROJECT_ID.
+	Organization string
+	Project      string
+	// NoAuth allows requests to be sent without an API key, for
+	// OpenAI-compatible servers that don't require one. A key is never
+	// required by a server on localhost.
+	NoAuth bool
+	// SystemPrompt is the content of the system message.
+	SystemPrompt string
+	// HTTPClient is the client used to send requests. If nil, a client
+	// returned by NewHTTPClient with no timeout or proxy is used, and
+	// requests are bounded only by their context.
+	HTTPClient *http.Client
+	// Temperature, if non-nil, is the sampling temperature. Lower values give
+	// more deterministic replies. If nil, it is left out of the request and
+	// the model's default is used, since some models reject it.
+	Temperature *float64
+	// MaxTokens, if positive, limits the number of tokens in the reply.
+	MaxTokens int
+	// Seed, if non-nil, asks the model to sample deterministically, so that
+	// repeated requests with the same seed and parameters return the same
+	// reply as far as possible. The Anthropic provider doesn't support it.
+	Seed *int64
+	// Retries is the number of times a rate-limited or failed request is
+	// retried before giving up.
+	Retries int
+	// Choices, if greater than one, is the number of candidate replies
+	// requested by Analyze. Chat always requests a single reply. Only the
+	// OpenAI provider supports it, and it cannot be used with Stream.
+	Choices int
+	// JSONMode asks the model to reply with a JSON object, using the
+	// provider's structured output support where available. The prompt must
+	// still ask for JSON, such as with JSONInstructions.
+	JSONMode bool
+	// ToolCalling asks Analyze for a structured analysis by requiring the
+	// model to call a report_findings tool whose parameters are
+	// AnalysisSchema, rather than by JSON mode. The tool call's arguments
+	// are returned as the reply's content, so they can be parsed with
+	// ParseAnalysis. Only the OpenAI provider supports it.
+	ToolCalling bool
+	// Stream, if non-nil, causes the response to be streamed and each
+	// content delta to be written to it as it arrives.
+	Stream io.Writer
+	// Logf, if non-nil, is called to log the details of each request.
+	Logf func(format string, args ...any)
+	// OnRateLimit, if non-nil, is called with the state of the request rate
+	// limit reported by each response, including error responses, so that
+	// later requests can be paced to avoid being rate limited. Only the
+	// OpenAI provider reports it.
+	OnRateLimit func(RateLimit)
+}
+
+// logf logs a message with o.Logf, if it is set.
+func (o Options) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+// New returns the Analyzer for the given provider. If opts.Model or
+// opts.Endpoint are empty, the provider's defaults are used.
+func New(provider string, opts Options) (Analyzer, error) {
+	if opts.SystemPrompt == "" {
+		opts.SystemPrompt = DefaultSystemPrompt
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = NewHTTPClient(0, nil)
+	}
+	switch provider {
+	case ProviderOpenAI:
+		if opts.Model == "" {
+			opts.Model = DefaultOpenAIModel
+		}
+		opts.Endpoint = resolveEndpoint(opts.Endpoint)
+		if opts.Organization == "" {
+			opts.Organization = strings.TrimSpace(os.Getenv("OPENAI_ORG_ID"))
+		}
+		if opts.Project == "" {
+			opts.Project = strings.TrimSpace(os.Getenv("OPENAI_PROJECT_ID"))
+		}
+		return &openAIClient{opts: opts}, nil
+	case ProviderAnthropic:
+		if opts.Choices > 1 {
+			return nil, fmt.Errorf("provider %s does not support multiple choices", provider)
+		}
+		if opts.ToolCalling {
+			return nil, fmt.Errorf("provider %s does not support tool calling", provider)
+		}
+		if opts.Seed != nil {
+			return nil, fmt.Errorf("provider %s does not support a seed", provider)
+		}
+		if opts.Model == "" {
+			opts.Model = DefaultAnthropicModel
+		}
+		if opts.Endpoint == "" {
+			opts.Endpoint = DefaultAnthropicEndpoint
+		}
+		return &anthropicClient{opts: opts}, nil
+	case ProviderGemini:
+		if opts.Choices > 1 {
+			return nil, fmt.Errorf("provider %s does not support multiple choices", provider)
+		}
+		if opts.ToolCalling {
+			return nil, fmt.Errorf("provider %s does not support tool calling", provider)
+		}
+		if opts.Model == "" {
+			opts.Model = DefaultGeminiModel
+		}
+		if opts.Endpoint == "" {
+			opts.Endpoint = DefaultGeminiEndpoint
+		}
+		return &geminiClient{opts: opts}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+}
+
+// ReadAPIKeyFile returns the API key stored in the file at path, trimmed of
+// surrounding whitespace such as a trailing newline.
+func ReadAPIKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return key, nil
+}
+
+// NewHTTPClient returns a client for sending requests with the given timeout,
+// or no timeout if it is zero. Requests are sent through proxy if it is
+// non-nil, otherwise through the proxy configured by the HTTPS_PROXY,
+// HTTP_PROXY, and NO_PROXY environment variables, if any.
+func NewHTTPClient(timeout time.Duration, proxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// statusError is returned when the API responds with a non-200 status code.
+type statusError struct {
+	code       int
+	body       []byte
+	retryAfter time.Duration
+}
+
+// apiErrorBody is the JSON body of an API error response. OpenAI reports a
+// code such as "invalid_api_key", Anthropic reports only a type such as
+// "authentication_error", and Gemini reports the HTTP status code along with a
+// status such as "INVALID_ARGUMENT".
+type apiErrorBody struct {
+	Error struct {
+		Message string `json:"message"`
+		Code    any    `json:"code"`
+		Type    string `json:"type"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func (e *statusError) Error() string {
+	var body apiErrorBody
+	if err := json.Unmarshal(e.body, &body); err != nil || body.Error.Message == "" {
+		return fmt.Sprintf("API call failed: %s", e.body)
+	}
+	code := body.Error.Type
+	switch c := body.Error.Code.(type) {
+	case string:
+		if c != "" {
+			code = c
+		}
+	case float64:
+		code = strconv.FormatFloat(c, 'f', -1, 64)
+	}
+	if body.Error.Status != "" {
+		code = body.Error.Status
+	}
+	if code == "" {
+		code = strconv.Itoa(e.code)
+	}
+	return fmt.Sprintf("API error (%s): %s", code, body.Error.Message)
+}
+
+// retryable returns true if the request that produced the error may succeed
+// if it is sent again.
+func (e *statusError) retryable() bool {
+	return e.code == http.StatusTooManyRequests || (e.code >= 500 && e.code <= 599)
+}
+
+// These match the messages of the errors that providers return when the
+// prompt doesn't fit in the model's context, capturing the number of tokens
+// the model allows and the number in the prompt.
+var (
+	// OpenAI: "This model's maximum context length is 8192 tokens. However,
+	// your messages resulted in 9000 tokens." or "However, you requested
+	// 10000 tokens (9000 in the messages, 1000 in the completion)."
+	openAIContextLimitRE  = regexp.MustCompile(`maximum context length is (\d+) tokens`)
+	openAIContextPromptRE = regexp.MustCompile(`(?:resulted in (\d+) tokens|(\d+) in the messages)`)
+	// Anthropic: "prompt is too long: 210000 tokens > 200000 maximum".
+	anthropicContextRE = regexp.MustCompile(`(\d+) tokens > (\d+) maximum`)
+	// Gemini: "The input token count (1200000) exceeds the maximum number of
+	// tokens allowed (1048576)."
+	geminiContextRE = regexp.MustCompile(`input token count \((\d+)\) exceeds the maximum number of tokens allowed \((\d+)\)`)
+)
+
+// ContextLengthExceeded returns true if err is the error a provider returns
+// when the prompt is too long for the model's context, such as OpenAI's
+// context_length_exceeded. It also returns the number of tokens the model
+// allows and the number of tokens in the prompt, if the error reports them,
+// or zero if it doesn't.
+func ContextLengthExceeded(err error) (limit, prompt int, ok bool) {
+	var statusErr *statusError
+	if !errors.As(err, &statusErr) || statusErr.code != http.StatusBadRequest {
+		return 0, 0, false
+	}
+	body := string(statusErr.body)
+	switch {
+	case strings.Contains(body, "context_length_exceeded") || openAIContextLimitRE.MatchString(body):
+		if m := openAIContextLimitRE.FindStringSubmatch(body); m != nil {
+			limit, _ = strconv.Atoi(m[1])
+		}
+		if m := openAIContextPromptRE.FindStringSubmatch(body); m != nil {
+			prompt, _ = strconv.Atoi(m[1] + m[2])
+		}
+	case anthropicContextRE.MatchString(body):
+		m := anthropicContextRE.FindStringSubmatch(body)
+		prompt, _ = strconv.Atoi(m[1])
+		limit, _ = strconv.Atoi(m[2])
+	case geminiContextRE.MatchString(body):
+		m := geminiContextRE.FindStringSubmatch(body)
+		prompt, _ = strconv.Atoi(m[1])
+		limit, _ = strconv.Atoi(m[2])
+	default:
+		return 0, 0, false
+	}
+	return limit, prompt, true
+}
+
+// newStatusError returns a statusError for the non-200 response resp,
+// consuming its body.
+func newStatusError(resp *http.Response) *statusError {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return &statusError{
+		code:       resp.StatusCode,
+		body:       bodyBytes,
+		retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// withRetries calls fn until it succeeds, returns an error that is not
+// retryable, or has been retried the given number of times. The Retry-After
+// duration of the error is honored when present, otherwise the delay between
+// attempts backs off exponentially.
+func withRetries(
+	ctx context.Context, retries int, fn func() (*Completion, error),
+) (*Completion, error) {
+	for attempt := 0; ; attempt++ {
+		c, err := fn()
+		var statusErr *statusError
+		if err == nil || !errors.As(err, &statusErr) || !statusErr.retryable() || attempt >= retries {
+			return c, err
+		}
+
+		delay := statusErr.retryAfter
+		if delay == 0 {
+			delay = time.Second << attempt
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date. It returns zero if the value is empty or
+// invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// errStreamDone is returned by a streamDecoder when the terminating event of a
+// stream has been received.
+var errStreamDone = errors.New("stream done")
+
+// streamDecoder decodes the data of a single server-sent event into the
+// content delta it carries. Any other information carried by the event, such
+// as token usage, is recorded in c.
+type streamDecoder func(data string, c *Completion) (string, error)
+
+// readStream reads server-sent events from r, writing each content delta to w
+// as it arrives. It returns the full completion once the stream is complete.
+func readStream(r io.Reader, w io.Writer, decode streamDecoder) (*Completion, error) {
+	var c Completion
+	var content strings.Builder
+	var data strings.Builder
+	// dispatch handles a complete event, which may have been split across
+	// multiple data lines and multiple reads. It returns true when the
+	// terminating event has been received.
+	dispatch := func() (bool, error) {
+		defer data.Reset()
+		if data.Len() == 0 {
+			return false, nil
+		}
+		delta, err := decode(data.String(), &c)
+		if errors.Is(err, errStreamDone) {
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("malformed stream event: %w", err)
+		}
+		if _, err := io.WriteString(w, delta); err != nil {
+			return false, err
+		}
+		content.WriteString(delta)
+		return false, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if done, err := dispatch(); done || err != nil {
+				c.Content = content.String()
+				return &c, err
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(value, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		c.Content = content.String()
+		return &c, err
+	}
+	// Dispatch any trailing event that wasn't followed by a blank line.
+	_, err := dispatch()
+	c.Content = content.String()
+	return &c, err
+}
@@ -0,0 +1,14 @@
+package analyze
+
+import "regexp"
+
+// ansiRE matches ANSI escape sequences: CSI sequences such as color codes,
+// OSC sequences such as hyperlinks and window titles, and two-character
+// escapes.
+var ansiRE = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// stripANSI returns s with its ANSI escape sequences removed, such as the
+// color codes in a plan captured from a terminal.
+func stripANSI(s string) string {
+	return ansiRE.ReplaceAllString(s, "")
+}
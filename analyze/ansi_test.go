@@ -0,0 +1,24 @@
+package analyze
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"\x1b[1;32m• scan\x1b[0m\n", "• scan\n"},
+		{"\x1b[38;5;208mtable:\x1b[m users@users_pkey", "table: users@users_pkey"},
+		{"\x1b]8;;http://example.com\x07link\x1b]8;;\x07", "link"},
+		{"\x1b[2K\x1b[1Gplanning time: 2ms", "planning time: 2ms"},
+		// Ordinary SQL, including brackets and escapes in literals, is left
+		// untouched.
+		{
+			"SELECT a[1], e'\\x1b[0m' FROM t WHERE b ~ '^\\[.*\\]$';\n",
+			"SELECT a[1], e'\\x1b[0m' FROM t WHERE b ~ '^\\[.*\\]$';\n",
+		},
+	} {
+		if got := stripANSI(tc.in); got != tc.want {
+			t.Errorf("stripANSI(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package analyze
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultAnthropicEndpoint = "https://api.anthropic.com/v1/messages"
+	anthropicVersion         = "2023-06-01"
+	DefaultAnthropicModel    = "claude-sonnet-4-5"
+	// anthropicMaxTokens is the maximum number of tokens generated in a
+	// reply when -max-completion-tokens is not set. The Messages API requires
+	// it to be set.
+	anthropicMaxTokens = 4096
+)
+
+type anthropicRequest struct {
+	Model       string    `json:"model"`
+	MaxTokens   int       `json:"max_tokens"`
+	Temperature *float64  `json:"temperature,omitempty"`
+	System      string    `json:"system,omitempty"`
+	Messages    []Message `json:"messages"`
+	Stream      bool      `json:"stream,omitempty"`
+}
+
+type anthropicResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      anthropicUsage `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicEvent is a single server-sent event of a streamed response.
+type anthropicEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+		// StopReason is set on the message_delta event.
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	// Message is set on the message_start event.
+	Message *anthropicResponse `json:"message"`
+	// Usage is set on the message_delta event.
+	Usage *anthropicUsage `json:"usage"`
+}
+
+// toUsage converts u to the common Usage type.
+func (u anthropicUsage) toUsage() Usage {
+	return Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// anthropicClient is an Analyzer backed by the Anthropic Messages API.
+type anthropicClient struct {
+	opts Options
+}
+
+var _ Analyzer = (*anthropicClient)(nil)
+
+// Model implements the Analyzer interface.
+func (c *anthropicClient) Model() string {
+	return c.opts.Model
+}
+
+// Analyze implements the Analyzer interface.
+func (c *anthropicClient) Analyze(ctx context.Context, prompt string) (*Completion, error) {
+	return c.Chat(ctx, []Message{{Role: RoleUser, Content: prompt}})
+}
+
+// Chat implements the Analyzer interface.
+func (c *anthropicClient) Chat(ctx context.Context, messages []Message) (*Completion, error) {
+	apiKey := c.opts.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("ANTHROPIC_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("ANTHROPIC_API_KEY not set")
+	}
+
+	maxTokens := c.opts.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = anthropicMaxTokens
+	}
+	reqBody := anthropicRequest{
+		Model:       c.opts.Model,
+		MaxTokens:   maxTokens,
+		Temperature: c.opts.Temperature,
+		System:      c.opts.SystemPrompt,
+		Messages:    messages,
+		Stream:      c.opts.Stream != nil,
+	}
+
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	comp, err := withRetries(ctx, c.opts.Retries, func() (*Completion, error) {
+		return c.doRequest(ctx, apiKey, jsonBody)
+	})
+	if comp != nil && comp.Model == "" {
+		comp.Model = c.opts.Model
+	}
+	return comp, err
+}
+
+// doRequest makes a single Messages API request with the given JSON body.
+func (c *anthropicClient) doRequest(ctx context.Context, apiKey string, jsonBody []byte) (*Completion, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.opts.Endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-api-key", apiKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	c.opts.logf("POST %s (model %s)", c.opts.Endpoint, c.opts.Model)
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	c.opts.logf("HTTP status %s", resp.Status)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
+	}
+
+	if c.opts.Stream != nil {
+		return readStream(resp.Body, c.opts.Stream, decodeAnthropicStream)
+	}
+
+	var msgResp anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&msgResp); err != nil {
+		return nil, err
+	}
+
+	var content strings.Builder
+	for _, block := range msgResp.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	return &Completion{
+		Content:      content.String(),
+		Model:        msgResp.Model,
+		FinishReason: finishReason(msgResp.StopReason),
+		Usage:        msgResp.Usage.toUsage(),
+	}, nil
+}
+
+// finishReason translates an Anthropic stop_reason to a FinishReason.
+func finishReason(stopReason string) string {
+	switch stopReason {
+	case "end_turn", "stop_sequence":
+		return FinishStop
+	case "max_tokens":
+		return FinishLength
+	case "refusal":
+		return FinishContentFilter
+	default:
+		return stopReason
+	}
+}
+
+// decodeAnthropicStream is the streamDecoder for Anthropic streamed responses,
+// which are terminated by a "message_stop" event.
+func decodeAnthropicStream(data string, c *Completion) (string, error) {
+	var event anthropicEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return "", err
+	}
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			c.Model = event.Message.Model
+			c.Usage = event.Message.Usage.toUsage()
+		}
+	case "message_delta":
+		if event.Delta.StopReason != "" {
+			c.FinishReason = finishReason(event.Delta.StopReason)
+		}
+		if event.Usage != nil {
+			c.Usage.CompletionTokens = event.Usage.OutputTokens
+			c.Usage.TotalTokens = c.Usage.PromptTokens + c.Usage.CompletionTokens
+		}
+	case "message_stop":
+		return "", errStreamDone
+	case "content_block_delta":
+		if event.Delta.Type == "text_delta" {
+			return event.Delta.Text, nil
+		}
+	}
+	return "", nil
+}
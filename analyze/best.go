@@ -0,0 +1,36 @@
+package analyze
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pickBestInstructions asks the model to choose between candidate analyses.
+const pickBestInstructions = `Below are several candidate analyses of the same CockroachDB statement bundle. Choose the one with the most specific, actionable advice, such as concrete indexes or query rewrites that address the slowest operations in the plan. Reply with only the number of the best candidate.
+`
+
+// candidateNumberRE matches the number in the reply to pickBestInstructions.
+var candidateNumberRE = regexp.MustCompile(`\d+`)
+
+// PickBest asks the model to choose the most actionable of the candidate
+// analyses, returning the index of the chosen candidate and the completion of
+// the request, whose usage is in addition to that of the candidates.
+func PickBest(ctx context.Context, a Analyzer, candidates []string) (int, *Completion, error) {
+	var buf strings.Builder
+	buf.WriteString(pickBestInstructions)
+	for i, c := range candidates {
+		fmt.Fprintf(&buf, "\nCandidate %d:\n```\n%s\n```\n", i+1, strings.TrimSpace(c))
+	}
+	comp, err := a.Chat(ctx, []Message{{Role: RoleUser, Content: buf.String()}})
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := strconv.Atoi(candidateNumberRE.FindString(comp.Content))
+	if err != nil || n < 1 || n > len(candidates) {
+		return 0, comp, fmt.Errorf("model did not choose a candidate between 1 and %d: %q", len(candidates), comp.Content)
+	}
+	return n - 1, comp, nil
+}
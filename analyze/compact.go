@@ -0,0 +1,71 @@
+package analyze
+
+import "strings"
+
+// compactWhitespace trims trailing whitespace from each line of s and
+// collapses runs of blank lines into a single blank line. Quoted strings and
+// identifiers are copied unchanged, so that whitespace inside a SQL literal
+// that spans lines is preserved.
+func compactWhitespace(s string) string {
+	var buf strings.Builder
+	buf.Grow(len(s))
+	// space holds the whitespace seen since the last non-space character,
+	// which is dropped if it turns out to be at the end of a line.
+	space := 0
+	spaceStart := 0
+	// newlines is the number of newlines seen since the last non-space
+	// character.
+	newlines := 0
+	inComment := false
+	flush := func() {
+		if newlines > 0 {
+			buf.WriteString("\n\n"[:min(newlines, 2)])
+			newlines = 0
+		}
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\n':
+			space = 0
+			newlines++
+			inComment = false
+			i++
+			continue
+		case c == ' ' || c == '\t' || c == '\r':
+			if space == 0 {
+				spaceStart = i
+			}
+			space++
+			i++
+			continue
+		}
+
+		flush()
+		if space > 0 {
+			buf.WriteString(s[spaceStart : spaceStart+space])
+			space = 0
+		}
+		switch {
+		case inComment:
+			buf.WriteByte(c)
+			i++
+		case c == '-' && strings.HasPrefix(s[i:], "--"):
+			// Apostrophes in line comments do not start string literals.
+			inComment = true
+			buf.WriteByte(c)
+			i++
+		case c == '\'' || c == '"':
+			end := skipQuoted(s, i, c, false)
+			buf.WriteString(s[i:end])
+			i = end
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	if newlines > 0 {
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
@@ -0,0 +1,88 @@
+package analyze
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// CompareInstructions is the prompt for explaining the regression between two
+// bundles of the same statement, used by BuildComparePrompt.
+const CompareInstructions = `You are a CockroachDB expert. The following
+		files are from two statement bundles for the same query, one collected
+		before a performance regression and one after. Explain the difference
+		in performance between them and what caused the regression. Attribute
+		each change to the schema, the table statistics, or the shape of the
+		plan, and label it as such. Include only the list not any summary text
+		beforehand.
+	`
+
+// compareFileNames returns the names of the files in either bundle to include
+// in a comparison, in order. Table statistics are included so that the model
+// can attribute changes to them.
+func compareFileNames(before, after map[string]string, opts PromptOptions) []string {
+	all := make(map[string]string, len(before)+len(after))
+	for _, files := range []map[string]string{before, after} {
+		for name, content := range files {
+			all[name] = content
+		}
+	}
+	names := promptFileNames(all, opts)
+	var stats []string
+	seen := make(map[string]bool)
+	for _, files := range []map[string]string{before, after} {
+		for name := range files {
+			if strings.HasPrefix(name, "stats-") && !seen[name] && !slices.Contains(names, name) && !matchesAny(opts.Exclude, name) {
+				seen[name] = true
+				stats = append(stats, name)
+			}
+		}
+	}
+	sort.Strings(stats)
+	return append(names[:len(names):len(names)], stats...)
+}
+
+// BuildComparePrompt returns the prompt for explaining the regression between
+// the files of the before and after bundles, keyed by name. Each file is
+// presented from both bundles side by side, followed by a diff of the two
+// plans.
+func BuildComparePrompt(instructions string, before, after map[string]string, opts PromptOptions) string {
+	names := compareFileNames(before, after, opts)
+	contents := make(map[string]string)
+	for _, name := range names {
+		for _, side := range []struct {
+			label string
+			files map[string]string
+		}{{"before", before}, {"after", after}} {
+			if content, ok := side.files[name]; ok {
+				content = prepareFile(name, content, opts)
+				if name == "schema.sql" && opts.RelevantSchema {
+					content = relevantSchema(content, side.files, opts)
+				}
+				contents[side.label+"/"+name] = content
+			}
+		}
+	}
+	planDiff := lineDiff(before["plan.txt"], after["plan.txt"])
+	if opts.MaxTokens > 0 {
+		opts.reportTruncation(truncateToBudget(contents, opts.MaxTokens-EstimateTokens(instructions)-EstimateTokens(planDiff)))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(instructions)
+	for _, name := range names {
+		for _, label := range []string{"before", "after"} {
+			if content, ok := contents[label+"/"+name]; ok {
+				writeSectionHeader(&buf, label+"/"+name)
+				writeContent(&buf, content)
+			}
+		}
+	}
+	if planDiff != "" {
+		writeSectionHeader(&buf, "plan.txt diff (before -> after)")
+		writeContent(&buf, planDiff)
+	}
+	writeNotes(&buf, opts.Notes)
+	return buf.String()
+}
@@ -0,0 +1,80 @@
+package analyze
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mgartner/bundlebot/schema"
+)
+
+// IndexInstructions is appended to the prompt to ask the model to write each
+// missing index as a statement that ParseIndexSuggestions can extract.
+const IndexInstructions = `
+		Write each missing index as a single CockroachDB CREATE INDEX
+		statement, using the table and column names from schema.sql, for
+		example: CREATE INDEX ON users (last_name, created_at DESC);
+	`
+
+// createIndexRE matches a CREATE INDEX statement, capturing the table name
+// and the list of indexed columns.
+var createIndexRE = regexp.MustCompile(
+	`(?i)\bCREATE\s+(?:UNIQUE\s+|INVERTED\s+)?INDEX\s+(?:CONCURRENTLY\s+)?` +
+		`(?:IF\s+NOT\s+EXISTS\s+)?(?:[\w"]+\s+)?ON\s+([\w."]+)\s*(?:USING\s+\w+\s*)?` +
+		`\(([^)]*)\)(?:\s*STORING\s*\([^)]*\))?(?:\s*WHERE\s+[^;\n"]+)?`,
+)
+
+// IndexSuggestion is a CREATE INDEX statement suggested by the model.
+type IndexSuggestion struct {
+	// Statement is the statement, terminated by a semicolon.
+	Statement string
+	Table     string
+	// Columns are the names of the indexed columns, without their direction.
+	Columns []string
+}
+
+// ParseIndexSuggestions returns the distinct CREATE INDEX statements in the
+// model's reply, in the order they appear.
+func ParseIndexSuggestions(reply string) []IndexSuggestion {
+	var suggestions []IndexSuggestion
+	seen := make(map[string]bool)
+	for _, m := range createIndexRE.FindAllStringSubmatch(reply, -1) {
+		stmt := strings.Join(strings.Fields(m[0]), " ") + ";"
+		if seen[stmt] {
+			continue
+		}
+		seen[stmt] = true
+
+		var cols []string
+		for _, col := range strings.Split(m[2], ",") {
+			if fields := strings.Fields(col); len(fields) > 0 {
+				cols = append(cols, strings.ReplaceAll(fields[0], `"`, ""))
+			}
+		}
+		suggestions = append(suggestions, IndexSuggestion{
+			Statement: stmt,
+			Table:     strings.ReplaceAll(m[1], `"`, ""),
+			Columns:   cols,
+		})
+	}
+	return suggestions
+}
+
+// Validate returns an error if the suggested index's table or any of its
+// columns are not defined in sch.
+func (s IndexSuggestion) Validate(sch *schema.Schema) error {
+	t := sch.Table(s.Table)
+	if t == nil {
+		return fmt.Errorf("table %s not found in schema.sql", s.Table)
+	}
+	var missing []string
+	for _, col := range s.Columns {
+		if !t.HasColumn(col) {
+			missing = append(missing, col)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("table %s has no column %s", s.Table, strings.Join(missing, ", "))
+	}
+	return nil
+}
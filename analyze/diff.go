@@ -0,0 +1,49 @@
+package analyze
+
+import "strings"
+
+// lineDiff returns a line-by-line diff of a and b, computed from their longest
+// common subsequence of lines. Removed lines are prefixed with "-", added
+// lines with "+", and unchanged lines with " ". It returns "" if a and b are
+// the same.
+func lineDiff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and
+	// y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var buf strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			buf.WriteString(" " + x[i] + "\n")
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			buf.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			buf.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+	return buf.String()
+}
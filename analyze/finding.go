@@ -0,0 +1,82 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Confidence is the model's rating of how confident it is that a finding is
+// relevant to the statement's performance.
+type Confidence string
+
+// Confidence levels, from least to most confident.
+const (
+	ConfidenceLow    Confidence = "low"
+	ConfidenceMedium Confidence = "medium"
+	ConfidenceHigh   Confidence = "high"
+)
+
+// confidenceLevels are the valid Confidence levels, from least to most
+// confident.
+var confidenceLevels = []Confidence{ConfidenceLow, ConfidenceMedium, ConfidenceHigh}
+
+// ParseConfidence returns the Confidence level named by s.
+func ParseConfidence(s string) (Confidence, error) {
+	if c := Confidence(s); slices.Contains(confidenceLevels, c) {
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown confidence %q, expected low, medium, or high", s)
+}
+
+// AtLeast returns true if c is at least as confident as min. A finding that
+// wasn't rated is treated as low confidence.
+func (c Confidence) AtLeast(min Confidence) bool {
+	return max(slices.Index(confidenceLevels, c), 0) >= slices.Index(confidenceLevels, min)
+}
+
+// Finding is a single finding of an Analysis.
+type Finding struct {
+	Text string `json:"finding"`
+	// Confidence is "" if the finding wasn't rated.
+	Confidence Confidence `json:"confidence,omitempty"`
+	// Rationale and ExpectedImpact explain why the finding matters and what
+	// addressing it would gain, if the model was asked with
+	// ExplainJSONInstructions.
+	Rationale      string `json:"rationale,omitempty"`
+	ExpectedImpact string `json:"expected_impact,omitempty"`
+}
+
+// String returns the finding's text, followed by its confidence if it was
+// rated.
+func (f Finding) String() string {
+	if f.Confidence == "" {
+		return f.Text
+	}
+	return fmt.Sprintf("%s (%s confidence)", f.Text, f.Confidence)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. A finding may also
+// be a bare string, as written by models that ignore the instructions to rate
+// their findings and by earlier versions of the JSON instructions, in which
+// case it is unrated.
+func (f *Finding) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*f = Finding{Text: text}
+		return nil
+	}
+	// finding has the same fields as Finding without its UnmarshalJSON
+	// method.
+	type finding Finding
+	return json.Unmarshal(data, (*finding)(f))
+}
+
+// FilterConfidence removes the findings in a that are less confident than min.
+func (a *Analysis) FilterConfidence(min Confidence) {
+	for _, findings := range []*[]Finding{&a.SlowestOperations, &a.SchemaAntipatterns, &a.QueryAntipatterns, &a.MissingIndexes, &a.RedundantIndexes} {
+		*findings = slices.DeleteFunc(*findings, func(f Finding) bool {
+			return !f.Confidence.AtLeast(min)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package analyze
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFilterConfidence(t *testing.T) {
+	// Bare strings are accepted as unrated findings.
+	a, err := ParseAnalysis(`{
+		"slowest_operations": [{"finding": "scan", "confidence": "high"}],
+		"schema_antipatterns": [{"finding": "no primary key", "confidence": "medium"}],
+		"query_antipatterns": [{"finding": "SELECT *", "confidence": "low"}, "unrated"],
+		"missing_indexes": [{"finding": "CREATE INDEX ON t (a);", "confidence": "high"}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts := func(findings []Finding) []string {
+		var s []string
+		for _, f := range findings {
+			s = append(s, f.Text)
+		}
+		return s
+	}
+
+	a.FilterConfidence(ConfidenceLow)
+	if got := texts(a.QueryAntipatterns); !slices.Equal(got, []string{"SELECT *", "unrated"}) {
+		t.Errorf("after filtering low, query anti-patterns = %q", got)
+	}
+	a.FilterConfidence(ConfidenceMedium)
+	if got := texts(a.QueryAntipatterns); len(got) != 0 {
+		t.Errorf("after filtering medium, query anti-patterns = %q, want none", got)
+	}
+	if got := a.Findings(); got != 2 {
+		t.Errorf("after filtering medium, Findings() = %d, want 2", got)
+	}
+	a.FilterConfidence(ConfidenceHigh)
+	if got := texts(a.SchemaAntipatterns); len(got) != 0 {
+		t.Errorf("after filtering high, schema anti-patterns = %q, want none", got)
+	}
+	if got := texts(a.MissingIndexes); !slices.Equal(got, []string{"CREATE INDEX ON t (a);"}) {
+		t.Errorf("after filtering high, missing indexes = %q", got)
+	}
+}
+
+func TestExplainedFindings(t *testing.T) {
+	a, err := ValidateAnalysis(`{
+		"slowest_operations": [],
+		"schema_antipatterns": [],
+		"query_antipatterns": [{"finding": "SELECT *", "confidence": "low", "rationale": "It reads unused columns.", "expected_impact": "Less data read."}],
+		"missing_indexes": [{"finding": "CREATE INDEX ON t (a);", "confidence": "high"}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Query anti-patterns:\n" +
+		"- SELECT * (low confidence)\n" +
+		"  Why: It reads unused columns.\n" +
+		"  Expected impact: Less data read.\n" +
+		"\nMissing indexes:\n" +
+		"- CREATE INDEX ON t (a); (high confidence)\n"
+	if got := a.Text(); !strings.HasSuffix(got, want) {
+		t.Errorf("Text() = %q, want it to end with %q", got, want)
+	}
+}
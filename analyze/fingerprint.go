@@ -0,0 +1,114 @@
+package analyze
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// fingerprintLiteral replaces each literal and placeholder in a normalized
+// statement.
+const fingerprintLiteral = "_"
+
+// literalListRE matches a comma-separated list of replaced literals, such as
+// the values of an IN list.
+var literalListRE = regexp.MustCompile(`\b_(?:,_)+\b`)
+
+// Fingerprint returns a stable identifier of the statement sql: the first 16
+// hex digits of the SHA-256 hash of NormalizeStatement(sql). Statements that
+// differ only in their literals, placeholders, comments, whitespace, or the
+// case of their keywords share a fingerprint.
+func Fingerprint(sql string) string {
+	sum := sha256.Sum256([]byte(NormalizeStatement(sql)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NormalizeStatement returns the statement sql normalized for Fingerprint:
+//
+//   - Comments are removed.
+//   - String and numeric literals and placeholders such as $1 are replaced
+//     with _, and a comma-separated list of them, such as the values of an IN
+//     list, is replaced with a single _.
+//   - Keywords and unquoted identifiers are lowercased. Quoted identifiers
+//     are unchanged.
+//   - Whitespace is removed, except for a single space between two words.
+//   - Trailing semicolons are removed.
+func NormalizeStatement(sql string) string {
+	var buf strings.Builder
+	space := false
+	// write appends the token s, separated from the previous token by a
+	// space if both are words.
+	write := func(s string) {
+		if space && buf.Len() > 0 && isIdentChar(buf.String()[buf.Len()-1]) && isIdentChar(s[0]) {
+			buf.WriteByte(' ')
+		}
+		space = false
+		buf.WriteString(s)
+	}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+
+		case c == '\'':
+			i = skipQuoted(sql, i, '\'', false)
+			write(fingerprintLiteral)
+
+		case c == '"':
+			end := skipQuoted(sql, i, '"', false)
+			write(sql[i:end])
+			i = end
+
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			i += end
+			space = true
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql)
+			} else {
+				end += i + 4
+			}
+			i = end
+			space = true
+
+		case c == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			i++
+			for i < len(sql) && isDigit(sql[i]) {
+				i++
+			}
+			write(fingerprintLiteral)
+
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(sql) && isIdentChar(sql[end]) {
+				end++
+			}
+			if end == i+1 && end < len(sql) && sql[end] == '\'' && strings.ContainsRune("eEbBxX", rune(c)) {
+				i = skipQuoted(sql, end, '\'', c == 'e' || c == 'E')
+				write(fingerprintLiteral)
+				continue
+			}
+			write(strings.ToLower(sql[i:end]))
+			i = end
+
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			i = skipNumber(sql, i)
+			write(fingerprintLiteral)
+
+		default:
+			write(string(c))
+			i++
+		}
+	}
+	normalized := literalListRE.ReplaceAllString(buf.String(), fingerprintLiteral)
+	return strings.TrimRight(normalized, ";")
+}
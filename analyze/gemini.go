@@ -0,0 +1,232 @@
+package analyze
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultGeminiEndpoint is the base URL of the Generative Language API.
+	// Requests are sent to the model's generateContent method under it.
+	DefaultGeminiEndpoint = "https://generativelanguage.googleapis.com/v1beta"
+	DefaultGeminiModel    = "gemini-2.5-flash"
+	// geminiRoleModel is the role of the assistant's turns in Gemini's
+	// contents.
+	geminiRoleModel = "model"
+)
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent        `json:"contents"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+// geminiContent is a single turn in a conversation with Gemini.
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	ResponseMIMEType string   `json:"responseMimeType,omitempty"`
+}
+
+// geminiResponse is the response to a generateContent request, and each event
+// of a streamed response.
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *geminiUsage `json:"usageMetadata"`
+	ModelVersion  string       `json:"modelVersion"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// toUsage converts u to the common Usage type.
+func (u geminiUsage) toUsage() Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokenCount,
+		CompletionTokens: u.CandidatesTokenCount,
+		TotalTokens:      u.TotalTokenCount,
+	}
+}
+
+// text returns the text of the first candidate of the response.
+func (r *geminiResponse) text() string {
+	if len(r.Candidates) == 0 {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range r.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String()
+}
+
+// update records the model, finish reason, and usage reported by r in c.
+func (r *geminiResponse) update(c *Completion) {
+	if r.ModelVersion != "" {
+		c.Model = r.ModelVersion
+	}
+	if len(r.Candidates) > 0 && r.Candidates[0].FinishReason != "" {
+		c.FinishReason = geminiFinishReason(r.Candidates[0].FinishReason)
+	}
+	if r.UsageMetadata != nil {
+		c.Usage = r.UsageMetadata.toUsage()
+	}
+}
+
+// geminiClient is an Analyzer backed by the Gemini API.
+type geminiClient struct {
+	opts Options
+}
+
+var _ Analyzer = (*geminiClient)(nil)
+
+// Model implements the Analyzer interface.
+func (c *geminiClient) Model() string {
+	return c.opts.Model
+}
+
+// Analyze implements the Analyzer interface.
+func (c *geminiClient) Analyze(ctx context.Context, prompt string) (*Completion, error) {
+	return c.Chat(ctx, []Message{{Role: RoleUser, Content: prompt}})
+}
+
+// Chat implements the Analyzer interface.
+func (c *geminiClient) Chat(ctx context.Context, messages []Message) (*Completion, error) {
+	apiKey := c.opts.APIKey
+	if apiKey == "" {
+		apiKey = os.Getenv("GEMINI_API_KEY")
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("GEMINI_API_KEY not set")
+	}
+
+	reqBody := geminiRequest{
+		SystemInstruction: &geminiContent{Parts: []geminiPart{{Text: c.opts.SystemPrompt}}},
+		GenerationConfig: geminiGenerationConfig{
+			Temperature:     c.opts.Temperature,
+			MaxOutputTokens: max(c.opts.MaxTokens, 0),
+			Seed:            c.opts.Seed,
+		},
+	}
+	for _, m := range messages {
+		role := m.Role
+		if role == RoleAssistant {
+			role = geminiRoleModel
+		}
+		reqBody.Contents = append(reqBody.Contents, geminiContent{Role: role, Parts: []geminiPart{{Text: m.Content}}})
+	}
+	if c.opts.JSONMode {
+		reqBody.GenerationConfig.ResponseMIMEType = "application/json"
+	}
+
+	jsonBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	comp, err := withRetries(ctx, c.opts.Retries, func() (*Completion, error) {
+		return c.doRequest(ctx, apiKey, jsonBody)
+	})
+	if comp != nil && comp.Model == "" {
+		comp.Model = c.opts.Model
+	}
+	return comp, err
+}
+
+// doRequest makes a single generateContent request with the given JSON body,
+// or a streamGenerateContent request if the response is streamed.
+func (c *geminiClient) doRequest(ctx context.Context, apiKey string, jsonBody []byte) (*Completion, error) {
+	method := "generateContent"
+	if c.opts.Stream != nil {
+		method = "streamGenerateContent?alt=sse"
+	}
+	url := fmt.Sprintf("%s/models/%s:%s", strings.TrimSuffix(c.opts.Endpoint, "/"), c.opts.Model, method)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-goog-api-key", apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	c.opts.logf("POST %s (model %s)", url, c.opts.Model)
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	c.opts.logf("HTTP status %s", resp.Status)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
+	}
+
+	if c.opts.Stream != nil {
+		return readStream(resp.Body, c.opts.Stream, decodeGeminiStream)
+	}
+
+	var genResp geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&genResp); err != nil {
+		return nil, err
+	}
+	if len(genResp.Candidates) == 0 {
+		if genResp.PromptFeedback != nil && genResp.PromptFeedback.BlockReason != "" {
+			return nil, fmt.Errorf("API blocked the prompt (block_reason: %s)", genResp.PromptFeedback.BlockReason)
+		}
+		return nil, fmt.Errorf("API returned no candidates (possibly content-filtered)")
+	}
+	var comp Completion
+	genResp.update(&comp)
+	comp.Content = genResp.text()
+	return &comp, nil
+}
+
+// geminiFinishReason translates a Gemini finishReason to a FinishReason.
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "STOP":
+		return FinishStop
+	case "MAX_TOKENS":
+		return FinishLength
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return FinishContentFilter
+	default:
+		return strings.ToLower(reason)
+	}
+}
+
+// decodeGeminiStream is the streamDecoder for Gemini streamed responses. Each
+// event is a partial response, and the stream ends when the connection is
+// closed.
+func decodeGeminiStream(data string, c *Completion) (string, error) {
+	var event geminiResponse
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return "", err
+	}
+	event.update(c)
+	return event.text(), nil
+}
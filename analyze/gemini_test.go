@@ -0,0 +1,82 @@
+package analyze
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestGeminiClient returns a Gemini Analyzer that sends its requests to a
+// stub server with the given handler.
+func newTestGeminiClient(t *testing.T, handler http.HandlerFunc) Analyzer {
+	t.Helper()
+	t.Setenv("GEMINI_API_KEY", "gemini-test-key")
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	a, err := New(ProviderGemini, Options{Endpoint: srv.URL, HTTPClient: srv.Client(), JSONMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestGeminiChat(t *testing.T) {
+	var req geminiRequest
+	a := newTestGeminiClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models/"+DefaultGeminiModel+":generateContent" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if got := r.Header.Get("x-goog-api-key"); got != "gemini-test-key" {
+			t.Errorf("x-goog-api-key header = %q", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		writeJSON(w, http.StatusOK, `{
+			"candidates": [{"content": {"role": "model", "parts": [{"text": "Add "}, {"text": "an index."}]}, "finishReason": "MAX_TOKENS"}],
+			"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 3, "totalTokenCount": 13},
+			"modelVersion": "gemini-2.5-flash-001"
+		}`)
+	})
+
+	comp, err := a.Chat(context.Background(), []Message{
+		{Role: RoleUser, Content: "analyze this"},
+		{Role: RoleAssistant, Content: "{}"},
+		{Role: RoleUser, Content: "why?"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comp.Content != "Add an index." || comp.Model != "gemini-2.5-flash-001" || comp.FinishReason != FinishLength {
+		t.Errorf("Completion = %+v", comp)
+	}
+	if comp.Usage != (Usage{PromptTokens: 10, CompletionTokens: 3, TotalTokens: 13}) {
+		t.Errorf("Usage = %+v", comp.Usage)
+	}
+	if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != DefaultSystemPrompt {
+		t.Errorf("SystemInstruction = %+v", req.SystemInstruction)
+	}
+	var roles []string
+	for _, c := range req.Contents {
+		roles = append(roles, c.Role)
+	}
+	if strings.Join(roles, ",") != "user,model,user" || req.Contents[0].Parts[0].Text != "analyze this" {
+		t.Errorf("Contents = %+v", req.Contents)
+	}
+	if req.GenerationConfig.ResponseMIMEType != "application/json" {
+		t.Errorf("ResponseMIMEType = %q", req.GenerationConfig.ResponseMIMEType)
+	}
+}
+
+func TestGeminiErrorStatus(t *testing.T) {
+	a := newTestGeminiClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusBadRequest, `{"error": {"code": 400, "message": "API key not valid.", "status": "INVALID_ARGUMENT"}}`)
+	})
+	_, err := a.Analyze(context.Background(), "analyze this")
+	if err == nil || err.Error() != "API error (INVALID_ARGUMENT): API key not valid." {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// AnalysisSchema is the JSON schema that the model's structured reply must
+// conform to with ValidateAnalysis.
+const AnalysisSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Analysis",
+  "type": "object",
+  "properties": {
+    "slowest_operations": {"type": "array", "items": {"$ref": "#/$defs/finding"}},
+    "schema_antipatterns": {"type": "array", "items": {"$ref": "#/$defs/finding"}},
+    "query_antipatterns": {"type": "array", "items": {"$ref": "#/$defs/finding"}},
+    "missing_indexes": {"type": "array", "items": {"$ref": "#/$defs/finding"}}
+  },
+  "required": ["slowest_operations", "schema_antipatterns", "query_antipatterns", "missing_indexes"],
+  "additionalProperties": false,
+  "$defs": {
+    "finding": {
+      "type": "object",
+      "properties": {
+        "finding": {"type": "string", "minLength": 1},
+        "confidence": {"type": "string", "enum": ["high", "medium", "low"]},
+        "rationale": {"type": "string"},
+        "expected_impact": {"type": "string"}
+      },
+      "required": ["finding", "confidence"],
+      "additionalProperties": false
+    }
+  }
+}`
+
+// jsonSchema is the subset of JSON schema needed to describe an Analysis.
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinLength            int                    `json:"minLength"`
+	Enum                 []string               `json:"enum"`
+	Ref                  string                 `json:"$ref"`
+	Defs                 map[string]*jsonSchema `json:"$defs"`
+}
+
+// analysisSchema is AnalysisSchema, parsed.
+var analysisSchema = mustParseSchema(AnalysisSchema)
+
+func mustParseSchema(s string) *jsonSchema {
+	var schema jsonSchema
+	if err := json.Unmarshal([]byte(s), &schema); err != nil {
+		panic(err)
+	}
+	return &schema
+}
+
+// validate returns an error describing the first part of v, found at path,
+// that does not conform to s. References are resolved in the $defs of root.
+func (s *jsonSchema) validate(root *jsonSchema, v any, path string) error {
+	if name, ok := strings.CutPrefix(s.Ref, "#/$defs/"); ok {
+		def, ok := root.Defs[name]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema reference %q", path, s.Ref)
+		}
+		s = def
+	}
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %s", path, jsonType(v))
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected field %q", path, name)
+				}
+				continue
+			}
+			if err := prop.validate(root, obj[name], path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %s", path, jsonType(v))
+		}
+		if s.Items != nil {
+			for i, elem := range arr {
+				if err := s.Items.validate(root, elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %s", path, jsonType(v))
+		}
+		if len(strings.TrimSpace(str)) < s.MinLength {
+			return fmt.Errorf("%s: expected a non-empty string", path)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			return fmt.Errorf("%s: expected one of %s, got %q", path, strings.Join(s.Enum, ", "), str)
+		}
+	}
+	return nil
+}
+
+// jsonType returns the JSON type of a value decoded by encoding/json.
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case json.Number, float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// ValidateAnalysis parses the model's structured reply like ParseAnalysis, but
+// also returns an error if the reply does not conform to AnalysisSchema, such
+// as when a field is missing or null.
+func ValidateAnalysis(reply string) (*Analysis, error) {
+	obj, err := extractObject(reply)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	dec := json.NewDecoder(strings.NewReader(obj))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("model replied with malformed JSON: %v", err)
+	}
+	if err := analysisSchema.validate(analysisSchema, v, "$"); err != nil {
+		return nil, fmt.Errorf("reply does not conform to the JSON schema: %v", err)
+	}
+	return ParseAnalysis(obj)
+}
+
+// SchemaCorrection returns the message asking the model to correct a reply
+// that ValidateAnalysis rejected with err.
+func SchemaCorrection(err error) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Your reply was rejected: %v.\n", err)
+	buf.WriteString("Reply again with only a JSON object, and no other text, that conforms to this JSON schema:\n")
+	buf.WriteString(AnalysisSchema)
+	buf.WriteByte('\n')
+	return buf.String()
+}
@@ -0,0 +1,66 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAnalysis(t *testing.T) {
+	for _, tc := range []struct {
+		reply string
+		// wantErr is a substring of the expected error, or "" if the reply
+		// is valid.
+		wantErr string
+	}{
+		{
+			reply: `{"slowest_operations": [{"finding": "full scan", "confidence": "high"}], "schema_antipatterns": [], "query_antipatterns": [], "missing_indexes": []}`,
+		},
+		{
+			reply: "```json\n" + `{"slowest_operations": [], "schema_antipatterns": [], "query_antipatterns": [], "missing_indexes": [{"finding": "CREATE INDEX ON t (a);", "confidence": "low"}]}` + "\n```",
+		},
+		{
+			reply:   `{"slowest_operations": [], "schema_antipatterns": [], "query_antipatterns": []}`,
+			wantErr: `$: missing required field "missing_indexes"`,
+		},
+		{
+			reply:   `{"slowest_operations": [], "schema_antipatterns": null, "query_antipatterns": [], "missing_indexes": []}`,
+			wantErr: "$.schema_antipatterns: expected an array, got null",
+		},
+		{
+			reply:   `{"slowest_operations": ["full scan"], "schema_antipatterns": [], "query_antipatterns": [], "missing_indexes": []}`,
+			wantErr: "$.slowest_operations[0]: expected an object, got a string",
+		},
+		{
+			reply:   `{"slowest_operations": [{"finding": 1, "confidence": "high"}], "schema_antipatterns": [], "query_antipatterns": [], "missing_indexes": []}`,
+			wantErr: "$.slowest_operations[0].finding: expected a string, got a number",
+		},
+		{
+			reply:   `{"slowest_operations": [], "schema_antipatterns": [], "query_antipatterns": [{"finding": "ok", "confidence": "high"}, {"finding": " ", "confidence": "high"}], "missing_indexes": []}`,
+			wantErr: "$.query_antipatterns[1].finding: expected a non-empty string",
+		},
+		{
+			reply:   `{"slowest_operations": [], "schema_antipatterns": [{"finding": "ok"}], "query_antipatterns": [], "missing_indexes": []}`,
+			wantErr: `$.schema_antipatterns[0]: missing required field "confidence"`,
+		},
+		{
+			reply:   `{"slowest_operations": [], "schema_antipatterns": [{"finding": "ok", "confidence": "certain"}], "query_antipatterns": [], "missing_indexes": []}`,
+			wantErr: `$.schema_antipatterns[0].confidence: expected one of high, medium, low, got "certain"`,
+		},
+		{
+			reply:   `{"slowest_operations": [], "schema_antipatterns": [], "query_antipatterns": [], "missing_indexes": [], "summary": "x"}`,
+			wantErr: `$: unexpected field "summary"`,
+		},
+		{
+			reply:   "No issues found.",
+			wantErr: "did not reply with a JSON object",
+		},
+	} {
+		_, err := ValidateAnalysis(tc.reply)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("ValidateAnalysis(%q) returned error: %v", tc.reply, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("ValidateAnalysis(%q) = %v, want error containing %q", tc.reply, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+)
+
+// languages are the English names of the languages that an analysis may be
+// requested in, keyed by their ISO 639-1 code.
+var languages = map[string]string{
+	"ar": "Arabic",
+	"de": "German",
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"hi": "Hindi",
+	"id": "Indonesian",
+	"it": "Italian",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"nl": "Dutch",
+	"pl": "Polish",
+	"pt": "Portuguese",
+	"ru": "Russian",
+	"sv": "Swedish",
+	"tr": "Turkish",
+	"uk": "Ukrainian",
+	"vi": "Vietnamese",
+	"zh": "Chinese",
+}
+
+// LanguageInstruction returns the instruction appended to the system prompt to
+// ask for the analysis in the language with the ISO 639-1 code, such as "fr"
+// or "pt-BR", whose region is ignored. It returns "" for English, which is
+// the default. SQL, identifiers, and JSON field names are kept as they are,
+// so that replies can still be parsed.
+func LanguageInstruction(code string) (string, error) {
+	base, _, _ := strings.Cut(strings.ToLower(code), "-")
+	base, _, _ = strings.Cut(base, "_")
+	name, ok := languages[base]
+	if !ok {
+		return "", fmt.Errorf("unknown language code %q, expected an ISO 639-1 code such as fr or de", code)
+	}
+	if base == "en" {
+		return "", nil
+	}
+	return fmt.Sprintf("Respond in %s. Keep SQL statements, table and column names, "+
+		"and JSON field names and confidence values unchanged, in English.", name), nil
+}
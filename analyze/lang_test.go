@@ -0,0 +1,44 @@
+package analyze
+
+import "testing"
+
+func TestLanguageInstruction(t *testing.T) {
+	for _, tc := range []struct {
+		code, want string
+		wantErr    bool
+	}{
+		{code: "en", want: ""},
+		{code: "EN-us", want: ""},
+		{code: "fr", want: "Respond in French. Keep SQL statements, table and column names, and JSON field names and confidence values unchanged, in English."},
+		{code: "pt-BR", want: "Respond in Portuguese. Keep SQL statements, table and column names, and JSON field names and confidence values unchanged, in English."},
+		{code: "zh_TW", want: "Respond in Chinese. Keep SQL statements, table and column names, and JSON field names and confidence values unchanged, in English."},
+		{code: "french", wantErr: true},
+		{code: "", wantErr: true},
+	} {
+		got, err := LanguageInstruction(tc.code)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("LanguageInstruction(%q) = %q, %v", tc.code, got, err)
+		}
+	}
+}
+
+// TestParseNonEnglishReply checks that replies in another language, which keep
+// the JSON field names and SQL in English as instructed, are still parsed.
+func TestParseNonEnglishReply(t *testing.T) {
+	a, err := ValidateAnalysis(`{
+		"slowest_operations": [{"finding": "Le balayage complet de la table users est lent.", "confidence": "high"}],
+		"schema_antipatterns": [],
+		"query_antipatterns": [{"finding": "Évitez SELECT *.", "confidence": "medium"}],
+		"missing_indexes": [{"finding": "Ajoutez CREATE INDEX ON users (last_name, created_at DESC); pour éviter le tri.", "confidence": "high"}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.SlowestOperations) != 1 || a.QueryAntipatterns[0].Confidence != ConfidenceMedium {
+		t.Errorf("Analysis = %+v", a)
+	}
+	got := ParseIndexSuggestions(a.MissingIndexes[0].Text)
+	if len(got) != 1 || got[0].Statement != "CREATE INDEX ON users (last_name, created_at DESC);" {
+		t.Errorf("ParseIndexSuggestions = %+v", got)
+	}
+}
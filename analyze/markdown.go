@@ -0,0 +1,61 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Markdown returns a as Markdown, with a collapsible list of findings under a
+// heading for each field. The suggested CREATE INDEX statements are repeated
+// in a fenced SQL code block so they can be copied as-is. The statement's
+// fingerprint and the one-line summary, if known, precede the headings.
+func (a *Analysis) Markdown() string {
+	var buf strings.Builder
+	if a.Fingerprint != "" {
+		fmt.Fprintf(&buf, "Fingerprint: `%s`\n\n", a.Fingerprint)
+	}
+	if e := a.execution(); e.Known() {
+		fmt.Fprintf(&buf, "Execution: %s\n\n", e)
+	}
+	if a.Summary != "" {
+		fmt.Fprintf(&buf, "**Summary:** %s\n\n", a.Summary)
+	}
+	for i, section := range a.sections() {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "## %s\n\n", section.heading)
+		if len(section.findings) == 0 {
+			buf.WriteString("_None found._\n")
+			continue
+		}
+		summary := "1 finding"
+		if len(section.findings) > 1 {
+			summary = fmt.Sprintf("%d findings", len(section.findings))
+		}
+		fmt.Fprintf(&buf, "<details open>\n<summary>%s</summary>\n\n", summary)
+		for _, f := range section.findings {
+			fmt.Fprintf(&buf, "- %s\n", f)
+			if f.Rationale != "" {
+				fmt.Fprintf(&buf, "  - _Why:_ %s\n", f.Rationale)
+			}
+			if f.ExpectedImpact != "" {
+				fmt.Fprintf(&buf, "  - _Expected impact:_ %s\n", f.ExpectedImpact)
+			}
+		}
+		buf.WriteString("\n</details>\n")
+	}
+
+	var missingIndexes strings.Builder
+	for _, f := range a.MissingIndexes {
+		fmt.Fprintf(&missingIndexes, "%s\n", f.Text)
+	}
+	if suggestions := ParseIndexSuggestions(missingIndexes.String()); len(suggestions) > 0 {
+		buf.WriteString("\n## Suggested Indexes\n\n```sql\n")
+		for _, s := range suggestions {
+			fmt.Fprintf(&buf, "%s\n", s.Statement)
+		}
+		buf.WriteString("```\n")
+	}
+	return buf.String()
+}
@@ -0,0 +1,498 @@
+package analyze
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	DefaultOpenAIEndpoint = "https://api.openai.com/v1/chat/completions"
+	// chatCompletionsPath is appended to OPENAI_BASE_URL to form the
+	// endpoint.
+	chatCompletionsPath = "/chat/completions"
+	DefaultOpenAIModel  = "gpt-4"
+)
+
+type request struct {
+	Model         string         `json:"model"`
+	Messages      []Message      `json:"messages"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	N             int            `json:"n,omitempty"`
+	Seed          *int64         `json:"seed,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+	// ResponseFormat constrains the format of the reply. It is only set in
+	// JSON mode, since not every model supports it.
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+	// Tools and ToolChoice require the model to call the report_findings
+	// tool with Options.ToolCalling.
+	Tools      []tool      `json:"tools,omitempty"`
+	ToolChoice *toolChoice `json:"tool_choice,omitempty"`
+}
+
+// reportFindingsTool is the name of the tool that the model calls with its
+// structured analysis.
+const reportFindingsTool = "report_findings"
+
+type tool struct {
+	Type     string       `json:"type"`
+	Function toolFunction `json:"function"`
+}
+
+type toolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// toolChoice forces the model to call the named function.
+type toolChoice struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+// toolCall is a call to a tool in the model's reply. Arguments holds the
+// function's arguments as a JSON object encoded in a string.
+type toolCall struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// reportFindings returns the definition of the report_findings tool, whose
+// parameters are AnalysisSchema without its metadata.
+func reportFindings() tool {
+	var params map[string]any
+	if err := json.Unmarshal([]byte(AnalysisSchema), &params); err != nil {
+		panic(err)
+	}
+	delete(params, "$schema")
+	delete(params, "title")
+	data, err := json.Marshal(params)
+	if err != nil {
+		panic(err)
+	}
+	return tool{Type: "function", Function: toolFunction{
+		Name:        reportFindingsTool,
+		Description: "Report the findings of the analysis of the statement bundle.",
+		Parameters:  data,
+	}}
+}
+
+// replyMessage is a message in a reply, which may call tools instead of, or
+// as well as, having content.
+type replyMessage struct {
+	Message
+	ToolCalls []toolCall `json:"tool_calls"`
+}
+
+// content returns the arguments of the message's call to report_findings, if
+// any, and otherwise its content.
+func (m replyMessage) content() string {
+	for _, call := range m.ToolCalls {
+		if call.Function.Name == reportFindingsTool {
+			return call.Function.Arguments
+		}
+	}
+	return m.Content
+}
+
+type responseFormat struct {
+	Type string `json:"type"`
+}
+
+// jsonModeUnsupported are the prefixes of the names of models that are known
+// not to support the json_object response format.
+var jsonModeUnsupported = []string{"gpt-4-0314", "gpt-4-0613", "gpt-4-32k", "gpt-3.5-turbo-0613"}
+
+// supportsJSONMode returns true if the model may support the json_object
+// response format.
+func supportsJSONMode(model string) bool {
+	if model == "gpt-4" {
+		return false
+	}
+	for _, prefix := range jsonModeUnsupported {
+		if strings.HasPrefix(model, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+type streamOptions struct {
+	// IncludeUsage requests a final event reporting the token usage.
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type response struct {
+	Model             string `json:"model"`
+	SystemFingerprint string `json:"system_fingerprint"`
+	Choices           []struct {
+		Message      replyMessage `json:"message"`
+		FinishReason string       `json:"finish_reason"`
+	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+// streamChunk is a single server-sent event of a streamed response.
+type streamChunk struct {
+	Model             string `json:"model"`
+	SystemFingerprint string `json:"system_fingerprint"`
+	Choices           []struct {
+		Delta        Message `json:"delta"`
+		FinishReason string  `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+// openAIClient is an Analyzer backed by the OpenAI chat completions API, or
+// any API compatible with it.
+type openAIClient struct {
+	opts Options
+}
+
+var _ Analyzer = (*openAIClient)(nil)
+
+// Model implements the Analyzer interface.
+func (c *openAIClient) Model() string {
+	return c.opts.Model
+}
+
+// Analyze implements the Analyzer interface.
+func (c *openAIClient) Analyze(ctx context.Context, prompt string) (*Completion, error) {
+	return c.sendToChatGPT(ctx, []Message{{Role: RoleUser, Content: prompt}}, c.opts.Choices, c.opts.ToolCalling)
+}
+
+// Chat implements the Analyzer interface.
+func (c *openAIClient) Chat(ctx context.Context, messages []Message) (*Completion, error) {
+	return c.sendToChatGPT(ctx, messages, 1, false)
+}
+
+// sendToChatGPT sends the conversation to the model, requesting n candidate
+// replies. If tools is true, the model must reply by calling report_findings.
+func (c *openAIClient) sendToChatGPT(ctx context.Context, messages []Message, n int, tools bool) (*Completion, error) {
+	apiKey, err := c.apiKey()
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody := request{
+		Model:       c.opts.Model,
+		Messages:    append([]Message{{Role: "system", Content: c.opts.SystemPrompt}}, messages...),
+		Temperature: c.opts.Temperature,
+		MaxTokens:   c.opts.MaxTokens,
+		Seed:        c.opts.Seed,
+	}
+	if n > 1 {
+		reqBody.N = n
+	}
+	if c.opts.Stream != nil {
+		reqBody.Stream = true
+		reqBody.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	jsonMode := c.opts.JSONMode && supportsJSONMode(c.opts.Model)
+	if tools {
+		reqBody.Tools = []tool{reportFindings()}
+		reqBody.ToolChoice = &toolChoice{Type: "function"}
+		reqBody.ToolChoice.Function.Name = reportFindingsTool
+	} else if jsonMode {
+		reqBody.ResponseFormat = &responseFormat{Type: "json_object"}
+	}
+
+	send := func() (*Completion, error) {
+		jsonBody, err := json.Marshal(reqBody)
+		if err != nil {
+			return nil, err
+		}
+		return withRetries(ctx, c.opts.Retries, func() (*Completion, error) {
+			return c.doChatRequest(ctx, apiKey, jsonBody)
+		})
+	}
+	comp, err := send()
+	if reqBody.Tools != nil && isRejected(err, "tool") {
+		// The model or server doesn't support tools, so fall back to JSON
+		// mode.
+		c.opts.logf("model %s rejected tools, retrying without them", c.opts.Model)
+		reqBody.Tools, reqBody.ToolChoice = nil, nil
+		if jsonMode {
+			reqBody.ResponseFormat = &responseFormat{Type: "json_object"}
+		}
+		comp, err = send()
+	}
+	if reqBody.ResponseFormat != nil && isRejected(err, "response_format") {
+		// The model doesn't support JSON mode, so rely on the prompt alone.
+		c.opts.logf("model %s rejected response_format, retrying without it", c.opts.Model)
+		reqBody.ResponseFormat = nil
+		comp, err = send()
+	}
+	if comp != nil && comp.Model == "" {
+		comp.Model = c.opts.Model
+	}
+	return comp, err
+}
+
+// isRejected returns true if err is a Bad Request response whose body mentions
+// field, meaning that the model or server doesn't support it.
+func isRejected(err error, field string) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.code == http.StatusBadRequest &&
+		bytes.Contains(statusErr.body, []byte(field))
+}
+
+// apiKey returns the API key from Options.APIKey, the file named by
+// OPENAI_API_KEY_FILE, or OPENAI_API_KEY, in that order of precedence. If
+// there is none, it returns "" if the server doesn't require a key.
+func (c *openAIClient) apiKey() (string, error) {
+	key, source := strings.TrimSpace(c.opts.APIKey), "API key"
+	if key == "" {
+		if path := os.Getenv("OPENAI_API_KEY_FILE"); path != "" {
+			var err error
+			if key, err = ReadAPIKeyFile(path); err != nil {
+				return "", fmt.Errorf("invalid OPENAI_API_KEY_FILE: %w", err)
+			}
+			source = "OPENAI_API_KEY_FILE"
+		} else {
+			key, source = strings.TrimSpace(os.Getenv("OPENAI_API_KEY")), "OPENAI_API_KEY"
+		}
+	}
+	if key == "" {
+		if c.opts.NoAuth || isLocalEndpoint(c.opts.Endpoint) {
+			// Local servers, such as Ollama and vLLM, usually don't need a
+			// key.
+			return "", nil
+		}
+		return "", fmt.Errorf("OPENAI_API_KEY not set")
+	}
+	if reason := malformedKeyReason(key); reason != "" {
+		return "", fmt.Errorf("%s looks malformed (%s)", source, reason)
+	}
+	return key, nil
+}
+
+// Bounds on the length of a plausible API key. Keys are usually between 40
+// and 200 characters long.
+const (
+	minAPIKeyLength = 20
+	maxAPIKeyLength = 512
+)
+
+// malformedKeyReason returns a guess at the copy-paste mistake that makes key
+// implausible as an API key, or "" if it looks fine. The key's prefix isn't
+// checked, since the format of keys changes over time.
+func malformedKeyReason(key string) string {
+	switch {
+	case strings.ContainsAny(key[:1], `"'`) || strings.ContainsAny(key[len(key)-1:], `"'`):
+		return "wrapped in quotes?"
+	case strings.ContainsFunc(key, unicode.IsSpace):
+		return "contains whitespace?"
+	case strings.ContainsFunc(key, func(r rune) bool { return !unicode.IsPrint(r) }):
+		return "contains unprintable characters?"
+	case len(key) < minAPIKeyLength:
+		return fmt.Sprintf("only %d characters long, truncated?", len(key))
+	case len(key) > maxAPIKeyLength:
+		return fmt.Sprintf("%d characters long, pasted more than the key?", len(key))
+	}
+	return ""
+}
+
+// doChatRequest makes a single chat completion request with the given JSON
+// body. If streaming, the response is read as a stream of server-sent events
+// and written to the stream as it arrives.
+func (c *openAIClient) doChatRequest(ctx context.Context, apiKey string, jsonBody []byte) (*Completion, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.opts.Endpoint, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case apiKey == "":
+	case isAzureEndpoint(c.opts.Endpoint):
+		req.Header.Set("api-key", apiKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// Without these headers, requests are billed to the key's default
+	// organization and project.
+	if c.opts.Organization != "" {
+		req.Header.Set("OpenAI-Organization", c.opts.Organization)
+	}
+	if c.opts.Project != "" {
+		req.Header.Set("OpenAI-Project", c.opts.Project)
+	}
+
+	c.opts.logf("POST %s (model %s)", c.opts.Endpoint, c.opts.Model)
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	c.opts.logf("HTTP status %s", resp.Status)
+	if rl, ok := parseRateLimit(resp.Header); ok && c.opts.OnRateLimit != nil {
+		c.opts.OnRateLimit(rl)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
+	}
+
+	if c.opts.Stream != nil {
+		return readStream(resp.Body, c.opts.Stream, decodeOpenAIStream)
+	}
+
+	var chatResp response
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, err
+	}
+
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("API returned no choices (possibly content-filtered)")
+	}
+	choice := chatResp.Choices[0]
+	if choice.Message.content() == "" && choice.FinishReason != "" && choice.FinishReason != FinishStop {
+		return nil, fmt.Errorf("API returned an empty reply (finish_reason: %s)", choice.FinishReason)
+	}
+
+	comp := &Completion{
+		Content:           choice.Message.content(),
+		Model:             chatResp.Model,
+		FinishReason:      choice.FinishReason,
+		SystemFingerprint: chatResp.SystemFingerprint,
+		Usage:             chatResp.Usage,
+	}
+	if len(chatResp.Choices) > 1 {
+		for _, choice := range chatResp.Choices {
+			comp.Choices = append(comp.Choices, choice.Message.content())
+		}
+	}
+	return comp, nil
+}
+
+// RateLimit is the state of the request rate limit of an API key, as reported
+// by the x-ratelimit-* headers of OpenAI responses.
+type RateLimit struct {
+	// Limit is the number of requests allowed in the rate limit's window, or
+	// 0 if it wasn't reported.
+	Limit int
+	// Remaining is the number of requests that may be made before the
+	// limit is reached.
+	Remaining int
+	// Reset is the time until Remaining is back to Limit, or 0 if it wasn't
+	// reported.
+	Reset time.Duration
+}
+
+// String returns the state of the rate limit, such as "3 of 500 requests
+// remaining, resets in 120ms".
+func (rl RateLimit) String() string {
+	s := fmt.Sprintf("%d requests remaining", rl.Remaining)
+	if rl.Limit > 0 {
+		s = fmt.Sprintf("%d of %d requests remaining", rl.Remaining, rl.Limit)
+	}
+	if rl.Reset > 0 {
+		s += fmt.Sprintf(", resets in %s", rl.Reset)
+	}
+	return s
+}
+
+// parseRateLimit returns the request rate limit reported by the headers of a
+// response, or false if they don't report the number of requests remaining.
+func parseRateLimit(h http.Header) (RateLimit, bool) {
+	remaining, err := strconv.Atoi(h.Get("x-ratelimit-remaining-requests"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	rl := RateLimit{Remaining: remaining}
+	rl.Limit, _ = strconv.Atoi(h.Get("x-ratelimit-limit-requests"))
+	// The reset is a duration such as "1s", "6m0s", or "20ms".
+	if d, err := time.ParseDuration(h.Get("x-ratelimit-reset-requests")); err == nil && d > 0 {
+		rl.Reset = d
+	}
+	return rl, true
+}
+
+// decodeOpenAIStream is the streamDecoder for OpenAI streamed responses, which
+// are terminated by a "[DONE]" event.
+func decodeOpenAIStream(data string, c *Completion) (string, error) {
+	if data == "[DONE]" {
+		return "", errStreamDone
+	}
+	var chunk streamChunk
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return "", err
+	}
+	if chunk.Model != "" {
+		c.Model = chunk.Model
+	}
+	if chunk.SystemFingerprint != "" {
+		c.SystemFingerprint = chunk.SystemFingerprint
+	}
+	if chunk.Usage != nil {
+		c.Usage = *chunk.Usage
+	}
+	var delta strings.Builder
+	for _, choice := range chunk.Choices {
+		delta.WriteString(choice.Delta.Content)
+		if choice.FinishReason != "" {
+			c.FinishReason = choice.FinishReason
+		}
+	}
+	return delta.String(), nil
+}
+
+// resolveEndpoint returns the chat completions endpoint to use. The -endpoint
+// flag takes precedence over the OPENAI_BASE_URL environment variable, which
+// takes precedence over the OpenAI API.
+func resolveEndpoint(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if base := os.Getenv("OPENAI_BASE_URL"); base != "" {
+		base = strings.TrimSuffix(base, "/")
+		if strings.HasSuffix(base, chatCompletionsPath) {
+			return base
+		}
+		return base + chatCompletionsPath
+	}
+	return DefaultOpenAIEndpoint
+}
+
+// isLocalEndpoint returns true if endpoint is a server on localhost, such as a
+// model served by Ollama or vLLM.
+func isLocalEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// isAzureEndpoint returns true if endpoint is an Azure OpenAI deployment,
+// which expects the key in an api-key header rather than as a bearer token.
+func isAzureEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(u.Hostname(), ".azure.com")
+}
@@ -0,0 +1,599 @@
+package analyze
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns an OpenAI Analyzer that sends its requests to a stub
+// server with the given handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) Analyzer {
+	t.Helper()
+	return newTestClientWithOptions(t, Options{}, handler)
+}
+
+// newTestClientWithOptions is like newTestClient, with the given options
+// besides the endpoint, HTTP client, and retries.
+func newTestClientWithOptions(t *testing.T, opts Options, handler http.HandlerFunc) Analyzer {
+	t.Helper()
+	t.Setenv("OPENAI_API_KEY", "sk-test-0123456789abcdef")
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	opts.Endpoint, opts.HTTPClient, opts.Retries = srv.URL, srv.Client(), 1
+	a, err := New(ProviderOpenAI, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func writeJSON(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+func TestSendToChatGPT(t *testing.T) {
+	var req request
+	a := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer sk-test-0123456789abcdef" {
+			t.Errorf("Authorization header = %q", got)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		writeJSON(w, http.StatusOK, `{
+			"model": "gpt-4-0613",
+			"choices": [{"message": {"role": "assistant", "content": "Add an index."}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 3, "total_tokens": 13}
+		}`)
+	})
+
+	comp, err := a.Analyze(context.Background(), "analyze this")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comp.Content != "Add an index." {
+		t.Errorf("Content = %q", comp.Content)
+	}
+	if comp.Model != "gpt-4-0613" {
+		t.Errorf("Model = %q", comp.Model)
+	}
+	if comp.Usage.TotalTokens != 13 {
+		t.Errorf("Usage.TotalTokens = %d", comp.Usage.TotalTokens)
+	}
+	if len(req.Messages) != 2 || req.Messages[1].Content != "analyze this" {
+		t.Errorf("Messages = %+v", req.Messages)
+	}
+}
+
+func TestSendToChatGPTRetriesRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	a := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			writeJSON(w, http.StatusTooManyRequests, `{"error": {"message": "Rate limit reached", "code": "rate_limit_exceeded"}}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, `{"choices": [{"message": {"content": "ok"}, "finish_reason": "stop"}]}`)
+	})
+
+	comp, err := a.Analyze(context.Background(), "analyze this")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comp.Content != "ok" {
+		t.Errorf("Content = %q", comp.Content)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+func TestSendToChatGPTErrorStatus(t *testing.T) {
+	a := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusUnauthorized, `{"error": {"message": "Incorrect API key provided", "type": "invalid_request_error", "code": "invalid_api_key"}}`)
+	})
+
+	_, err := a.Analyze(context.Background(), "analyze this")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if want := "API error (invalid_api_key): Incorrect API key provided"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestContextLengthExceeded(t *testing.T) {
+	a := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusBadRequest, `{"error": {"message": "This model's maximum context length is 8192 tokens. However, your messages resulted in 9000 tokens. Please reduce the length of the messages.", "type": "invalid_request_error", "code": "context_length_exceeded"}}`)
+	})
+
+	_, err := a.Analyze(context.Background(), "analyze this")
+	limit, prompt, ok := ContextLengthExceeded(fmt.Errorf("wrapped: %w", err))
+	if !ok || limit != 8192 || prompt != 9000 {
+		t.Errorf("ContextLengthExceeded(%v) = %d, %d, %v, want 8192, 9000, true", err, limit, prompt, ok)
+	}
+	if _, _, ok := ContextLengthExceeded(errors.New("API error (context_length_exceeded)")); ok {
+		t.Error("ContextLengthExceeded is true for an error without a status")
+	}
+}
+
+func TestSendToChatGPTNoChoices(t *testing.T) {
+	a := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, `{"model": "gpt-4", "choices": []}`)
+	})
+
+	_, err := a.Analyze(context.Background(), "analyze this")
+	if err == nil || !strings.Contains(err.Error(), "no choices") {
+		t.Errorf("error = %v, want an error about no choices", err)
+	}
+}
+
+func TestSendToChatGPTMissingKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	// A key is required by any server other than one on localhost, and the
+	// request fails before it is sent.
+	a, err := New(ProviderOpenAI, Options{Endpoint: DefaultOpenAIEndpoint})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = a.Analyze(context.Background(), "analyze this")
+	if err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY") {
+		t.Errorf("error = %v, want an error about OPENAI_API_KEY", err)
+	}
+}
+
+func TestSendToChatGPTJSONModeFallback(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test-0123456789abcdef")
+	var formats []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if req.ResponseFormat != nil {
+			formats = append(formats, req.ResponseFormat.Type)
+			writeJSON(w, http.StatusBadRequest, `{"error": {"message": "Invalid parameter: 'response_format' of type 'json_object' is not supported with this model."}}`)
+			return
+		}
+		formats = append(formats, "")
+		writeJSON(w, http.StatusOK, `{"choices": [{"message": {"content": "{}"}, "finish_reason": "stop"}]}`)
+	}))
+	defer srv.Close()
+	a, err := New(ProviderOpenAI, Options{Model: "gpt-4o", Endpoint: srv.URL, HTTPClient: srv.Client(), JSONMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comp, err := a.Analyze(context.Background(), "reply with JSON")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comp.Content != "{}" {
+		t.Errorf("Content = %q", comp.Content)
+	}
+	if want := []string{"json_object", ""}; !slices.Equal(formats, want) {
+		t.Errorf("response formats = %q, want %q", formats, want)
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute URL of the target.
+		if r.URL.Host != "api.bundlebot.test" {
+			t.Errorf("proxied request for host %q", r.URL.Host)
+		}
+		proxied.Add(1)
+		writeJSON(w, http.StatusOK, `{"model":"gpt-4","choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	t.Cleanup(proxy.Close)
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OPENAI_API_KEY", "sk-test-0123456789abcdef")
+	a, err := New(ProviderOpenAI, Options{
+		Endpoint:   "http://api.bundlebot.test/v1/chat/completions",
+		HTTPClient: NewHTTPClient(time.Minute, proxyURL),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := a.Analyze(context.Background(), "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Content != "ok" {
+		t.Errorf("content = %q, want %q", c.Content, "ok")
+	}
+	if n := proxied.Load(); n != 1 {
+		t.Errorf("proxy received %d requests, want 1", n)
+	}
+}
+
+func TestSendToChatGPTChoices(t *testing.T) {
+	var calls atomic.Int32
+	t.Setenv("OPENAI_API_KEY", "sk-test-0123456789abcdef")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if calls.Add(1) == 1 {
+			if req.N != 2 {
+				t.Errorf("n = %d, want 2", req.N)
+			}
+			writeJSON(w, http.StatusOK, `{"choices": [
+				{"message": {"content": "first"}, "finish_reason": "stop"},
+				{"message": {"content": "second"}, "finish_reason": "stop"}]}`)
+			return
+		}
+		// Picking the best candidate requests a single reply.
+		if req.N != 0 {
+			t.Errorf("n = %d, want it omitted", req.N)
+		}
+		writeJSON(w, http.StatusOK, `{"choices": [{"message": {"content": "Candidate 2"}, "finish_reason": "stop"}]}`)
+	}))
+	t.Cleanup(srv.Close)
+	a, err := New(ProviderOpenAI, Options{Endpoint: srv.URL, HTTPClient: srv.Client(), Choices: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := a.Analyze(context.Background(), "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Content != "first" || !slices.Equal(c.Choices, []string{"first", "second"}) {
+		t.Fatalf("content = %q, choices = %q", c.Content, c.Choices)
+	}
+	i, _, err := PickBest(context.Background(), a, c.Choices)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1 {
+		t.Errorf("picked candidate %d, want 1", i)
+	}
+}
+
+func TestSendToChatGPTSeed(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test-0123456789abcdef")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if req.Seed == nil || *req.Seed != 42 {
+			t.Errorf("seed = %v, want 42", req.Seed)
+		}
+		writeJSON(w, http.StatusOK, `{"system_fingerprint": "fp_44709d6fcb",
+			"choices": [{"message": {"content": "reply"}, "finish_reason": "stop"}]}`)
+	}))
+	t.Cleanup(srv.Close)
+	seed := int64(42)
+	a, err := New(ProviderOpenAI, Options{Endpoint: srv.URL, HTTPClient: srv.Client(), Seed: &seed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := a.Analyze(context.Background(), "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.SystemFingerprint != "fp_44709d6fcb" {
+		t.Errorf("SystemFingerprint = %q", c.SystemFingerprint)
+	}
+}
+
+func TestSendToChatGPTTemperature(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "sk-test-0123456789abcdef")
+	zero := 0.0
+	for _, tc := range []struct {
+		temperature *float64
+		want        string
+	}{
+		{temperature: nil, want: ""},
+		{temperature: &zero, want: "0"},
+	} {
+		var got string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req map[string]json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			got = string(req["temperature"])
+			writeJSON(w, http.StatusOK, `{"choices": [{"message": {"content": "reply"}, "finish_reason": "stop"}]}`)
+		}))
+		a, err := New(ProviderOpenAI, Options{Endpoint: srv.URL, HTTPClient: srv.Client(), Temperature: tc.temperature})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Analyze(context.Background(), "prompt"); err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+		if got != tc.want {
+			t.Errorf("temperature %v: sent %q, want %q", tc.temperature, got, tc.want)
+		}
+	}
+}
+
+func TestSendToChatGPTOrganizationHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		opts Options
+		// wantOrg and wantProject are the headers' values, or nil if
+		// they must not be sent.
+		wantOrg, wantProject []string
+	}{
+		{name: "unset"},
+		{
+			name:        "env",
+			env:         map[string]string{"OPENAI_ORG_ID": "org-env", "OPENAI_PROJECT_ID": "proj_env"},
+			wantOrg:     []string{"org-env"},
+			wantProject: []string{"proj_env"},
+		},
+		{
+			name:        "options override env",
+			env:         map[string]string{"OPENAI_ORG_ID": "org-env"},
+			opts:        Options{Organization: "org-flag", Project: "proj_flag"},
+			wantOrg:     []string{"org-flag"},
+			wantProject: []string{"proj_flag"},
+		},
+		{
+			name:        "project only",
+			opts:        Options{Project: "proj_flag"},
+			wantProject: []string{"proj_flag"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OPENAI_ORG_ID", "")
+			t.Setenv("OPENAI_PROJECT_ID", "")
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			a := newTestClientWithOptions(t, tc.opts, func(w http.ResponseWriter, r *http.Request) {
+				org, project := r.Header.Values("OpenAI-Organization"), r.Header.Values("OpenAI-Project")
+				if !slices.Equal(org, tc.wantOrg) || !slices.Equal(project, tc.wantProject) {
+					t.Errorf("OpenAI-Organization = %q, OpenAI-Project = %q, want %q, %q", org, project, tc.wantOrg, tc.wantProject)
+				}
+				writeJSON(w, http.StatusOK, `{"choices": [{"message": {"content": "reply"}}]}`)
+			})
+			if _, err := a.Analyze(context.Background(), "prompt"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestSendToChatGPTRateLimit checks that the rate limit reported by each
+// response, including a 429 that is retried, is passed to OnRateLimit.
+func TestSendToChatGPTRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	var got []RateLimit
+	opts := Options{OnRateLimit: func(rl RateLimit) { got = append(got, rl) }}
+	a := newTestClientWithOptions(t, opts, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ratelimit-limit-requests", "500")
+		if calls.Add(1) == 1 {
+			w.Header().Set("x-ratelimit-remaining-requests", "0")
+			w.Header().Set("x-ratelimit-reset-requests", "20ms")
+			w.Header().Set("Retry-After", "0")
+			writeJSON(w, http.StatusTooManyRequests, `{"error": {"message": "Rate limit reached", "code": "rate_limit_exceeded"}}`)
+			return
+		}
+		w.Header().Set("x-ratelimit-remaining-requests", "499")
+		w.Header().Set("x-ratelimit-reset-requests", "bogus")
+		writeJSON(w, http.StatusOK, `{"choices": [{"message": {"content": "reply"}}]}`)
+	})
+	if _, err := a.Analyze(context.Background(), "prompt"); err != nil {
+		t.Fatal(err)
+	}
+	want := []RateLimit{
+		{Limit: 500, Remaining: 0, Reset: 20 * time.Millisecond},
+		{Limit: 500, Remaining: 499},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("OnRateLimit got %+v, want %+v", got, want)
+	}
+	if s := want[0].String(); s != "0 of 500 requests remaining, resets in 20ms" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestSendToChatGPTToolCalling(t *testing.T) {
+	const args = `{"slowest_operations": [], "schema_antipatterns": [], "query_antipatterns": [], "missing_indexes": [{"finding": "CREATE INDEX ON t (a);", "confidence": "high"}]}`
+	var rejectTools atomic.Bool
+	t.Setenv("OPENAI_API_KEY", "sk-test-0123456789abcdef")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		if len(req.Tools) == 0 {
+			if req.ResponseFormat == nil {
+				t.Error("request without tools is not in JSON mode")
+			}
+			writeJSON(w, http.StatusOK, `{"choices": [{"message": {"content": "{}"}, "finish_reason": "stop"}]}`)
+			return
+		}
+		if rejectTools.Load() {
+			writeJSON(w, http.StatusBadRequest, `{"error": {"message": "tools are not supported by this model"}}`)
+			return
+		}
+		if req.ResponseFormat != nil {
+			t.Error("response_format is set along with tools")
+		}
+		if req.ToolChoice == nil || req.ToolChoice.Function.Name != reportFindingsTool {
+			t.Errorf("tool_choice = %+v, want %s", req.ToolChoice, reportFindingsTool)
+		}
+		var params map[string]any
+		if err := json.Unmarshal(req.Tools[0].Function.Parameters, &params); err != nil || params["type"] != "object" {
+			t.Errorf("parameters = %s, want an object schema", req.Tools[0].Function.Parameters)
+		}
+		resp, _ := json.Marshal(map[string]any{"choices": []any{map[string]any{
+			"message": map[string]any{"content": nil, "tool_calls": []any{map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": reportFindingsTool, "arguments": args},
+			}}},
+			"finish_reason": "stop",
+		}}})
+		writeJSON(w, http.StatusOK, string(resp))
+	}))
+	t.Cleanup(srv.Close)
+	a, err := New(ProviderOpenAI, Options{Model: "gpt-4o", Endpoint: srv.URL, HTTPClient: srv.Client(), JSONMode: true, ToolCalling: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := a.Analyze(context.Background(), "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Content != args {
+		t.Fatalf("content = %q, want the tool call's arguments", c.Content)
+	}
+	if _, err := ValidateAnalysis(c.Content); err != nil {
+		t.Error(err)
+	}
+	// Follow-up questions are answered in prose.
+	if c, err = a.Chat(context.Background(), []Message{{Role: RoleUser, Content: "why?"}}); err != nil || c.Content != "{}" {
+		t.Errorf("Chat() = %v, %v", c, err)
+	}
+	// A server that doesn't support tools falls back to JSON mode.
+	rejectTools.Store(true)
+	if c, err = a.Analyze(context.Background(), "prompt"); err != nil || c.Content != "{}" {
+		t.Errorf("Analyze() with tools rejected = %v, %v", c, err)
+	}
+}
+
+func TestOpenAIAPIKeyPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name, content string) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	envFile := writeKey("env-key", "file-env-key-0123456789abcdef\n")
+	flagFile := writeKey("flag-key", "  file-flag-key-0123456789abcdef\r\n")
+
+	for _, tc := range []struct {
+		name    string
+		fileEnv string
+		opt     string
+		want    string
+	}{
+		{name: "env", want: "env-key-0123456789abcdef"},
+		{name: "file env", fileEnv: envFile, want: "file-env-key-0123456789abcdef"},
+		{name: "option", fileEnv: envFile, opt: flagFile, want: "file-flag-key-0123456789abcdef"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OPENAI_API_KEY", "env-key-0123456789abcdef")
+			t.Setenv("OPENAI_API_KEY_FILE", tc.fileEnv)
+			var opts Options
+			if tc.opt != "" {
+				key, err := ReadAPIKeyFile(tc.opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.APIKey = key
+			}
+			got, err := (&openAIClient{opts: opts}).apiKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("key = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	t.Setenv("OPENAI_API_KEY_FILE", writeKey("empty", "\n"))
+	if _, err := (&openAIClient{}).apiKey(); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Errorf("error = %v, want an error about the empty file", err)
+	}
+}
+
+func TestSendToChatGPTLocalServerWithoutKey(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY_FILE", "")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("Authorization header = %q, want none", got)
+		}
+		writeJSON(w, http.StatusOK, `{
+			"id": "chatcmpl-1",
+			"object": "chat.completion",
+			"model": "llama3",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": "Add an index."}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 4, "total_tokens": 14}
+		}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	// The stub server listens on 127.0.0.1, so no key is required.
+	a, err := New(ProviderOpenAI, Options{Model: "llama3", Endpoint: srv.URL + "/v1/chat/completions", HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := a.Analyze(context.Background(), "prompt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Content != "Add an index." || c.Model != "llama3" || c.Usage.TotalTokens != 14 {
+		t.Errorf("completion = %+v", c)
+	}
+
+	remote := &openAIClient{opts: Options{Endpoint: DefaultOpenAIEndpoint}}
+	if _, err := remote.apiKey(); err == nil {
+		t.Error("expected an error for a remote endpoint without a key")
+	}
+	remote.opts.NoAuth = true
+	if key, err := remote.apiKey(); err != nil || key != "" {
+		t.Errorf("apiKey() = %q, %v with NoAuth", key, err)
+	}
+}
+
+func TestOpenAIAPIKeyMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		want string
+	}{
+		{key: "  sk-proj-0123456789abcdef\n", want: ""},
+		{key: `"sk-proj-0123456789abcdef"`, want: "wrapped in quotes?"},
+		{key: "sk-proj-0123 456789abcdef", want: "contains whitespace?"},
+		{key: "sk-proj-0123\x07456789abcdef", want: "contains unprintable characters?"},
+		{key: "sk-proj-0123", want: "truncated?"},
+		{key: "sk-" + strings.Repeat("a", 600), want: "pasted more than the key?"},
+	} {
+		t.Setenv("OPENAI_API_KEY", tc.key)
+		_, err := (&openAIClient{}).apiKey()
+		if tc.want == "" {
+			if err != nil {
+				t.Errorf("key %q: unexpected error %v", tc.key, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "OPENAI_API_KEY looks malformed") || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("key %q: error = %v, want one containing %q", tc.key, err, tc.want)
+		}
+	}
+}
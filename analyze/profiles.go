@@ -0,0 +1,81 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PromptProfile is a system message and the questions asked by the analysis
+// prompt in place of the default ones, tailored to a kind of workload.
+type PromptProfile struct {
+	SystemPrompt string   `json:"system_prompt"`
+	Questions    []string `json:"questions"`
+}
+
+// BuiltinProfiles are the prompt profiles available without defining them in
+// the config file, keyed by name.
+var BuiltinProfiles = map[string]PromptProfile{
+	"oltp-latency": {
+		SystemPrompt: "You are a CockroachDB performance expert focused on the latency of OLTP statements, " +
+			"which should take milliseconds and touch few rows.",
+		Questions: []string{
+			"Which operators in the plan add the most latency, and why?",
+			"Does the statement read many more rows than it returns, such as with a full scan, an index join, or a lookup join on many rows?",
+			"What anti-patterns in the schema or query, such as hot spots from sequential keys or contention on frequently updated rows, could cause latency spikes under concurrency?",
+			"What missing indexes, including covering indexes with STORING, would turn scans into point lookups?",
+		},
+	},
+	"olap-throughput": {
+		SystemPrompt: "You are a CockroachDB performance expert focused on the throughput of analytical statements, " +
+			"which scan, join, and aggregate large amounts of data.",
+		Questions: []string{
+			"What are the slowest operations as shown in the plan, and how many rows and bytes does each process?",
+			"Do the joins and aggregations use the best algorithms, such as merge joins and streaming aggregation over ordered input, and do any of them spill to disk?",
+			"Is the plan distributed and vectorized, and if not, what prevents it?",
+			"What missing indexes or schema changes, such as indexes ordered for the joins and aggregations, would reduce the data scanned?",
+		},
+	},
+	"multi-region": {
+		SystemPrompt: "You are a CockroachDB performance expert focused on multi-region clusters, " +
+			"where the latency between regions dominates the latency of statements.",
+		Questions: []string{
+			"Which operations in the plan read from or write to remote regions, and how much latency do they add?",
+			"Do the localities of the tables, such as REGIONAL BY ROW or GLOBAL, suit the statement?",
+			"What anti-patterns in the query, such as predicates that don't constrain crdb_region, prevent locality optimized search?",
+			"What missing indexes, or changes to the localities of tables, would keep the statement within a single region?",
+		},
+	},
+}
+
+// ProfileNames returns the names of the profiles, sorted.
+func ProfileNames(profiles map[string]PromptProfile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate returns an error if p has no system message or no questions.
+func (p PromptProfile) Validate() error {
+	if strings.TrimSpace(p.SystemPrompt) == "" {
+		return fmt.Errorf("system_prompt must not be empty")
+	}
+	if len(p.Questions) == 0 {
+		return fmt.Errorf("questions must not be empty")
+	}
+	for i, q := range p.Questions {
+		if strings.TrimSpace(q) == "" {
+			return fmt.Errorf("questions[%d] must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// Prompt returns the analysis prompt that asks p's questions, like BasePrompt
+// does the default ones.
+func (p PromptProfile) Prompt() string {
+	return basePrompt(p.Questions)
+}
@@ -0,0 +1,29 @@
+package analyze
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuiltinProfiles(t *testing.T) {
+	for name, p := range BuiltinProfiles {
+		if err := p.Validate(); err != nil {
+			t.Errorf("profile %q: %v", name, err)
+		}
+		prompt := p.Prompt()
+		for _, q := range p.Questions {
+			if !strings.Contains(prompt, "* "+q+"\n") {
+				t.Errorf("profile %q: prompt doesn't ask %q:\n%s", name, q, prompt)
+			}
+		}
+	}
+}
+
+func TestBasePromptQuestions(t *testing.T) {
+	prompt := BasePrompt(QuestionIndexes, QuestionSlowest)
+	slowest := strings.Index(prompt, questionText[QuestionSlowest])
+	indexes := strings.Index(prompt, questionText[QuestionIndexes])
+	if slowest < 0 || indexes < slowest || strings.Contains(prompt, questionText[QuestionSchema]) {
+		t.Errorf("BasePrompt(QuestionIndexes, QuestionSlowest) =\n%s", prompt)
+	}
+}
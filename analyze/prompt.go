@@ -0,0 +1,545 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mgartner/bundlebot/bundle"
+	"github.com/mgartner/bundlebot/plan"
+	"github.com/mgartner/bundlebot/schema"
+	"github.com/mgartner/bundlebot/stats"
+	"github.com/mgartner/bundlebot/trace"
+)
+
+const (
+	// basePromptIntro precedes the questions asked by the default analysis
+	// prompt.
+	basePromptIntro = `You are a CockroachDB expert. Analyze the following
+		files and identify inefficiences and anti-patterns. Only include
+		suggestions that you are highly confident in being relevant to query
+		performance. Include only the list not any summary text beforehand.
+`
+	// JSONInstructions is appended to the prompt when structured output is
+	// requested. The reply can be parsed with ParseAnalysis.
+	JSONInstructions = `
+		Reply with only a JSON object and no other text. The object must have
+		the fields "slowest_operations", "schema_antipatterns",
+		"query_antipatterns", and "missing_indexes", answering the questions
+		above about each of them. Each field is an array with one object per
+		finding, and is an empty array if there are no findings or the question
+		was not asked. Each object has a "finding" field holding the finding as
+		a string, and a "confidence" field rating how confident you are that it
+		is relevant to the statement's performance: "high", "medium", or "low".
+	`
+	// ExplainInstructions is appended to the prompt to ask for the rationale
+	// and expected impact of each item of the list.
+	ExplainInstructions = `
+		After each item, add a line starting with "Why:" giving a
+		one-sentence rationale for it, and a line starting with "Expected
+		impact:" estimating the effect of addressing it, such as the rows
+		scanned or the time it would save.
+	`
+	// ExplainJSONInstructions follows JSONInstructions to ask for the
+	// rationale and expected impact of each finding in their own fields.
+	ExplainJSONInstructions = `
+		Each object also has a "rationale" field holding a one-sentence
+		explanation of why the finding matters, and an "expected_impact" field
+		estimating the effect of addressing it, such as the rows scanned or the
+		time it would save.
+	`
+)
+
+// TraceSpans and TraceEvents are the number of the longest spans and of the
+// notable events in the summary of trace.json included in the prompt with
+// PromptOptions.Trace.
+const (
+	TraceSpans  = 5
+	TraceEvents = 10
+)
+
+// Question is a question asked by the default analysis prompt.
+type Question string
+
+// Questions that the default analysis prompt can ask.
+const (
+	QuestionSlowest Question = "slowest"
+	QuestionSchema  Question = "schema"
+	QuestionQuery   Question = "query"
+	QuestionIndexes Question = "indexes"
+)
+
+// AllQuestions are the questions asked by default, in the order they are
+// asked.
+var AllQuestions = []Question{QuestionSlowest, QuestionSchema, QuestionQuery, QuestionIndexes}
+
+// questionText is the text of each Question.
+var questionText = map[Question]string{
+	QuestionSlowest: "What are the slowest operations as shown in the plan?",
+	QuestionSchema:  "What are the most common anti-patterns in the schema?",
+	QuestionQuery:   "What are the most common anti-patterns in the query?",
+	QuestionIndexes: "What missing indexes might speed up this query?",
+}
+
+// BasePrompt returns the default analysis prompt, which precedes the bundle's
+// files, asking the given questions in the order of AllQuestions.
+func BasePrompt(questions ...Question) string {
+	var texts []string
+	for _, q := range AllQuestions {
+		if slices.Contains(questions, q) {
+			texts = append(texts, questionText[q])
+		}
+	}
+	return basePrompt(texts)
+}
+
+// basePrompt returns the analysis prompt asking the given questions, in order.
+func basePrompt(questions []string) string {
+	var buf strings.Builder
+	buf.WriteString(basePromptIntro)
+	buf.WriteString("\n")
+	for _, q := range questions {
+		fmt.Fprintf(&buf, "\t\t* %s\n", q)
+	}
+	buf.WriteString("\t")
+	return buf.String()
+}
+
+// extraFileDescriptions describes each of the bundle.ExtraFileNames to the
+// model.
+var extraFileDescriptions = map[string]string{
+	"env.sql": "session variables, cluster settings, and the CockroachDB version",
+	"opt.txt": "the optimizer's normalized expression tree",
+}
+
+// PromptOptions controls how the bundle's files are included in the prompt.
+type PromptOptions struct {
+	// Redact replaces the literals in the statement files with
+	// placeholders.
+	Redact bool
+	// MaxTokens, if positive, is the estimated number of tokens the prompt
+	// may contain. Files are truncated to fit within it, each keeping a fair
+	// share of it.
+	MaxTokens int
+	// TopOperators is the number of the plan's most expensive operators to
+	// summarize at the end of the prompt.
+	TopOperators int
+	// ExtraFiles includes the bundle.ExtraFileNames in the prompt.
+	ExtraFiles bool
+	// Include, if non-empty, holds glob patterns matching the files to
+	// include in the prompt instead of the bundle.FileNames. A pattern
+	// matches a file if it matches either its full name or its base name.
+	Include []string
+	// Exclude holds glob patterns matching files to leave out of the
+	// prompt. It takes precedence over Include.
+	Exclude []string
+	// StripANSI removes ANSI escape sequences, such as color codes, from
+	// each file.
+	StripANSI bool
+	// Compact trims trailing whitespace and collapses runs of blank lines in
+	// each file, outside of quoted strings.
+	Compact bool
+	// Tables, if non-empty, are the names of the tables to focus the
+	// analysis on. The DDL of other tables is left out of schema.sql.
+	Tables []string
+	// RelevantSchema leaves the DDL of the tables that the statements don't
+	// reference, directly or through a foreign key, out of schema.sql.
+	RelevantSchema bool
+	// Notes are the user's own remarks about the statement, such as "this
+	// runs during peak traffic", added to the end of the prompt.
+	Notes []string
+	// WhatIf are CREATE INDEX statements for indexes that don't exist yet,
+	// which the model is asked to assess at the end of the prompt.
+	WhatIf []string
+	// Trace includes a summary of the bundle's trace.json at the end of the
+	// prompt. The trace itself, in any of the files that hold it in full, is
+	// then left out, even if it matches Include.
+	Trace bool
+	// Logf, if non-nil, is called to log details of how the prompt was built.
+	Logf func(format string, args ...any)
+	// Truncatef, if non-nil, is called to report the number of tokens each
+	// file kept when the files are truncated to fit within MaxTokens.
+	Truncatef func(format string, args ...any)
+}
+
+// logf logs a message with o.Logf, if it is set.
+func (o PromptOptions) logf(format string, args ...any) {
+	if o.Logf != nil {
+		o.Logf(format, args...)
+	}
+}
+
+// reportTruncation reports the tokens that each file kept with o.Truncatef, if
+// it is set and any of them were truncated.
+func (o PromptOptions) reportTruncation(sections []sectionTokens) {
+	if o.Truncatef != nil && len(sections) > 0 {
+		o.Truncatef("truncated the files to fit the token budget: %s", summarizeSections(sections))
+	}
+}
+
+// promptFileNames returns the names of the files to include in the prompt, in
+// order. The bundle.FileNames and bundle.ExtraFileNames come first, in their
+// usual order, followed by any other files matching opts.Include sorted by
+// name. The returned names may include files that are not in files.
+func promptFileNames(files map[string]string, opts PromptOptions) []string {
+	var names []string
+	for _, name := range bundle.FileNames {
+		candidates := []string{name}
+		if statements := bundle.StatementFileNames(files); name == "statement.sql" && len(statements) > 0 {
+			// Bundles capturing several statements hold statement-2.sql and
+			// so on alongside statement.sql.
+			candidates = statements
+		}
+		for _, c := range candidates {
+			if len(opts.Include) == 0 || matchesAny(opts.Include, c) {
+				names = append(names, c)
+			}
+		}
+	}
+	if opts.ExtraFiles {
+		names = append(names, bundle.ExtraFileNames[:]...)
+	}
+	if len(opts.Include) > 0 {
+		var others []string
+		for name := range files {
+			if !slices.Contains(names, name) && matchesAny(opts.Include, name) {
+				others = append(others, name)
+			}
+		}
+		sort.Strings(others)
+		names = append(names, others...)
+	}
+	return slices.DeleteFunc(names, func(name string) bool {
+		return matchesAny(opts.Exclude, name) || (opts.Trace && trace.IsTraceFile(name))
+	})
+}
+
+// matchesAny returns true if any of the glob patterns match the full name or
+// base name of the file.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSectionHeader writes the delimiter that precedes the contents of the
+// named file in the prompt.
+func writeSectionHeader(buf *bytes.Buffer, name string) {
+	if desc, ok := extraFileDescriptions[name]; ok {
+		fmt.Fprintf(buf, "\n--- %s (%s) ---\n", name, desc)
+		return
+	}
+	fmt.Fprintf(buf, "\n--- %s ---\n", name)
+}
+
+// prepareFile returns the content of the named file as it should appear in
+// the prompt, sanitized, redacted, and compacted as requested by opts.
+func prepareFile(name, content string, opts PromptOptions) string {
+	if opts.StripANSI {
+		content = stripANSI(content)
+	}
+	if len(opts.Tables) > 0 && name == "schema.sql" {
+		content, _ = schema.Filter(content, opts.Tables)
+	}
+	if opts.Redact && bundle.IsStatementFile(name) {
+		content = RedactLiterals(content)
+	}
+	if opts.Compact {
+		compacted := compactWhitespace(content)
+		opts.logf("compacting %s saved ~%d tokens", name, EstimateTokens(content)-EstimateTokens(compacted))
+		content = compacted
+	}
+	return content
+}
+
+// relevantSchema returns schemaSQL with only the DDL of the tables referenced
+// by the bundle's statement files and the tables their foreign keys
+// reference. If the statements reference none of the tables, schemaSQL is
+// returned unchanged.
+func relevantSchema(schemaSQL string, files map[string]string, opts PromptOptions) string {
+	var statements strings.Builder
+	for _, name := range bundle.StatementFileNames(files) {
+		statements.WriteString(files[name])
+		statements.WriteByte('\n')
+	}
+	tables := schema.Parse(schemaSQL).RelevantTables(statements.String())
+	if len(tables) == 0 {
+		opts.logf("the statement references none of the tables in schema.sql, sending all of it")
+		return schemaSQL
+	}
+	filtered, _ := schema.Filter(schemaSQL, tables)
+	opts.logf("sending the DDL of %s from schema.sql saved ~%d tokens", strings.Join(tables, ", "), EstimateTokens(schemaSQL)-EstimateTokens(filtered))
+	return filtered
+}
+
+// writeContent writes the contents of a file to the prompt, terminated by a
+// newline.
+func writeContent(buf *bytes.Buffer, content string) {
+	buf.WriteString(content)
+	if !strings.HasSuffix(content, "\n") {
+		buf.WriteByte('\n')
+	}
+}
+
+// CaptureTime returns the time the bundle's files were captured, which is when
+// the statement traced in its trace.json started, or the zero time if it has
+// no trace to tell.
+func CaptureTime(files map[string]string) time.Time {
+	content, ok := files[trace.FileName]
+	if !ok {
+		return time.Time{}
+	}
+	root, err := trace.Parse(content)
+	if err != nil {
+		return time.Time{}
+	}
+	return root.Start
+}
+
+// BuildPrompt returns the prompt for analyzing the bundle's files, keyed by
+// name, which consists of the instructions, the CockroachDB version the bundle
+// was collected from, and each file. The version is left out if files hold a
+// lone statement rather than a bundle.
+func BuildPrompt(instructions string, files map[string]string, opts PromptOptions) string {
+	names := promptFileNames(files, opts)
+	contents := make(map[string]string, len(names))
+	for _, name := range names {
+		if content, ok := files[name]; ok {
+			contents[name] = prepareFile(name, content, opts)
+		}
+	}
+	if schemaSQL, ok := contents["schema.sql"]; ok && opts.RelevantSchema {
+		contents["schema.sql"] = relevantSchema(schemaSQL, files, opts)
+	}
+	if opts.MaxTokens > 0 {
+		opts.reportTruncation(truncateToBudget(contents, opts.MaxTokens-EstimateTokens(instructions)))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(instructions)
+	if version, ok := bundle.Version(files); ok {
+		fmt.Fprintf(&buf, "\nThe bundle was collected from CockroachDB %s. Only make suggestions that apply to this version.\n", version)
+	} else if !bundle.StatementOnly(files) {
+		buf.WriteString("\nThe CockroachDB version the bundle was collected from is unknown.\n")
+	}
+	// Stale statistics are a common cause of bad plans, so point out how
+	// old they were rather than leaving the model to find it in the
+	// statistics files. Their age is measured against when the bundle was
+	// captured, not the current time, so that the prompt, and the reply
+	// cached under it, don't change as time passes.
+	tables, _ := stats.ParseFiles(files)
+	captured := CaptureTime(files)
+	for _, t := range tables {
+		fmt.Fprintf(&buf, "%s\n", t.Freshness(captured))
+	}
+	// Skewed distributions drive plan choices, so summarize the histograms
+	// to help the model judge the selectivity of predicates and indexes.
+	if summary := stats.SummarizeDistributions(tables); summary != "" {
+		buf.WriteString("Column distributions from the histograms in the statistics:\n")
+		buf.WriteString(summary)
+	}
+	// Whether the plan was distributed and vectorized changes how its times
+	// should be read, so state it rather than leaving it to be found in
+	// the header of plan.txt.
+	if planText, ok := files["plan.txt"]; ok {
+		if p, err := plan.Parse(planText); err == nil {
+			if execution := p.Execution().Describe(); execution != "" {
+				buf.WriteString(execution + "\n")
+			}
+		}
+	}
+	var statements []string
+	for _, name := range names {
+		if _, ok := contents[name]; ok && bundle.IsStatementFile(name) {
+			statements = append(statements, name)
+		}
+	}
+	if len(statements) > 1 {
+		fmt.Fprintf(&buf, "The bundle holds %d statements that were captured together, in %s. "+
+			"Analyze them collectively, and say which statement each suggestion applies to.\n",
+			len(statements), strings.Join(statements, ", "))
+	}
+	if len(opts.Tables) > 0 {
+		fmt.Fprintf(&buf, "Focus the analysis on the tables %s. Only make suggestions about these tables.\n", strings.Join(opts.Tables, ", "))
+	}
+	for _, name := range names {
+		if content, ok := contents[name]; ok {
+			writeSectionHeader(&buf, name)
+			writeContent(&buf, content)
+		}
+	}
+	if planText, ok := files["plan.txt"]; ok && opts.TopOperators > 0 && slices.Contains(names, "plan.txt") {
+		if p, err := plan.Parse(planText); err == nil {
+			buf.WriteString("\nMost expensive operators in plan.txt:\n")
+			buf.WriteString(p.SummarizeOperators(opts.TopOperators))
+		}
+	}
+	if content, ok := files[trace.FileName]; ok && opts.Trace {
+		if t, err := trace.Parse(content); err != nil {
+			opts.logf("leaving out the summary of %s, which failed to parse: %v", trace.FileName, err)
+		} else {
+			fmt.Fprintf(&buf, "\nSummary of %s, the statement's execution trace:\n", trace.FileName)
+			buf.WriteString(t.Summarize(TraceSpans, TraceEvents))
+		}
+	}
+	writeNotes(&buf, opts.Notes)
+	writeWhatIf(&buf, opts.WhatIf)
+	return buf.String()
+}
+
+// writeWhatIf writes the proposed indexes, one per line, with an instruction
+// to assess whether each would improve the plan.
+func writeWhatIf(buf *bytes.Buffer, statements []string) {
+	if len(statements) == 0 {
+		return
+	}
+	buf.WriteString("\nThe user is considering the following indexes, which don't exist yet. " +
+		"For each, assess whether the optimizer would use it and whether it would improve the plan, and explain why, " +
+		"such as the operators it would replace and the rows it would avoid scanning, or why it wouldn't help. " +
+		"Include a proposed index that would help among the missing indexes.\n")
+	for _, stmt := range statements {
+		fmt.Fprintf(buf, "- %s\n", stmt)
+	}
+}
+
+// writeNotes writes the user's notes, one per line, in a section of their own.
+func writeNotes(buf *bytes.Buffer, notes []string) {
+	if len(notes) == 0 {
+		return
+	}
+	buf.WriteString("\nAdditional context from user:\n")
+	for _, note := range notes {
+		fmt.Fprintf(buf, "- %s\n", note)
+	}
+}
+
+// Analysis is the structured result of an analysis, requested with
+// JSONInstructions.
+type Analysis struct {
+	// Fingerprint is the Fingerprint of the bundle's statement, if known. It
+	// is not part of the model's reply.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Distribution and Vectorized are how the bundle's plan was executed,
+	// from plan.Execution, if known. They are not part of the model's
+	// reply.
+	Distribution string `json:"distribution,omitempty"`
+	Vectorized   *bool  `json:"vectorized,omitempty"`
+	// Summary is a one-line summary of the analysis, from Summarize, if it
+	// was requested. It is not part of the model's structured reply.
+	Summary            string    `json:"summary,omitempty"`
+	SlowestOperations  []Finding `json:"slowest_operations"`
+	SchemaAntipatterns []Finding `json:"schema_antipatterns"`
+	QueryAntipatterns  []Finding `json:"query_antipatterns"`
+	MissingIndexes     []Finding `json:"missing_indexes"`
+	// RedundantIndexes are the indexes in schema.sql that another index
+	// makes redundant, found locally by heuristic.RedundantIndexes. They are
+	// not part of the model's reply.
+	RedundantIndexes []Finding `json:"redundant_indexes,omitempty"`
+}
+
+// SetExecution records how the bundle's plan was executed in a.
+func (a *Analysis) SetExecution(e plan.Execution) {
+	a.Distribution, a.Vectorized = e.Distribution, e.Vectorized
+}
+
+// execution returns how the bundle's plan was executed, as recorded by
+// SetExecution.
+func (a *Analysis) execution() plan.Execution {
+	return plan.Execution{Distribution: a.Distribution, Vectorized: a.Vectorized}
+}
+
+// Findings returns the number of anti-patterns, missing indexes, and redundant
+// indexes in a.
+func (a *Analysis) Findings() int {
+	return len(a.SchemaAntipatterns) + len(a.QueryAntipatterns) + len(a.MissingIndexes) + len(a.RedundantIndexes)
+}
+
+// analysisSection is the findings of one field of an Analysis.
+type analysisSection struct {
+	heading  string
+	findings []Finding
+}
+
+// sections returns the fields of a, in the order the questions are asked,
+// followed by the redundant indexes if there are any.
+func (a *Analysis) sections() []analysisSection {
+	sections := []analysisSection{
+		{"Slowest Operations", a.SlowestOperations},
+		{"Schema Anti-Patterns", a.SchemaAntipatterns},
+		{"Query Anti-Patterns", a.QueryAntipatterns},
+		{"Missing Indexes", a.MissingIndexes},
+	}
+	if len(a.RedundantIndexes) > 0 {
+		sections = append(sections, analysisSection{"Redundant Indexes", a.RedundantIndexes})
+	}
+	return sections
+}
+
+// Text returns a as a plain-text list of findings under a heading for each
+// field, preceded by the statement's fingerprint if it is known.
+func (a *Analysis) Text() string {
+	var buf strings.Builder
+	if a.Fingerprint != "" {
+		fmt.Fprintf(&buf, "Fingerprint: %s\n\n", a.Fingerprint)
+	}
+	if e := a.execution(); e.Known() {
+		fmt.Fprintf(&buf, "Execution: %s\n\n", e)
+	}
+	for i, section := range a.sections() {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		fmt.Fprintf(&buf, "%s:\n", strings.ToUpper(section.heading[:1])+strings.ToLower(section.heading[1:]))
+		if len(section.findings) == 0 {
+			buf.WriteString("- None found.\n")
+		}
+		for _, f := range section.findings {
+			fmt.Fprintf(&buf, "- %s\n", f)
+			if f.Rationale != "" {
+				fmt.Fprintf(&buf, "  Why: %s\n", f.Rationale)
+			}
+			if f.ExpectedImpact != "" {
+				fmt.Fprintf(&buf, "  Expected impact: %s\n", f.ExpectedImpact)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// ParseAnalysis parses the model's structured reply. Any text surrounding the
+// JSON object, such as a Markdown code fence, is ignored.
+func ParseAnalysis(reply string) (*Analysis, error) {
+	obj, err := extractObject(reply)
+	if err != nil {
+		return nil, err
+	}
+	var a Analysis
+	dec := json.NewDecoder(strings.NewReader(obj))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&a); err != nil {
+		return nil, fmt.Errorf("model replied with malformed JSON: %v", err)
+	}
+	return &a, nil
+}
+
+// extractObject returns the JSON object in the model's reply, without any
+// text surrounding it.
+func extractObject(reply string) (string, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("model did not reply with a JSON object: %q", reply)
+	}
+	return reply[start : end+1], nil
+}
@@ -0,0 +1,180 @@
+package analyze
+
+import "strings"
+
+// redactedLiteral replaces each literal removed by RedactLiterals.
+const redactedLiteral = "$REDACTED"
+
+// RedactLiterals replaces the string and numeric literals in the SQL statement
+// sql, including dollar-quoted strings, with redactedLiteral, preserving identifiers, keywords, placeholders,
+// and comments so that the structure of the query is unchanged.
+func RedactLiterals(sql string) string {
+	var buf strings.Builder
+	buf.Grow(len(sql))
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '\'':
+			// String literal, with quotes escaped by doubling them.
+			i = skipQuoted(sql, i, '\'', false)
+			buf.WriteString(redactedLiteral)
+
+		case c == '"':
+			// Quoted identifier, with quotes escaped by doubling them.
+			end := skipQuoted(sql, i, '"', false)
+			buf.WriteString(sql[i:end])
+			i = end
+
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				end = len(sql) - i
+			}
+			buf.WriteString(sql[i : i+end])
+			i += end
+
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				end = len(sql)
+			} else {
+				end += i + 4
+			}
+			buf.WriteString(sql[i:end])
+			i = end
+
+		case c == '$' && dollarQuoteDelimiter(sql[i:]) != "":
+			// Dollar-quoted string literal, such as $$it's$$ or
+			// $tag$it's$tag$, which ends at the next occurrence of its
+			// opening delimiter.
+			delim := dollarQuoteDelimiter(sql[i:])
+			if end := strings.Index(sql[i+len(delim):], delim); end >= 0 {
+				i += len(delim) + end + len(delim)
+			} else {
+				i = len(sql)
+			}
+			buf.WriteString(redactedLiteral)
+
+		case c == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			// Placeholder, such as $1.
+			end := i + 1
+			for end < len(sql) && isDigit(sql[end]) {
+				end++
+			}
+			buf.WriteString(sql[i:end])
+			i = end
+
+		case isIdentStart(c):
+			end := i + 1
+			for end < len(sql) && isIdentChar(sql[end]) {
+				end++
+			}
+			// A single-letter prefix directly before a quote is part of an
+			// escape (e'...'), bit (b'...'), or hex (x'...') string literal.
+			if end == i+1 && end < len(sql) && sql[end] == '\'' && strings.ContainsRune("eEbBxX", rune(c)) {
+				i = skipQuoted(sql, end, '\'', c == 'e' || c == 'E')
+				buf.WriteString(redactedLiteral)
+				continue
+			}
+			buf.WriteString(sql[i:end])
+			i = end
+
+		case isDigit(c) || (c == '.' && i+1 < len(sql) && isDigit(sql[i+1])):
+			i = skipNumber(sql, i)
+			buf.WriteString(redactedLiteral)
+
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// skipQuoted returns the index just past the quoted token starting at sql[i].
+// A doubled quote is an escaped quote. If backslash is true, a backslash
+// also escapes the following character.
+func skipQuoted(sql string, i int, quote byte, backslash bool) int {
+	for i++; i < len(sql); i++ {
+		switch {
+		case backslash && sql[i] == '\\':
+			i++
+		case sql[i] == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// dollarQuoteDelimiter returns the delimiter of the dollar-quoted string
+// literal that sql starts with, such as "$$" or "$tag$", or "" if it doesn't
+// start with one. The tag follows the rules of an identifier, except that it
+// can't contain a dollar sign, so a placeholder such as $1 isn't a delimiter.
+func dollarQuoteDelimiter(sql string) string {
+	if len(sql) < 2 || sql[0] != '$' {
+		return ""
+	}
+	if sql[1] == '$' {
+		return "$$"
+	}
+	if !isIdentStart(sql[1]) {
+		return ""
+	}
+	for i := 2; i < len(sql); i++ {
+		switch {
+		case sql[i] == '$':
+			return sql[:i+1]
+		case !isIdentStart(sql[i]) && !isDigit(sql[i]):
+			return ""
+		}
+	}
+	return ""
+}
+
+// skipNumber returns the index just past the numeric literal starting at
+// sql[i], including hexadecimal literals, decimals, and exponents.
+func skipNumber(sql string, i int) int {
+	if strings.HasPrefix(sql[i:], "0x") || strings.HasPrefix(sql[i:], "0X") {
+		i += 2
+		for i < len(sql) && strings.IndexByte("0123456789abcdefABCDEF", sql[i]) >= 0 {
+			i++
+		}
+		return i
+	}
+	for i < len(sql) && (isDigit(sql[i]) || sql[i] == '.') {
+		// Stop at a second dot, as in the range 1..2 or a cast like 1::INT.
+		if sql[i] == '.' && i+1 < len(sql) && sql[i+1] == '.' {
+			return i
+		}
+		i++
+	}
+	if i < len(sql) && (sql[i] == 'e' || sql[i] == 'E') {
+		j := i + 1
+		if j < len(sql) && (sql[j] == '+' || sql[j] == '-') {
+			j++
+		}
+		if j < len(sql) && isDigit(sql[j]) {
+			for j < len(sql) && isDigit(sql[j]) {
+				j++
+			}
+			i = j
+		}
+	}
+	return i
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
@@ -0,0 +1,102 @@
+package analyze
+
+import "testing"
+
+func TestRedactLiterals(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{
+			name: "string and number",
+			sql:  "SELECT * FROM users WHERE name = 'Alice' AND age > 30",
+			want: "SELECT * FROM users WHERE name = $REDACTED AND age > $REDACTED",
+		},
+		{
+			name: "escaped quote",
+			sql:  "SELECT * FROM users WHERE name = 'O''Brien' AND id = 1",
+			want: "SELECT * FROM users WHERE name = $REDACTED AND id = $REDACTED",
+		},
+		{
+			name: "quoted identifier",
+			sql:  `SELECT "Name", "col 2" FROM "Users" WHERE "Name" = 'x'`,
+			want: `SELECT "Name", "col 2" FROM "Users" WHERE "Name" = $REDACTED`,
+		},
+		{
+			name: "quoted identifier with escaped quote",
+			sql:  `SELECT "a""b" FROM t WHERE "a""b" = 'c''d'`,
+			want: `SELECT "a""b" FROM t WHERE "a""b" = $REDACTED`,
+		},
+		{
+			name: "digits in identifiers",
+			sql:  "SELECT col1, t2.col_3 FROM t2 WHERE col1 = 42",
+			want: "SELECT col1, t2.col_3 FROM t2 WHERE col1 = $REDACTED",
+		},
+		{
+			name: "decimals, exponents, and hex",
+			sql:  "SELECT 1.5, .5, 2e-3, 0xFF FROM t",
+			want: "SELECT $REDACTED, $REDACTED, $REDACTED, $REDACTED FROM t",
+		},
+		{
+			name: "cast",
+			sql:  "SELECT 1::INT8, '2024-01-01'::DATE",
+			want: "SELECT $REDACTED::INT8, $REDACTED::DATE",
+		},
+		{
+			name: "escape, bit, and hex strings",
+			sql:  `SELECT e'it\'s', b'101', x'ff'`,
+			want: "SELECT $REDACTED, $REDACTED, $REDACTED",
+		},
+		{
+			name: "placeholders",
+			sql:  "SELECT * FROM t WHERE a = $1 AND b IN ($2, 3)",
+			want: "SELECT * FROM t WHERE a = $1 AND b IN ($2, $REDACTED)",
+		},
+		{
+			name: "comments",
+			sql:  "SELECT 1 -- limit 10\n/* id = 5 */ FROM t",
+			want: "SELECT $REDACTED -- limit 10\n/* id = 5 */ FROM t",
+		},
+		{
+			name: "dollar-quoted string",
+			sql:  "SELECT * FROM users WHERE name = $$O'Brien$$ AND id = 1",
+			want: "SELECT * FROM users WHERE name = $REDACTED AND id = $REDACTED",
+		},
+		{
+			name: "empty dollar-quoted string",
+			sql:  "SELECT $$$$, 'x'",
+			want: "SELECT $REDACTED, $REDACTED",
+		},
+		{
+			name: "tagged dollar-quoted string",
+			sql:  "SELECT $tag$secret $$ still secret$tag$::STRING, $_1$ssn$_1$",
+			want: "SELECT $REDACTED::STRING, $REDACTED",
+		},
+		{
+			name: "dollar-quoted string with placeholders and comments",
+			sql:  "SELECT * FROM t WHERE a = $1 AND b = $q$-- not a comment $2$q$ -- $$x$$\n",
+			want: "SELECT * FROM t WHERE a = $1 AND b = $REDACTED -- $$x$$\n",
+		},
+		{
+			name: "unterminated dollar-quoted string",
+			sql:  "SELECT $abc$ secret",
+			want: "SELECT $REDACTED",
+		},
+		{
+			// A dollar sign in an identifier doesn't start a string.
+			name: "dollar sign in identifier",
+			sql:  "SELECT a$b, c$ FROM t$1 WHERE d = 5",
+			want: "SELECT a$b, c$ FROM t$1 WHERE d = $REDACTED",
+		},
+		{
+			name: "unterminated string",
+			sql:  "SELECT 'abc",
+			want: "SELECT $REDACTED",
+		},
+	} {
+		if got := RedactLiterals(tc.sql); got != tc.want {
+			t.Errorf("%s: RedactLiterals(%q) = %q, want %q", tc.name, tc.sql, got, tc.want)
+		}
+	}
+}
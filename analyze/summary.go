@@ -0,0 +1,63 @@
+package analyze
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// summaryInstructions follows the analysis to ask for a one-line summary of
+// it, such as for an incident channel. The verb is the maximum length.
+const summaryInstructions = `Summarize your analysis in a single sentence of at most %d characters for a chat channel, naming the most important problem and the fix for it, such as "Full scan on orders; add index on (customer_id, created_at)." Reply with only the sentence, without Markdown.`
+
+// Summarize asks the model to summarize the analysis, the last message of the
+// conversation, in a single line of at most maxChars characters, or of any
+// length if maxChars is 0. A longer reply is shortened at a word boundary. It
+// also returns the completion of the request, whose usage is in addition to
+// that of the analysis.
+func Summarize(ctx context.Context, a Analyzer, conversation []Message, maxChars int) (string, *Completion, error) {
+	limit := maxChars
+	if limit <= 0 {
+		limit = 200
+	}
+	messages := append(conversation[:len(conversation):len(conversation)], Message{
+		Role:    RoleUser,
+		Content: fmt.Sprintf(summaryInstructions, limit),
+	})
+	comp, err := a.Chat(ctx, messages)
+	if err != nil {
+		return "", nil, err
+	}
+	summary := shortenSummary(comp.Content, maxChars)
+	if summary == "" {
+		return "", comp, fmt.Errorf("model replied with an empty summary")
+	}
+	return summary, comp, nil
+}
+
+// shortenSummary returns the first non-empty line of the reply, without
+// surrounding quotes or a "Summary:" label, cut at a word boundary and ended
+// with an ellipsis if it is longer than maxChars characters.
+func shortenSummary(reply string, maxChars int) string {
+	var s string
+	for _, line := range strings.Split(reply, "\n") {
+		if s = strings.TrimSpace(line); s != "" {
+			break
+		}
+	}
+	if label, rest, ok := strings.Cut(s, ":"); ok && strings.EqualFold(strings.Trim(label, "*_ "), "summary") {
+		s = strings.TrimSpace(rest)
+	}
+	s = strings.Trim(s, "\"'`*_ ")
+	s = strings.Join(strings.Fields(s), " ")
+	if maxChars <= 0 || utf8.RuneCountInString(s) <= maxChars {
+		return s
+	}
+	runes := []rune(s)[:max(maxChars-1, 0)]
+	cut := string(runes)
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:.") + "…"
+}
@@ -0,0 +1,22 @@
+package analyze
+
+import "testing"
+
+func TestShortenSummary(t *testing.T) {
+	for _, tc := range []struct {
+		reply    string
+		maxChars int
+		want     string
+	}{
+		{reply: "Full scan on orders; add index on (customer_id, created_at).\n", maxChars: 200, want: "Full scan on orders; add index on (customer_id, created_at)."},
+		{reply: "\n**Summary:** \"Full scan on orders.\"\n\nMore details follow.", maxChars: 200, want: "Full scan on orders."},
+		{reply: "Full   scan on\torders.", maxChars: 0, want: "Full scan on orders."},
+		{reply: "Full scan on orders; add index on (customer_id, created_at).", maxChars: 30, want: "Full scan on orders; add…"},
+		{reply: "Überprüfung von orders nötig.", maxChars: 29, want: "Überprüfung von orders nötig."},
+		{reply: "", maxChars: 200, want: ""},
+	} {
+		if got := shortenSummary(tc.reply, tc.maxChars); got != tc.want {
+			t.Errorf("shortenSummary(%q, %d) = %q, want %q", tc.reply, tc.maxChars, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// charsPerToken is a rough estimate of the number of characters in a token.
+const charsPerToken = 4
+
+// EstimateTokens returns a rough estimate of the number of tokens in s.
+func EstimateTokens(s string) int {
+	return (len(s) + charsPerToken - 1) / charsPerToken
+}
+
+// fileTokenFloor is the number of tokens that each file keeps before the rest
+// of the budget is shared out, so that a large plan.txt can't crowd out the
+// schema or the statement entirely.
+const fileTokenFloor = 500
+
+// sectionTokens is the estimated number of tokens in a file of the prompt
+// before and after it was truncated to fit the budget.
+type sectionTokens struct {
+	name   string
+	tokens int
+	kept   int
+}
+
+// truncateToBudget truncates the contents of files, keyed by name, until their
+// estimated total number of tokens is within budget, sharing the budget out
+// fairly. Each file is allowed up to fileTokenFloor tokens, or an equal share
+// of the budget if that is less, and the rest of the budget is divided among
+// the files that need more in proportion to how much more they need, so that
+// the largest files are cut the most. It returns the tokens of each file from
+// largest to smallest, or nil if none had to be truncated.
+func truncateToBudget(files map[string]string, budget int) []sectionTokens {
+	sections := make([]sectionTokens, 0, len(files))
+	total := 0
+	for name, content := range files {
+		tokens := EstimateTokens(content)
+		sections = append(sections, sectionTokens{name: name, tokens: tokens})
+		total += tokens
+	}
+	if total <= budget || len(sections) == 0 {
+		return nil
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		if sections[i].tokens != sections[j].tokens {
+			return sections[i].tokens > sections[j].tokens
+		}
+		return sections[i].name < sections[j].name
+	})
+
+	budget = max(budget, 0)
+	floor := min(fileTokenFloor, budget/len(sections))
+	remaining, need := budget, 0
+	for i := range sections {
+		sections[i].kept = min(sections[i].tokens, floor)
+		remaining -= sections[i].kept
+		need += sections[i].tokens - sections[i].kept
+	}
+	// The files need more than remaining, since they don't fit, so each
+	// gets less than it needs and the shares add up to at most remaining.
+	for i, s := range sections {
+		if need > 0 {
+			sections[i].kept += (s.tokens - s.kept) * remaining / need
+		}
+		if sections[i].kept < s.tokens {
+			files[s.name] = truncateMiddle(files[s.name], sections[i].kept*charsPerToken)
+			sections[i].kept = EstimateTokens(files[s.name])
+		}
+	}
+	return sections
+}
+
+// summarizeSections returns a line listing the tokens that each file kept
+// after being truncated by truncateToBudget, such as "plan.txt 1200 of 9000
+// tokens, schema.sql 800 tokens".
+func summarizeSections(sections []sectionTokens) string {
+	parts := make([]string, len(sections))
+	for i, s := range sections {
+		if s.kept < s.tokens {
+			parts[i] = fmt.Sprintf("%s %d of %d tokens", s.name, s.kept, s.tokens)
+		} else {
+			parts[i] = fmt.Sprintf("%s %d tokens", s.name, s.kept)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// truncateMiddle returns s with bytes removed from its middle so that at most
+// keep bytes of it remain, replaced by a marker noting how many bytes were
+// removed. The cut is made on line boundaries where possible.
+func truncateMiddle(s string, keep int) string {
+	if len(s) <= keep {
+		return s
+	}
+	head := s[:keep/2]
+	tail := s[len(s)-keep/2:]
+	if i := strings.LastIndexByte(head, '\n'); i >= 0 {
+		head = head[:i+1]
+	}
+	if i := strings.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	removed := len(s) - len(head) - len(tail)
+	return fmt.Sprintf("%s... [truncated %d bytes] ...\n%s", head, removed, tail)
+}
@@ -0,0 +1,42 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestTruncateToBudgetFairShare checks that a huge plan.txt doesn't crowd the
+// other files out of the budget.
+func TestTruncateToBudgetFairShare(t *testing.T) {
+	line := strings.Repeat("x", 79) + "\n"
+	files := map[string]string{
+		"plan.txt":      strings.Repeat(line, 2000),
+		"schema.sql":    strings.Repeat(line, 500),
+		"statement.sql": "SELECT * FROM t WHERE a = 1;\n",
+	}
+	sections := truncateToBudget(files, 6000)
+
+	total := 0
+	for _, content := range files {
+		total += EstimateTokens(content)
+	}
+	if total > 6000+2*EstimateTokens("... [truncated 100000 bytes] ...\n") {
+		t.Errorf("total = %d tokens, want about 6000", total)
+	}
+	if files["statement.sql"] != "SELECT * FROM t WHERE a = 1;\n" {
+		t.Errorf("statement.sql was truncated: %q", files["statement.sql"])
+	}
+	schema, plan := EstimateTokens(files["schema.sql"]), EstimateTokens(files["plan.txt"])
+	if schema < fileTokenFloor || plan < 3*schema {
+		t.Errorf("schema.sql kept %d tokens and plan.txt %d, want a share of the budget proportional to their size", schema, plan)
+	}
+	want := fmt.Sprintf("plan.txt %d of 40000 tokens, schema.sql %d of 10000 tokens, statement.sql 8 tokens", plan, schema)
+	if got := summarizeSections(sections); got != want {
+		t.Errorf("summarizeSections = %q, want %q", got, want)
+	}
+
+	if sections := truncateToBudget(files, 1e6); sections != nil {
+		t.Errorf("truncateToBudget truncated files that fit: %v", sections)
+	}
+}
@@ -0,0 +1,66 @@
+package analyze
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Usage is the number of tokens consumed by a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// modelPrice is the price of a model in US dollars per million tokens.
+type modelPrice struct {
+	prompt     float64
+	completion float64
+}
+
+// modelPrices is the price of known models, keyed by model name. Dated
+// snapshots of a model, such as gpt-4o-2024-08-06, use the price of the
+// longest name that is a prefix of the snapshot's name.
+var modelPrices = map[string]modelPrice{
+	"gpt-4":             {prompt: 30, completion: 60},
+	"gpt-4-32k":         {prompt: 60, completion: 120},
+	"gpt-4-turbo":       {prompt: 10, completion: 30},
+	"gpt-4o":            {prompt: 2.5, completion: 10},
+	"gpt-4o-mini":       {prompt: 0.15, completion: 0.6},
+	"gpt-4.1":           {prompt: 2, completion: 8},
+	"gpt-4.1-mini":      {prompt: 0.4, completion: 1.6},
+	"gpt-3.5-turbo":     {prompt: 0.5, completion: 1.5},
+	"claude-opus-4-1":   {prompt: 15, completion: 75},
+	"claude-sonnet-4-5": {prompt: 3, completion: 15},
+	"claude-haiku-4-5":  {prompt: 1, completion: 5},
+	"gemini-2.5-pro":    {prompt: 1.25, completion: 10},
+	"gemini-2.5-flash":  {prompt: 0.3, completion: 2.5},
+}
+
+// priceOf returns the price of model and true, or false if the price of the
+// model is unknown.
+func priceOf(model string) (modelPrice, bool) {
+	if p, ok := modelPrices[model]; ok {
+		return p, true
+	}
+	var best string
+	for name := range modelPrices {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+	p, ok := modelPrices[best]
+	return p, ok
+}
+
+// SummarizeUsage returns a one-line summary of u, including the estimated
+// cost of the tokens if the price of model is known.
+func SummarizeUsage(model string, u Usage) string {
+	summary := fmt.Sprintf("tokens: %d prompt + %d completion", u.PromptTokens, u.CompletionTokens)
+	p, ok := priceOf(model)
+	if !ok {
+		return summary + "; est. cost unknown for " + model
+	}
+	cost := (float64(u.PromptTokens)*p.prompt + float64(u.CompletionTokens)*p.completion) / 1e6
+	return fmt.Sprintf("%s; est. cost $%.2f", summary, cost)
+}
@@ -0,0 +1,331 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Magic bytes identifying the supported archive formats.
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	// tarMagic is found at tarMagicOffset in POSIX and GNU tar archives.
+	tarMagic       = []byte("ustar")
+	tarMagicOffset = 257
+)
+
+// Default limits of the files extracted from an archive.
+const (
+	DefaultMaxFileSize  = 10 << 20
+	DefaultMaxTotalSize = 100 << 20
+	DefaultMaxFiles     = 10000
+)
+
+// Limits bounds the uncompressed size and number of the files extracted from
+// an archive, protecting against corrupt archives and zip bombs. A zero limit
+// disables the check.
+type Limits struct {
+	// MaxFileSize is the maximum size of a single file, in bytes.
+	MaxFileSize int64
+	// MaxTotalSize is the maximum combined size of all files, in bytes.
+	MaxTotalSize int64
+	// MaxFiles is the maximum number of files. A bundle holds a few dozen,
+	// but an archive of millions of empty files stays within the size
+	// limits while taking as long to read.
+	MaxFiles int
+}
+
+// DefaultLimits are the limits used when none are specified.
+var DefaultLimits = Limits{
+	MaxFileSize:  DefaultMaxFileSize,
+	MaxTotalSize: DefaultMaxTotalSize,
+	MaxFiles:     DefaultMaxFiles,
+}
+
+// Extract detects the format of the archive in data from its magic bytes and
+// returns the contents of its files, keyed by name. Zip, tar, and
+// gzip-compressed tar archives are supported. An error is returned if a file
+// exceeds the given limits. If some, but not all, of the files in a zip
+// archive can't be read, the others are returned along with an
+// *UnreadableError.
+func Extract(data []byte, limits Limits) (map[string]string, error) {
+	a, err := openArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	if a.zip != nil {
+		return unzipInMemory(a.zip, limits)
+	}
+	return untarInMemory(a.tar, limits)
+}
+
+// Entry describes a file in an archive.
+type Entry struct {
+	Name string
+	// Size is the uncompressed size of the file, in bytes.
+	Size int64
+}
+
+// List returns the files in the archive in data, in the order they are stored,
+// without extracting them. See Extract for the supported formats.
+func List(data []byte) ([]Entry, error) {
+	a, err := openArchive(data)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	if a.zip != nil {
+		for _, file := range a.zip.File {
+			if !file.FileInfo().IsDir() {
+				entries = append(entries, Entry{Name: file.Name, Size: file.FileInfo().Size()})
+			}
+		}
+		return entries, nil
+	}
+	reader := tar.NewReader(a.tar)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			entries = append(entries, Entry{Name: strings.TrimPrefix(hdr.Name, "./"), Size: hdr.Size})
+		}
+	}
+}
+
+// archive is an opened archive. Exactly one of zip and tar is set.
+type archive struct {
+	zip *zip.Reader
+	// tar is the uncompressed tar archive.
+	tar io.Reader
+}
+
+// openArchive detects the format of the archive in data from its magic bytes
+// and opens it.
+func openArchive(data []byte) (*archive, error) {
+	switch {
+	case bytes.HasPrefix(data, zipMagic), bytes.HasPrefix(data, zipEmptyMagic):
+		reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		return &archive{zip: reader}, nil
+	case bytes.HasPrefix(data, gzipMagic):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		// Read the tar archive as it is decompressed rather than
+		// decompressing it into memory, so that the limits apply.
+		br := bufio.NewReader(gz)
+		header, _ := br.Peek(tarMagicOffset + len(tarMagic))
+		if !isTar(header) {
+			return nil, fmt.Errorf("gzip-compressed data is not a tar archive")
+		}
+		return &archive{tar: br}, nil
+	case isTar(data):
+		return &archive{tar: bytes.NewReader(data)}, nil
+	default:
+		return nil, fmt.Errorf(
+			"unrecognized archive format (magic bytes % x); expected zip, tar, or tar.gz",
+			data[:min(len(data), 8)],
+		)
+	}
+}
+
+// isTar returns true if data starts with a tar header.
+func isTar(data []byte) bool {
+	end := tarMagicOffset + len(tarMagic)
+	return len(data) >= end && bytes.Equal(data[tarMagicOffset:end], tarMagic)
+}
+
+// ErrEncrypted is returned by Extract for a zip archive whose files are all
+// encrypted, such as a bundle that was re-zipped with a password.
+var ErrEncrypted = errors.New("bundle appears to be password-protected; bundlebot cannot read encrypted archives")
+
+// zipFlagEncrypted is the bit of a zip file header's general purpose flags
+// that is set if the file is encrypted, with either traditional PKWARE or AES
+// encryption.
+const zipFlagEncrypted = 0x1
+
+// UnreadableError is returned by Extract, along with the files that could be
+// read, when some of the files in an archive could not be read, such as
+// because they are corrupt or compressed with an unsupported method.
+type UnreadableError struct {
+	// Files holds the error reading each unreadable file, keyed by name.
+	Files map[string]error
+}
+
+func (e *UnreadableError) Error() string {
+	names := make([]string, 0, len(e.Files))
+	for name := range e.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = e.Files[name].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// limitError is returned when reading a file would exceed the Limits.
+type limitError struct {
+	msg string
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+// sizeChecker enforces Limits on the files read from a single archive.
+type sizeChecker struct {
+	limits Limits
+	total  int64
+	files  int
+}
+
+// read returns the contents of the named file read from r, or an error if
+// reading it would exceed the limits.
+func (c *sizeChecker) read(name string, r io.Reader) (string, error) {
+	c.files++
+	if c.limits.MaxFiles > 0 && c.files > c.limits.MaxFiles {
+		return "", &limitError{fmt.Sprintf("bundle contains more than the maximum of %d files", c.limits.MaxFiles)}
+	}
+	// limit is the number of bytes the file may contain, or -1 if it is
+	// unlimited.
+	limit := int64(-1)
+	if c.limits.MaxFileSize > 0 {
+		limit = c.limits.MaxFileSize
+	}
+	if c.limits.MaxTotalSize > 0 {
+		remaining := c.limits.MaxTotalSize - c.total
+		if limit < 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	if limit >= 0 {
+		// Read one byte past the limit to detect files that exceed it.
+		r = io.LimitReader(r, limit+1)
+	}
+
+	buf := new(strings.Builder)
+	n, err := io.Copy(buf, r)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	if limit >= 0 && n > limit {
+		if c.limits.MaxFileSize > 0 && n > c.limits.MaxFileSize {
+			return "", &limitError{fmt.Sprintf("%s exceeds the maximum file size of %d bytes", name, c.limits.MaxFileSize)}
+		}
+		return "", &limitError{fmt.Sprintf("%s exceeds the maximum total size of %d bytes", name, c.limits.MaxTotalSize)}
+	}
+	c.total += n
+	return buf.String(), nil
+}
+
+// unzipInMemory returns the contents of the files in the zip archive, keyed by
+// name. A file that can't be read, including an encrypted one, is skipped, and
+// the errors reading them are returned in an *UnreadableError, unless no files
+// could be read at all. ErrEncrypted is returned if every file is encrypted.
+// Exceeding the limits is always an error.
+func unzipInMemory(reader *zip.Reader, limits Limits) (map[string]string, error) {
+	checker := sizeChecker{limits: limits}
+	files := make(map[string]string)
+	unreadable := make(map[string]error)
+	encrypted := 0
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if file.Flags&zipFlagEncrypted != 0 {
+			// Reading it would fail with an opaque error about corrupt
+			// or unsupported data.
+			unreadable[file.Name] = fmt.Errorf("%s: file is password-protected", file.Name)
+			encrypted++
+			continue
+		}
+		content, err := readZipFile(file, &checker)
+		var limitErr *limitError
+		if errors.As(err, &limitErr) {
+			return nil, err
+		}
+		if err != nil {
+			unreadable[file.Name] = err
+			continue
+		}
+
+		files[file.Name] = content
+	}
+	if len(unreadable) == 0 {
+		return files, nil
+	}
+	if len(files) == 0 && encrypted == len(unreadable) {
+		return nil, ErrEncrypted
+	}
+	err := &UnreadableError{Files: unreadable}
+	if len(files) == 0 {
+		// Not an *UnreadableError, since there are no files to fall back
+		// on.
+		return nil, fmt.Errorf("no files could be read: %v", err)
+	}
+	return files, err
+}
+
+// readZipFile returns the contents of the file in a zip archive, read with
+// checker. The file is closed as soon as it is read, rather than when the
+// whole archive is, and an error closing it is returned like an error reading
+// it.
+func readZipFile(file *zip.File, checker *sizeChecker) (content string, err error) {
+	rc, err := file.Open()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", file.Name, err)
+	}
+	defer func() {
+		if closeErr := rc.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("%s: %w", file.Name, closeErr)
+		}
+	}()
+	return checker.read(file.Name, rc)
+}
+
+// untarInMemory returns the contents of the regular files in the tar archive
+// read from r, keyed by name.
+func untarInMemory(r io.Reader, limits Limits) (map[string]string, error) {
+	reader := tar.NewReader(r)
+	checker := sizeChecker{limits: limits}
+	files := make(map[string]string)
+	for {
+		hdr, err := reader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(hdr.Name, "./")
+		content, err := checker.read(name, reader)
+		if err != nil {
+			return nil, err
+		}
+
+		files[name] = content
+	}
+}
@@ -0,0 +1,251 @@
+// Package bundle reads the files in CockroachDB statement bundles.
+package bundle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FileNames is the list of files to use for analysis.
+var FileNames = [...]string{"schema.sql", "statement.sql", "plan.txt"}
+
+// ExtraFileNames is the list of additional files that may optionally be used
+// for analysis.
+var ExtraFileNames = [...]string{"env.sql", "opt.txt"}
+
+// statementNumberRE matches the number in the name of a statement file, such as
+// the 2 in statement-2.sql.
+var statementNumberRE = regexp.MustCompile(`\d+`)
+
+// IsStatementFile returns true if name is a file holding one of the bundle's
+// statements: statement.sql, or a name matching statement*.sql, such as
+// statement-2.sql, when several statements were captured together.
+func IsStatementFile(name string) bool {
+	ok, _ := path.Match("statement*.sql", name)
+	return ok
+}
+
+// StatementFileNames returns the names of the statement files in files,
+// ordered by the number in their name. statement.sql comes first, since it is
+// the first statement, and names without a number come last in sorted order.
+func StatementFileNames(files map[string]string) []string {
+	var names []string
+	for name := range files {
+		if IsStatementFile(name) {
+			names = append(names, name)
+		}
+	}
+	number := func(name string) int {
+		if name == "statement.sql" {
+			return 1
+		}
+		if n, err := strconv.Atoi(statementNumberRE.FindString(name)); err == nil {
+			return n
+		}
+		return math.MaxInt
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ni, nj := number(names[i]), number(names[j])
+		if ni != nj {
+			return ni < nj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// ErrEmpty is returned by Read when the reader contains no data.
+var ErrEmpty = errors.New("bundle is empty")
+
+// Read reads an archive from r and returns the contents of its files, keyed by
+// name. See Extract for the supported formats and limits.
+func Read(r io.Reader, limits Limits) (map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrEmpty
+	}
+	return Extract(data, limits)
+}
+
+// Validate returns an error if files contains none of the files used for
+// analysis.
+func Validate(files map[string]string) error {
+	for _, name := range FileNames {
+		if _, ok := files[name]; ok {
+			return nil
+		}
+	}
+	if len(StatementFileNames(files)) > 0 {
+		return nil
+	}
+	present := make([]string, 0, len(files))
+	for name := range files {
+		present = append(present, name)
+	}
+	sort.Strings(present)
+	found := "none"
+	if len(present) > 0 {
+		found = strings.Join(present, ", ")
+	}
+	return fmt.Errorf(
+		"bundle contains no analyzable files (expected %s); found: %s",
+		strings.Join(FileNames[:], ", "), found,
+	)
+}
+
+// signatureFileNames are the files found in every CockroachDB statement
+// bundle, at least one of which a bundle must contain to look like one.
+var signatureFileNames = [...]string{"statement.sql", "plan.txt", "env.sql", "schema.sql"}
+
+// explainHeaderKeys are the keys of the header lines that EXPLAIN and EXPLAIN
+// ANALYZE print before the operator tree, one of which starts the plan.txt of
+// a statement bundle.
+var explainHeaderKeys = []string{
+	"planning time", "execution time", "distribution", "vectorized", "plan type", "rows decoded from KV",
+}
+
+// MissingMarkers returns a description of each of the markers of a
+// CockroachDB statement bundle that files lack, or nil if files look like a
+// statement bundle. The markers are at least one of the files that every
+// bundle contains, a plan.txt that starts with the header printed by EXPLAIN
+// ANALYZE, and an env.sql that names the CockroachDB version.
+func MissingMarkers(files map[string]string) []string {
+	var missing []string
+	found := false
+	for _, name := range signatureFileNames {
+		if _, ok := files[name]; ok {
+			found = true
+		}
+	}
+	if !found {
+		missing = append(missing, fmt.Sprintf("none of %s found", strings.Join(signatureFileNames[:], ", ")))
+	}
+	if planText, ok := files["plan.txt"]; ok && !hasExplainHeader(planText) {
+		missing = append(missing, "plan.txt does not start with an EXPLAIN header such as \"planning time:\"")
+	}
+	if env, ok := files["env.sql"]; ok && !strings.Contains(env, "CockroachDB") {
+		missing = append(missing, "env.sql does not name a CockroachDB version")
+	}
+	return missing
+}
+
+// hasExplainHeader returns true if the first non-blank line of planText is a
+// line of the header of EXPLAIN output, or the root of its operator tree.
+func hasExplainHeader(planText string) bool {
+	for _, line := range strings.Split(planText, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "•") {
+			return true
+		}
+		key, _, ok := strings.Cut(line, ":")
+		return ok && slices.Contains(explainHeaderKeys, key)
+	}
+	return false
+}
+
+// StatementOnly returns true if files hold a statement without the plan and
+// schema that the rest of the analysis relies on, such as a lone .sql file
+// rather than a bundle.
+func StatementOnly(files map[string]string) bool {
+	_, hasStatement := files["statement.sql"]
+	_, hasPlan := files["plan.txt"]
+	_, hasSchema := files["schema.sql"]
+	return hasStatement && !hasPlan && !hasSchema
+}
+
+// versionRE matches a CockroachDB version, such as "CockroachDB CCL v23.1.2"
+// or a bare "23.1.2", capturing the version number.
+var versionRE = regexp.MustCompile(`(?:CockroachDB(?:\s+(?:CCL|OSS))?\s+)?\bv?(\d+\.\d+(?:\.\d+)?(?:-[0-9A-Za-z.-]+)?)\b`)
+
+// Version returns the CockroachDB version the bundle was collected from, such
+// as "v23.1.2", read from the bundle's env.sql or version.txt. It returns
+// false if the version cannot be found.
+func Version(files map[string]string) (string, bool) {
+	if env, ok := files["env.sql"]; ok {
+		for _, line := range strings.Split(env, "\n") {
+			if !strings.Contains(line, "CockroachDB") {
+				continue
+			}
+			if m := versionRE.FindStringSubmatch(line); m != nil {
+				return "v" + m[1], true
+			}
+		}
+	}
+	if v, ok := files["version.txt"]; ok {
+		if m := versionRE.FindStringSubmatch(v); m != nil {
+			return "v" + m[1], true
+		}
+	}
+	return "", false
+}
+
+// IsBinary returns true if content appears to be binary rather than text,
+// because it contains a null byte or is not valid UTF-8.
+func IsBinary(content string) bool {
+	return strings.IndexByte(content, 0) >= 0 || !utf8.ValidString(content)
+}
+
+// RemoveBinary removes the files that appear to be binary from files, keyed by
+// name, and returns their names in sorted order.
+func RemoveBinary(files map[string]string) []string {
+	var removed []string
+	for name, content := range files {
+		if IsBinary(content) {
+			delete(files, name)
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// Flatten renames each file in files to its base name, so that a bundle
+// whose files are nested in a directory, such as bundle-12345/schema.sql, can
+// be read like one whose files are not. If several files share a base name,
+// the shallowest is kept, or the first in sorted order if they are equally
+// deep, and the names of the others are removed and returned in sorted order.
+func Flatten(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		di, dj := strings.Count(names[i], "/"), strings.Count(names[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return names[i] < names[j]
+	})
+
+	flat := make(map[string]string, len(files))
+	var removed []string
+	for _, name := range names {
+		base := path.Base(name)
+		if _, ok := flat[base]; ok {
+			removed = append(removed, name)
+			continue
+		}
+		flat[base] = files[name]
+	}
+	clear(files)
+	for name, content := range flat {
+		files[name] = content
+	}
+	sort.Strings(removed)
+	return removed
+}
@@ -0,0 +1,502 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testFile is a file to write to an archive.
+type testFile struct {
+	name, content string
+}
+
+// testFiles are the files of a small bundle, nested in a directory.
+var testFiles = []testFile{
+	{"bundle/", ""},
+	{"bundle/statement.sql", "SELECT * FROM users WHERE id = 1;\n"},
+	{"bundle/plan.txt", "planning time: 1ms\n\n• scan\n  table: users@users_pkey\n"},
+	{"bundle/schema.sql", "CREATE TABLE users (id INT PRIMARY KEY);\n"},
+	{"bundle/empty.txt", ""},
+}
+
+// zipArchive returns a zip archive of files. Names ending in a slash are
+// directories.
+func zipArchive(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// tarArchive returns a tar archive of files, like zipArchive.
+func tarArchive(t *testing.T, files []testFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(f.name, "/") {
+			hdr = &tar.Header{Name: f.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gzipData returns data compressed with gzip.
+func gzipData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractFormats(t *testing.T) {
+	want := make(map[string]string)
+	var wantEntries []Entry
+	for _, f := range testFiles {
+		if !strings.HasSuffix(f.name, "/") {
+			want[f.name] = f.content
+			wantEntries = append(wantEntries, Entry{Name: f.name, Size: int64(len(f.content))})
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"zip", zipArchive(t, testFiles)},
+		{"tar", tarArchive(t, testFiles)},
+		{"tar.gz", gzipData(t, tarArchive(t, testFiles))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files, err := Extract(tc.data, DefaultLimits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(files, want) {
+				t.Errorf("Extract() = %q, want %q", files, want)
+			}
+			entries, err := List(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(entries, wantEntries) {
+				t.Errorf("List() = %v, want %v", entries, wantEntries)
+			}
+		})
+	}
+}
+
+func TestExtractTarDotSlash(t *testing.T) {
+	// tar archives made with "tar -C dir ." prefix their names with "./".
+	data := tarArchive(t, []testFile{{"./", ""}, {"./statement.sql", "SELECT 1;"}})
+	files, err := Extract(data, DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"statement.sql": "SELECT 1;"}; !maps.Equal(files, want) {
+		t.Errorf("Extract() = %q, want %q", files, want)
+	}
+	entries, err := List(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Entry{{Name: "statement.sql", Size: 9}}; !slices.Equal(entries, want) {
+		t.Errorf("List() = %v, want %v", entries, want)
+	}
+}
+
+func TestExtractEmptyZip(t *testing.T) {
+	// An empty zip archive starts with the end of central directory record
+	// rather than a file header.
+	files, err := Extract(zipArchive(t, nil), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got files %q, want none", files)
+	}
+}
+
+func TestExtractUnrecognized(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "text",
+			data: []byte("SELECT * FROM users;"),
+			err:  "unrecognized archive format (magic bytes 53 45 4c 45 43 54 20 2a); expected zip, tar, or tar.gz",
+		},
+		{
+			name: "short",
+			data: []byte{0x42},
+			err:  "unrecognized archive format (magic bytes 42); expected zip, tar, or tar.gz",
+		},
+		{
+			name: "gzip of something else",
+			data: gzipData(t, []byte("SELECT * FROM users;")),
+			err:  "gzip-compressed data is not a tar archive",
+		},
+		{
+			name: "truncated zip",
+			data: zipArchive(t, testFiles)[:40],
+			err:  "zip: not a valid zip file",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Extract(tc.data, DefaultLimits); err == nil || err.Error() != tc.err {
+				t.Errorf("Extract() error = %v, want %q", err, tc.err)
+			}
+			if _, err := List(tc.data); err == nil || err.Error() != tc.err {
+				t.Errorf("List() error = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	files := []testFile{
+		{"statement.sql", "0123456789"},
+		{"plan.txt", "0123456789"},
+		{"schema.sql", "0123456789"},
+	}
+	for _, tc := range []struct {
+		name   string
+		limits Limits
+		err    string
+	}{
+		{
+			name:   "within the limits",
+			limits: Limits{MaxFileSize: 10, MaxTotalSize: 30, MaxFiles: 3},
+		},
+		{
+			name:   "no limits",
+			limits: Limits{},
+		},
+		{
+			name:   "file too large",
+			limits: Limits{MaxFileSize: 9},
+			err:    "statement.sql exceeds the maximum file size of 9 bytes",
+		},
+		{
+			name:   "total too large",
+			limits: Limits{MaxFileSize: 10, MaxTotalSize: 25},
+			err:    "schema.sql exceeds the maximum total size of 25 bytes",
+		},
+		{
+			name:   "too many files",
+			limits: Limits{MaxFiles: 2},
+			err:    "bundle contains more than the maximum of 2 files",
+		},
+	} {
+		for _, format := range []struct {
+			name string
+			data []byte
+		}{
+			{"zip", zipArchive(t, files)},
+			{"tar", tarArchive(t, files)},
+			{"tar.gz", gzipData(t, tarArchive(t, files))},
+		} {
+			t.Run(tc.name+"/"+format.name, func(t *testing.T) {
+				got, err := Extract(format.data, tc.limits)
+				if tc.err == "" {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(got) != len(files) {
+						t.Errorf("got %d files, want %d", len(got), len(files))
+					}
+					return
+				}
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				if got != nil {
+					t.Errorf("got files %q along with the error, want none", got)
+				}
+			})
+		}
+	}
+}
+
+func TestExtractZipBomb(t *testing.T) {
+	// A file of zeros compresses to almost nothing, so only its
+	// uncompressed size gives it away.
+	data := zipArchive(t, []testFile{
+		{"statement.sql", "SELECT 1;"},
+		{"plan.txt", strings.Repeat("\x00", DefaultMaxFileSize+1)},
+	})
+	if len(data) > DefaultMaxFileSize/100 {
+		t.Fatalf("archive is %d bytes, want a small one", len(data))
+	}
+	want := "plan.txt exceeds the maximum file size of 10485760 bytes"
+	if _, err := Extract(data, DefaultLimits); err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestReadDirLimits(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"plan.txt", "schema.sql", "statement.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("0123456789"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		limits Limits
+		err    string
+	}{
+		{Limits{MaxFileSize: 9}, "plan.txt exceeds the maximum file size of 9 bytes"},
+		{Limits{MaxTotalSize: 25}, "statement.sql exceeds the maximum total size of 25 bytes"},
+		{Limits{MaxFiles: 2}, "bundle contains more than the maximum of 2 files"},
+	} {
+		if _, err := ReadDir(dir, tc.limits); err == nil || err.Error() != tc.err {
+			t.Errorf("ReadDir() with %+v: got error %v, want %q", tc.limits, err, tc.err)
+		}
+	}
+}
+
+// encryptedZip returns a zip archive of files like zipArchive, with the
+// encryption flag set on the named files. Their contents aren't actually
+// encrypted, since only the flag is checked.
+func encryptedZip(t *testing.T, files []testFile, encrypted ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		hdr := &zip.FileHeader{Name: f.name, Method: zip.Deflate}
+		if slices.Contains(encrypted, f.name) {
+			hdr.Flags |= zipFlagEncrypted
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractEncrypted(t *testing.T) {
+	files := []testFile{
+		{"statement.sql", "SELECT 1;"},
+		{"plan.txt", "• scan"},
+	}
+
+	data := encryptedZip(t, files, "statement.sql", "plan.txt")
+	got, err := Extract(data, DefaultLimits)
+	if !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("got error %v, want ErrEncrypted", err)
+	}
+	if want := "bundle appears to be password-protected; bundlebot cannot read encrypted archives"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if got != nil {
+		t.Errorf("got files %q, want none", got)
+	}
+
+	// When only some files are encrypted, the others are read.
+	data = encryptedZip(t, files, "plan.txt")
+	got, err = Extract(data, DefaultLimits)
+	var unreadable *UnreadableError
+	if !errors.As(err, &unreadable) {
+		t.Fatalf("got error %v, want an *UnreadableError", err)
+	}
+	if want := "plan.txt: file is password-protected"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if want := map[string]string{"statement.sql": "SELECT 1;"}; !maps.Equal(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+}
+
+// corruptZip returns a zip archive of files, stored without compression,
+// with the contents of the named files altered after their checksums were
+// computed.
+func corruptZip(t *testing.T, files []testFile, corrupt ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, f := range files {
+		if !slices.Contains(corrupt, f.name) {
+			continue
+		}
+		i := bytes.Index(data, []byte(f.content))
+		if i < 0 {
+			t.Fatalf("contents of %s not found in archive", f.name)
+		}
+		data[i] ^= 0xff
+	}
+	return data
+}
+
+func TestExtractUnreadable(t *testing.T) {
+	files := []testFile{
+		{"statement.sql", "SELECT * FROM users;"},
+		{"plan.txt", "• scan\n  table: users@users_pkey\n"},
+	}
+
+	got, err := Extract(corruptZip(t, files, "plan.txt"), DefaultLimits)
+	var unreadable *UnreadableError
+	if !errors.As(err, &unreadable) {
+		t.Fatalf("got error %v, want an *UnreadableError", err)
+	}
+	if len(unreadable.Files) != 1 || !errors.Is(unreadable.Files["plan.txt"], zip.ErrChecksum) {
+		t.Errorf("got unreadable files %v, want plan.txt with a checksum error", unreadable.Files)
+	}
+	if want := "plan.txt: zip: checksum error"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if want := map[string]string{"statement.sql": "SELECT * FROM users;"}; !maps.Equal(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+
+	// Without any files to fall back on, the error is not an
+	// *UnreadableError.
+	got, err = Extract(corruptZip(t, files, "statement.sql", "plan.txt"), DefaultLimits)
+	if err == nil || errors.As(err, &unreadable) {
+		t.Fatalf("got error %v, want an error other than *UnreadableError", err)
+	}
+	if want := "no files could be read: plan.txt: zip: checksum error; statement.sql: zip: checksum error"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+	if got != nil {
+		t.Errorf("got files %q, want none", got)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		want    map[string]string
+		removed []string
+	}{
+		{
+			name:  "top level",
+			files: map[string]string{"plan.txt": "plan", "schema.sql": "schema"},
+			want:  map[string]string{"plan.txt": "plan", "schema.sql": "schema"},
+		},
+		{
+			name:  "nested",
+			files: map[string]string{"bundle-12345/plan.txt": "plan", "bundle-12345/schema.sql": "schema"},
+			want:  map[string]string{"plan.txt": "plan", "schema.sql": "schema"},
+		},
+		{
+			// The shallowest file wins, wherever it sorts.
+			name: "shallowest wins",
+			files: map[string]string{
+				"z/plan.txt":          "shallow",
+				"a/b/plan.txt":        "deep",
+				"a/b/c/d/plan.txt":    "deeper",
+				"z/statement.sql":     "statement",
+				"a/b/c/statement.sql": "old statement",
+			},
+			want:    map[string]string{"plan.txt": "shallow", "statement.sql": "statement"},
+			removed: []string{"a/b/c/d/plan.txt", "a/b/c/statement.sql", "a/b/plan.txt"},
+		},
+		{
+			name:    "top level wins",
+			files:   map[string]string{"plan.txt": "top", "bundle/plan.txt": "nested"},
+			want:    map[string]string{"plan.txt": "top"},
+			removed: []string{"bundle/plan.txt"},
+		},
+		{
+			// Among equally deep files, the first in sorted order wins.
+			name:    "first in sorted order wins",
+			files:   map[string]string{"b/plan.txt": "b", "a/plan.txt": "a", "c/plan.txt": "c"},
+			want:    map[string]string{"plan.txt": "a"},
+			removed: []string{"b/plan.txt", "c/plan.txt"},
+		},
+		{
+			name:  "empty",
+			files: map[string]string{},
+			want:  map[string]string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			removed := Flatten(tc.files)
+			if !maps.Equal(tc.files, tc.want) {
+				t.Errorf("got files %q, want %q", tc.files, tc.want)
+			}
+			if !slices.Equal(removed, tc.removed) {
+				t.Errorf("got removed %q, want %q", removed, tc.removed)
+			}
+		})
+	}
+}
+
+func TestStatementOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  bool
+	}{
+		{"lone statement", map[string]string{"statement.sql": "SELECT 1"}, true},
+		{"statement and env", map[string]string{"statement.sql": "SELECT 1", "env.sql": "-- CockroachDB CCL v23.1.2"}, true},
+		{"with plan", map[string]string{"statement.sql": "SELECT 1", "plan.txt": "• values"}, false},
+		{"with schema", map[string]string{"statement.sql": "SELECT 1", "schema.sql": "CREATE TABLE t (k INT PRIMARY KEY);"}, false},
+		{"no statement", map[string]string{"plan.txt": "• values"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := StatementOnly(tc.files); got != tc.want {
+				t.Errorf("got %t, want %t", got, tc.want)
+			}
+		})
+	}
+}
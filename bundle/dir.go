@@ -0,0 +1,82 @@
+package bundle
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadDir returns the contents of the files in dir, a bundle that has already
+// been extracted, keyed by name, like Extract does for an archive. Only the
+// regular files at the top of dir are read; subdirectories and hidden files,
+// such as .DS_Store, are skipped. An error is returned if a file exceeds the
+// given limits. If some, but not all, of the files can't be read, the others
+// are returned along with an *UnreadableError.
+func ReadDir(dir string, limits Limits) (map[string]string, error) {
+	entries, err := ListDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrEmpty
+	}
+	checker := sizeChecker{limits: limits}
+	files := make(map[string]string)
+	unreadable := make(map[string]error)
+	for _, e := range entries {
+		content, err := readDirFile(filepath.Join(dir, e.Name), e.Name, &checker)
+		var limitErr *limitError
+		if errors.As(err, &limitErr) {
+			return nil, err
+		}
+		if err != nil {
+			unreadable[e.Name] = err
+			continue
+		}
+		files[e.Name] = content
+	}
+	if len(unreadable) == 0 {
+		return files, nil
+	}
+	err = &UnreadableError{Files: unreadable}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files could be read: %v", err)
+	}
+	return files, err
+}
+
+// ListDir returns the files in dir that ReadDir reads, sorted by name, without
+// reading them. Symbolic links to regular files are followed.
+func ListDir(dir string) ([]Entry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, de := range dirEntries {
+		if strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, de.Name()))
+		if err != nil || !info.Mode().IsRegular() {
+			// The file is not regular, is a broken link, or was removed
+			// since the directory was read.
+			continue
+		}
+		entries = append(entries, Entry{Name: de.Name(), Size: info.Size()})
+	}
+	return entries, nil
+}
+
+// readDirFile returns the contents of the file at path, read with checker
+// under the given name.
+func readDirFile(path, name string, checker *sizeChecker) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	defer f.Close()
+	return checker.read(name, f)
+}
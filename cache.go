@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// cacheDir returns the directory that API responses are cached in,
+// $XDG_CACHE_HOME/bundlebot on Linux.
+func cacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bundlebot"), nil
+}
+
+// cacheKey returns the key that the response to prompt from model is cached
+// under.
+func cacheKey(model, prompt string) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	h.Write([]byte{0})
+	h.Write([]byte(prompt))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// systemPromptHash returns a short hash of a system prompt, which distinguishes
+// the replies cached for different system prompts.
+func systemPromptHash(systemPrompt string) string {
+	h := sha256.Sum256([]byte(systemPrompt))
+	return hex.EncodeToString(h[:8])
+}
+
+// readCache returns the cached response for key and true, or false if there
+// is no cached response.
+func readCache(key string) (string, bool) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", false
+	}
+	data, err := os.ReadFile(filepath.Join(dir, key+".txt"))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// writeCache caches the response for key.
+func writeCache(key, response string) error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a concurrent reader never sees
+	// a partially written response.
+	tmp, err := os.CreateTemp(dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(response); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, key+".txt"))
+}
+
+// clearCache removes all cached responses.
+func clearCache() error {
+	dir, err := cacheDir()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
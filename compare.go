@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// compareBundles explains the performance regression between the bundles at
+// beforePath and afterPath, which are of the same statement.
+func compareBundles(ctx context.Context, beforePath, afterPath string, analyzer analyze.Analyzer, cfg config) (bundleResult, error) {
+	before, err := readBundleFiles(ctx, beforePath, cfg, beforePath+": ", log.Printf)
+	if err != nil {
+		return bundleResult{}, fmt.Errorf("%s: %w", beforePath, err)
+	}
+	after, err := readBundleFiles(ctx, afterPath, cfg, afterPath+": ", log.Printf)
+	if err != nil {
+		return bundleResult{}, fmt.Errorf("%s: %w", afterPath, err)
+	}
+	for path, files := range map[string]map[string]string{beforePath: before, afterPath: after} {
+		if dotPath := cfg.planDot[path]; dotPath != "" {
+			writePlanDot(files, dotPath, path+": ", func(format string, args ...any) {
+				log.Printf(path+": "+format, args...)
+			})
+		}
+	}
+
+	promptOpts := cfg.prompt
+	if verbose {
+		promptOpts.Logf = debugf
+	}
+	if !cfg.quiet {
+		promptOpts.Truncatef = func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		}
+	}
+	prompt := analyze.BuildComparePrompt(analyze.CompareInstructions, before, after, promptOpts)
+	if rec := dumpRecorderFrom(ctx); rec != nil {
+		rec.setPrompt(prompt)
+	}
+	debugf("prompt is %d bytes (~%d tokens)", len(prompt), analyze.EstimateTokens(prompt))
+	if cfg.dryRun {
+		return bundleResult{output: prompt}, nil
+	}
+
+	r, err := complete(ctx, analyzer, prompt, cfg, "", log.Printf)
+	if err != nil {
+		return bundleResult{}, err
+	}
+	if r.streamed {
+		return bundleResult{}, nil
+	}
+	return bundleResult{output: r.content}, nil
+}
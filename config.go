@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// defaultConfigPath returns the path of the config file used when -config is
+// not set, ~/.config/bundlebot/config.json on Linux.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "bundlebot", "config.json")
+}
+
+// applyConfigFile sets the flags in fs that were not set on the command line
+// to the values in the config file at path. The file is a JSON object keyed by
+// flag name, such as {"model": "gpt-4o", "timeout": "30s", "redact": true}.
+// The elements of an array value, such as {"exclude": ["trace*"]}, are each
+// set in turn. The "profiles" setting isn't a flag: it defines prompt profiles
+// for -profile-name, keyed by name, which are returned. If the file does not
+// exist and mustExist is false, no flags are set.
+func applyConfigFile(flags *flag.FlagSet, path string, mustExist bool) (map[string]analyze.PromptProfile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !mustExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// Decode numbers as json.Number so that large integers aren't formatted in
+	// exponent notation.
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var profiles map[string]analyze.PromptProfile
+	for name, value := range values {
+		if name == "profiles" {
+			if profiles, err = parseProfiles(value); err != nil {
+				return nil, fmt.Errorf("%s: invalid profiles: %w", path, err)
+			}
+			continue
+		}
+		if name == "config" || flags.Lookup(name) == nil {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if set[name] {
+			// Flags on the command line take precedence.
+			continue
+		}
+		elems, ok := value.([]any)
+		if !ok {
+			elems = []any{value}
+		}
+		for _, elem := range elems {
+			if err := flags.Set(name, fmt.Sprint(elem)); err != nil {
+				return nil, fmt.Errorf("%s: invalid value for %q: %w", path, name, err)
+			}
+		}
+	}
+	return profiles, nil
+}
+
+// parseProfiles parses the "profiles" setting of the config file, an object
+// keyed by profile name, such as {"oltp": {"system_prompt": "...",
+// "questions": ["..."]}}.
+func parseProfiles(value any) (map[string]analyze.PromptProfile, error) {
+	// Decode the value again to reject misspelled fields.
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]analyze.PromptProfile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&profiles); err != nil {
+		return nil, err
+	}
+	for name, p := range profiles {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("profile name must not be empty")
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+	}
+	return profiles, nil
+}
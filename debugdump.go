@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDumpedBodySize is the number of bytes of each request and response body
+// kept by a dumpRecorder.
+const maxDumpedBodySize = 1 << 20
+
+// secretHeaders are the request headers that carry an API key, which are
+// redacted from debug dumps.
+var secretHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "X-Goog-Api-Key"}
+
+// dumpRecorder records the prompt sent for a bundle and the last API request
+// made with it, for -debug-dump to write if the analysis fails.
+type dumpRecorder struct {
+	mu             sync.Mutex
+	prompt         string
+	request        string
+	requestBody    []byte
+	status         string
+	responseHeader http.Header
+	responseBody   bytes.Buffer
+	// secrets are the API keys seen in requests, which are redacted from
+	// everything that is dumped.
+	secrets []string
+}
+
+type dumpRecorderKey struct{}
+
+// withDumpRecorder returns a context whose API requests are recorded by the
+// returned recorder.
+func withDumpRecorder(ctx context.Context) (context.Context, *dumpRecorder) {
+	rec := &dumpRecorder{}
+	return context.WithValue(ctx, dumpRecorderKey{}, rec), rec
+}
+
+// dumpRecorderFrom returns the recorder of the context, or nil if it has none.
+func dumpRecorderFrom(ctx context.Context) *dumpRecorder {
+	rec, _ := ctx.Value(dumpRecorderKey{}).(*dumpRecorder)
+	return rec
+}
+
+// setPrompt records the prompt sent for the bundle.
+func (r *dumpRecorder) setPrompt(prompt string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prompt = prompt
+}
+
+// recordRequest records req, replacing any request recorded before it.
+func (r *dumpRecorder) recordRequest(req *http.Request, body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	header := req.Header.Clone()
+	for _, name := range secretHeaders {
+		if value := header.Get(name); value != "" {
+			r.secrets = append(r.secrets, strings.TrimPrefix(value, "Bearer "))
+			header.Set(name, "REDACTED")
+		}
+	}
+	r.request = fmt.Sprintf("%s %s\n%s", req.Method, req.URL.Redacted(), formatHeader(header))
+	r.requestBody = body
+	r.status, r.responseHeader = "", nil
+	r.responseBody.Reset()
+}
+
+// recordResponse records the status and headers of the response to the last
+// request.
+func (r *dumpRecorder) recordResponse(resp *http.Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = resp.Status
+	r.responseHeader = resp.Header.Clone()
+}
+
+// Write records the part of the response body that has been read.
+func (r *dumpRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if room := maxDumpedBodySize - r.responseBody.Len(); room > 0 {
+		r.responseBody.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// redact replaces the API keys in s.
+func (r *dumpRecorder) redact(s string) string {
+	for _, secret := range r.secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "REDACTED")
+		}
+	}
+	return s
+}
+
+// dumpTransport is an http.RoundTripper that records the requests made with a
+// context holding a dumpRecorder, and their responses.
+type dumpTransport struct {
+	base http.RoundTripper
+}
+
+func (t dumpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := dumpRecorderFrom(req.Context())
+	if rec == nil {
+		return t.base.RoundTrip(req)
+	}
+	var body []byte
+	if req.GetBody != nil {
+		if rc, err := req.GetBody(); err == nil {
+			body, _ = io.ReadAll(io.LimitReader(rc, maxDumpedBodySize))
+			rc.Close()
+		}
+	}
+	rec.recordRequest(req, body)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	rec.recordResponse(resp)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, rec), resp.Body}
+	return resp, nil
+}
+
+// withDebugDump runs the analysis of the bundle at path. If -debug-dump is set
+// and the analysis fails, the last API request it made is dumped.
+func withDebugDump(ctx context.Context, path string, cfg config, run func(context.Context) (bundleResult, error)) (bundleResult, error) {
+	if cfg.debugDump == "" {
+		return run(ctx)
+	}
+	ctx, rec := withDumpRecorder(ctx)
+	res, err := run(ctx)
+	if err != nil && ctx.Err() == nil {
+		if dir, dumpErr := writeDebugDump(cfg.debugDump, path, rec, err); dumpErr != nil {
+			log.Printf("%s: warning: failed to write debug dump: %v", path, dumpErr)
+		} else {
+			log.Printf("%s: wrote debug dump to %s", path, dir)
+		}
+	}
+	return res, err
+}
+
+// writeDebugDump writes what rec recorded about the failed analysis of the
+// bundle at path, along with the error and the flags, to a new directory in
+// dir, and returns the directory's path. API keys are redacted.
+func writeDebugDump(dir, path string, rec *dumpRecorder, analysisErr error) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	name := bundleBaseName(path)
+	if path == "-" {
+		name = "stdin"
+	}
+	dumpDir, err := os.MkdirTemp(dir, name+"-"+time.Now().Format("20060102T150405")+"-")
+	if err != nil {
+		return "", err
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	files := map[string]string{
+		"error.txt": analysisErr.Error() + "\n",
+		"flags.txt": flagSummary(),
+	}
+	if rec.prompt != "" {
+		files["prompt.txt"] = rec.prompt
+	}
+	if rec.request != "" {
+		files["request.txt"] = rec.request
+		files["request.json"] = string(rec.requestBody)
+	}
+	if rec.status != "" {
+		files["status.txt"] = rec.status + "\n" + formatHeader(rec.responseHeader)
+		files["response.txt"] = rec.responseBody.String()
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dumpDir, name), []byte(rec.redact(content)), 0o600); err != nil {
+			return "", err
+		}
+	}
+	return dumpDir, nil
+}
+
+// formatHeader returns the fields of h, one per line, sorted by name.
+func formatHeader(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		for _, value := range h[name] {
+			fmt.Fprintf(&buf, "%s: %s\n", name, value)
+		}
+	}
+	return buf.String()
+}
+
+// flagSummary returns the version of bundlebot and the value of every flag,
+// one per line, marking those that were set. Secrets are redacted, as by
+// redactFlagValue.
+func flagSummary() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "bundlebot %s %s\n\n", version, commit)
+	flag.VisitAll(func(f *flag.Flag) {
+		set := ""
+		if isFlagSet(f.Name) {
+			set = " (set)"
+		}
+		fmt.Fprintf(&buf, "-%s=%s%s\n", f.Name, redactFlagValue(f.Name, f.Value.String()), set)
+	})
+	return buf.String()
+}
+
+// secretFlagWords are the words of the names of flags whose values are
+// secrets, such as the "key" of a hypothetical -api-key.
+var secretFlagWords = []string{"key", "secret", "password", "token", "credentials"}
+
+// redactFlagValue returns the value of the named flag with any secret in it
+// replaced by REDACTED. The values of flags named for a secret are replaced
+// whole, unless they name the file holding it, such as -api-key-file, and a
+// URL, such as a -proxy with a password, is shown as by displayURL.
+func redactFlagValue(name, value string) string {
+	words := strings.Split(name, "-")
+	if value != "" && words[len(words)-1] != "file" && slices.ContainsFunc(words, func(w string) bool {
+		return slices.Contains(secretFlagWords, w)
+	}) {
+		return "REDACTED"
+	}
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		return displayURL(value)
+	}
+	return value
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mgartner/bundlebot/bundle"
+)
+
+// runExtract implements the extract subcommand, which prints the contents of a
+// single file in a bundle without analyzing it.
+func runExtract(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s extract <statement_bundle.zip | -> <filename>\n", os.Args[0])
+		os.Exit(exitUsage)
+	}
+	path, name := args[0], args[1]
+
+	data, err := readBundle(path, defaultMaxBundleBytes)
+	if err != nil {
+		log.Fatalf("Failed to read file: %v", err)
+	}
+	if len(data) == 0 {
+		log.Fatalf("Failed to extract bundle: %v", bundle.ErrEmpty)
+	}
+	files, err := bundle.Extract(data, bundle.DefaultLimits)
+	var unreadable *bundle.UnreadableError
+	if errors.As(err, &unreadable) {
+		// The requested file may be among those that could be read.
+		log.Printf("warning: skipping unreadable files: %v", err)
+	} else if err != nil {
+		log.Fatalf("Failed to extract bundle: %v", err)
+	}
+
+	content, ok := files[name]
+	if !ok {
+		// Files nested in a directory can be extracted by their base name.
+		bundle.Flatten(files)
+		content, ok = files[name]
+	}
+	if !ok && unreadable != nil {
+		if ferr, unread := unreadable.Files[name]; unread {
+			log.Fatalf("Failed to extract bundle: %v", ferr)
+		}
+	}
+	if !ok {
+		names := make([]string, 0, len(files))
+		for n := range files {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		log.Fatalf("%s not found in bundle; available files:\n  %s", name, strings.Join(names, "\n  "))
+	}
+	fmt.Print(content)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// fetchClient fetches the bundles given as http:// or https:// URLs. main
+// replaces it with a client that honors -timeout and -proxy.
+var fetchClient = analyze.NewHTTPClient(defaultTimeout, nil)
+
+// isBundleURL returns true if the bundle path is an http:// or https:// URL,
+// such as a presigned URL of an object in object storage.
+func isBundleURL(p string) bool {
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://")
+}
+
+// bundleBaseName returns the last element of the bundle path, such as
+// "stmt-bundle-1.zip", which for a URL is the last element of its path,
+// without the query.
+func bundleBaseName(p string) string {
+	if isBundleURL(p) {
+		if u, err := url.Parse(p); err == nil {
+			return path.Base(u.Path)
+		}
+	}
+	return filepath.Base(p)
+}
+
+// displayURL returns the bundle URL without its query, which for a presigned
+// URL holds the signature, so that it can be printed in messages.
+func displayURL(p string) string {
+	u, err := url.Parse(p)
+	if err != nil {
+		return p
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.Redacted()
+}
+
+// fetchBundle requests the bundle at the URL, returning the response body and
+// its size, or -1 if the size is unknown. A response with a status other than
+// 2xx is an error.
+func fetchBundle(rawURL string) (io.ReadCloser, int64, error) {
+	resp, err := fetchClient.Get(rawURL)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			uerr.URL = displayURL(rawURL)
+		}
+		return nil, 0, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("GET %s: %s", displayURL(rawURL), resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
@@ -0,0 +1,206 @@
+// Package heuristic detects common anti-patterns in a statement bundle using
+// local rules, without calling a language model.
+package heuristic
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mgartner/bundlebot/analyze"
+	"github.com/mgartner/bundlebot/plan"
+	"github.com/mgartner/bundlebot/schema"
+)
+
+// largeTableRows is the number of rows above which a scan is considered to be
+// of a large table.
+const largeTableRows = 10000
+
+// slowestOperations is the number of operators reported as the slowest.
+const slowestOperations = 3
+
+// bundle is the parsed contents of a bundle that the rules inspect.
+type bundle struct {
+	statement string
+	// plan is nil if the bundle has no plan.txt or it could not be parsed.
+	plan *plan.Plan
+}
+
+// rule adds the findings of a single heuristic to a. Findings measured in the
+// plan are rated high confidence, and those inferred from it medium.
+type rule func(b *bundle, a *analyze.Analysis)
+
+// rules are the heuristics applied by Analyze, in order.
+var rules = []rule{
+	slowest,
+	fullScans,
+	selectStar,
+	missingWhere,
+	diskSpills,
+}
+
+// Analyze applies the heuristics to the bundle's files, keyed by name, and
+// returns their findings.
+func Analyze(files map[string]string) *analyze.Analysis {
+	b := &bundle{statement: files["statement.sql"]}
+	if planText, ok := files["plan.txt"]; ok {
+		b.plan, _ = plan.Parse(planText)
+	}
+	a := &analyze.Analysis{
+		SlowestOperations:  []analyze.Finding{},
+		SchemaAntipatterns: []analyze.Finding{},
+		QueryAntipatterns:  []analyze.Finding{},
+		MissingIndexes:     []analyze.Finding{},
+	}
+	for _, r := range rules {
+		r(b, a)
+	}
+	a.RedundantIndexes = RedundantIndexes(files)
+	return a
+}
+
+// RedundantIndexes reports the indexes in the bundle's schema.sql whose
+// columns are covered by another index of the same table, which cost writes
+// and storage without serving any query that the other index can't. They are
+// found the same way whether or not the bundle is analyzed by a model.
+func RedundantIndexes(files map[string]string) []analyze.Finding {
+	schemaSQL, ok := files["schema.sql"]
+	if !ok {
+		return nil
+	}
+	var findings []analyze.Finding
+	for _, r := range schema.Parse(schemaSQL).RedundantIndexes() {
+		text := fmt.Sprintf("Index %s on %s is redundant with %s, which covers its columns; dropping it saves storage and write overhead",
+			r.Index, r.Table, r.CoveredBy)
+		if r.Index.Name != "" && !r.Index.Unique {
+			text += fmt.Sprintf(": DROP INDEX %s@%s;", r.Table, r.Index.Name)
+		}
+		findings = append(findings, analyze.Finding{Text: text, Confidence: analyze.ConfidenceHigh})
+	}
+	return findings
+}
+
+// operators returns the operators in the plan, or nil if there is no plan.
+func (b *bundle) operators() []*plan.Node {
+	if b.plan == nil {
+		return nil
+	}
+	return b.plan.Operators()
+}
+
+// scans returns the scan operators in the plan.
+func (b *bundle) scans() []*plan.Node {
+	var scans []*plan.Node
+	for _, n := range b.operators() {
+		if n.Name == "scan" {
+			scans = append(scans, n)
+		}
+	}
+	return scans
+}
+
+// rows returns the actual number of rows produced by n, or the estimated
+// number if the actual number is unknown.
+func rows(n *plan.Node) int64 {
+	if n.ActualRows >= 0 {
+		return n.ActualRows
+	}
+	return n.EstimatedRows
+}
+
+// tableName returns the name of the table read by n, without the index.
+func tableName(n *plan.Node) string {
+	table, _, _ := strings.Cut(n.Attrs["table"], "@")
+	return table
+}
+
+// whereRE matches a WHERE clause.
+var whereRE = regexp.MustCompile(`(?i)\bWHERE\b`)
+
+// slowest reports the most expensive operators in the plan.
+func slowest(b *bundle, a *analyze.Analysis) {
+	if b.plan == nil {
+		return
+	}
+	for _, n := range b.plan.MostExpensive(slowestOperations) {
+		a.SlowestOperations = append(a.SlowestOperations, analyze.Finding{Text: n.String(), Confidence: analyze.ConfidenceHigh})
+	}
+}
+
+// fullScans reports scans that read every row of a table or index. If the
+// statement filters rows, an index on the filtered columns may avoid them.
+func fullScans(b *bundle, a *analyze.Analysis) {
+	for _, n := range b.scans() {
+		if !strings.HasPrefix(n.Attrs["spans"], "FULL SCAN") {
+			continue
+		}
+		a.QueryAntipatterns = append(a.QueryAntipatterns, analyze.Finding{
+			Text:       fmt.Sprintf("Full scan of %s reads every row (%d rows)", n.Attrs["table"], rows(n)),
+			Confidence: analyze.ConfidenceHigh,
+		})
+		if whereRE.MatchString(b.statement) {
+			a.MissingIndexes = append(a.MissingIndexes, analyze.Finding{
+				Text: fmt.Sprintf(
+					"An index on %s on the columns filtered in the WHERE clause could avoid the full scan of %s",
+					tableName(n), n.Attrs["table"],
+				),
+				Confidence: analyze.ConfidenceMedium,
+			})
+		}
+	}
+}
+
+// selectStarRE matches a SELECT * or SELECT t.* projection.
+var selectStarRE = regexp.MustCompile(`(?i)\bSELECT\s+(?:DISTINCT\s+)?(?:[\w"]+\.)?\*`)
+
+// selectStar reports statements that select every column.
+func selectStar(b *bundle, a *analyze.Analysis) {
+	if selectStarRE.MatchString(b.statement) {
+		a.QueryAntipatterns = append(a.QueryAntipatterns, analyze.Finding{
+			Text:       "SELECT * fetches every column; selecting only the columns needed reduces the data read and may allow a covering index",
+			Confidence: analyze.ConfidenceMedium,
+		})
+	}
+}
+
+// dmlRE matches the beginning of a statement that may have a WHERE clause.
+var dmlRE = regexp.MustCompile(`(?i)^\s*(?:SELECT|UPDATE|DELETE)\b`)
+
+// missingWhere reports statements without a WHERE clause that read large
+// tables.
+func missingWhere(b *bundle, a *analyze.Analysis) {
+	if !dmlRE.MatchString(b.statement) || whereRE.MatchString(b.statement) {
+		return
+	}
+	for _, n := range b.scans() {
+		if r := rows(n); r >= largeTableRows {
+			a.QueryAntipatterns = append(a.QueryAntipatterns, analyze.Finding{
+				Text:       fmt.Sprintf("No WHERE clause restricts the rows read from %s, which has %d rows", tableName(n), r),
+				Confidence: analyze.ConfidenceHigh,
+			})
+		}
+	}
+}
+
+// diskUsageAttrs are the operator attributes that report temporary disk
+// usage.
+var diskUsageAttrs = []string{"sql temp disk usage", "estimated max sql temp disk usage"}
+
+// diskSpills reports operators, such as sorts and hash joins, that spilled to
+// disk because they exceeded their memory budget.
+func diskSpills(b *bundle, a *analyze.Analysis) {
+	for _, n := range b.operators() {
+		for _, attr := range diskUsageAttrs {
+			if usage, ok := n.Attrs[attr]; ok && usage != "0 B" {
+				a.QueryAntipatterns = append(a.QueryAntipatterns, analyze.Finding{
+					Text: fmt.Sprintf(
+						"%s spilled %s to disk; an index providing the required order or a smaller input could avoid it",
+						n.Label(), usage,
+					),
+					Confidence: analyze.ConfidenceHigh,
+				})
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package heuristic
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mgartner/bundlebot/analyze"
+	"github.com/mgartner/bundlebot/plan"
+)
+
+// largeScanPlan is a plan that filters a full scan of a large table.
+const largeScanPlan = `planning time: 1ms
+execution time: 45ms
+distribution: local
+vectorized: true
+
+• filter
+│ actual row count: 10
+│ execution time: 3ms
+│ estimated row count: 12
+│ filter: email = 'a@example.com'
+│
+└── • scan
+      actual row count: 50,000
+      KV time: 40ms
+      estimated row count: 50,000 (100% of the table; stats collected 1 day ago)
+      table: users@users_pkey
+      spans: FULL SCAN
+`
+
+// smallScanPlan is a plan from EXPLAIN that fully scans a small table.
+const smallScanPlan = `• scan
+  estimated row count: 100 (100% of the table; stats collected 1 day ago)
+  table: users@users_pkey
+  spans: FULL SCAN
+`
+
+// limitedScanPlan is a plan from EXPLAIN whose scan stops at the LIMIT.
+const limitedScanPlan = `• scan
+  estimated row count: 10 (0.02% of the table; stats collected 1 day ago)
+  table: users@users_pkey
+  spans: LIMITED SCAN
+  limit: 10
+`
+
+// constrainedScanPlan is a plan from EXPLAIN whose scan reads a range of an
+// index.
+const constrainedScanPlan = `• scan
+  estimated row count: 20,000 (40% of the table; stats collected 1 day ago)
+  table: users@users_email_idx
+  spans: [/'a' - /'m')
+`
+
+// spillPlan is a plan whose hash join spilled to disk.
+const spillPlan = `• hash join
+│ actual row count: 1,000
+│ execution time: 80ms
+│ sql temp disk usage: 12 MiB
+│ estimated row count: 1,000
+│ equality: (customer_id) = (id)
+│
+├── • scan
+│     actual row count: 1,000
+│     KV time: 2ms
+│     estimated max sql temp disk usage: 0 B
+│     table: orders@orders_customer_idx
+│     spans: [/1 - /5]
+│
+└── • scan
+      actual row count: 5
+      KV time: 1ms
+      table: customers@customers_pkey
+      spans: [/1 - /5]
+`
+
+// findings returns the findings of a, prefixed with their kind.
+func findings(a *analyze.Analysis) []string {
+	var all []string
+	for _, kind := range []struct {
+		name     string
+		findings []analyze.Finding
+	}{
+		{"slowest", a.SlowestOperations},
+		{"schema", a.SchemaAntipatterns},
+		{"query", a.QueryAntipatterns},
+		{"index", a.MissingIndexes},
+	} {
+		for _, f := range kind.findings {
+			all = append(all, kind.name+": "+f.String())
+		}
+	}
+	return all
+}
+
+func TestRules(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		rule      rule
+		statement string
+		plan      string
+		want      []string
+	}{
+		{
+			name: "slowest", rule: slowest, plan: largeScanPlan,
+			want: []string{
+				"slowest: scan (users@users_pkey), KV time 40ms, actual rows 50000, estimated rows 50000 (high confidence)",
+				"slowest: filter, time 3ms, actual rows 10, estimated rows 12 (high confidence)",
+			},
+		},
+		{
+			name: "slowest without a plan", rule: slowest, statement: "SELECT id FROM users",
+		},
+		{
+			name: "full scan with a WHERE clause", rule: fullScans, plan: largeScanPlan,
+			statement: "SELECT id FROM users WHERE email = 'a@example.com'",
+			want: []string{
+				"query: Full scan of users@users_pkey reads every row (50000 rows) (high confidence)",
+				"index: An index on users on the columns filtered in the WHERE clause could avoid the full scan of users@users_pkey (medium confidence)",
+			},
+		},
+		{
+			// The estimate is used when the actual rows are unknown, and
+			// without a WHERE clause no index would help.
+			name: "full scan without a WHERE clause", rule: fullScans, plan: smallScanPlan,
+			statement: "SELECT id FROM users",
+			want:      []string{"query: Full scan of users@users_pkey reads every row (100 rows) (high confidence)"},
+		},
+		{
+			name: "limited scan", rule: fullScans, plan: limitedScanPlan,
+			statement: "SELECT id FROM users LIMIT 10",
+		},
+		{
+			name: "constrained scan", rule: fullScans, plan: constrainedScanPlan,
+			statement: "SELECT id FROM users WHERE email >= 'a' AND email < 'm'",
+		},
+		{
+			name: "select star", rule: selectStar, statement: "SELECT * FROM users WHERE id = 1",
+			want: []string{"query: SELECT * fetches every column; selecting only the columns needed reduces the data read and may allow a covering index (medium confidence)"},
+		},
+		{
+			name: "select qualified star", rule: selectStar, statement: "select distinct u.* from users as u",
+			want: []string{"query: SELECT * fetches every column; selecting only the columns needed reduces the data read and may allow a covering index (medium confidence)"},
+		},
+		{
+			name: "select count star", rule: selectStar, statement: "SELECT count(*) FROM users",
+		},
+		{
+			name: "select multiplication", rule: selectStar, statement: "SELECT price * quantity FROM orders",
+		},
+		{
+			name: "missing WHERE on a large table", rule: missingWhere, plan: largeScanPlan,
+			statement: "SELECT id FROM users",
+			want:      []string{"query: No WHERE clause restricts the rows read from users, which has 50000 rows (high confidence)"},
+		},
+		{
+			name: "WHERE on a large table", rule: missingWhere, plan: largeScanPlan,
+			statement: "SELECT id FROM users WHERE email = 'a@example.com'",
+		},
+		{
+			name: "missing WHERE on a small table", rule: missingWhere, plan: smallScanPlan,
+			statement: "DELETE FROM users",
+		},
+		{
+			name: "missing WHERE in an INSERT", rule: missingWhere, plan: largeScanPlan,
+			statement: "INSERT INTO archive SELECT * FROM users",
+		},
+		{
+			name: "disk spill", rule: diskSpills, plan: spillPlan,
+			want: []string{"query: hash join spilled 12 MiB to disk; an index providing the required order or a smaller input could avoid it (high confidence)"},
+		},
+		{
+			name: "no disk spill", rule: diskSpills, plan: largeScanPlan,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &bundle{statement: tc.statement}
+			if tc.plan != "" {
+				p, err := plan.Parse(tc.plan)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b.plan = p
+			}
+			a := &analyze.Analysis{}
+			tc.rule(b, a)
+			if got := findings(a); !slices.Equal(got, tc.want) {
+				t.Errorf("got findings:\n%q\nwant:\n%q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	a := Analyze(map[string]string{
+		"statement.sql": "SELECT * FROM users",
+		"plan.txt":      largeScanPlan,
+		"schema.sql": `CREATE TABLE users (
+	id INT PRIMARY KEY,
+	email STRING,
+	INDEX users_email_idx (email),
+	INDEX users_email_id_idx (email, id)
+);`,
+	})
+	want := []string{
+		"slowest: scan (users@users_pkey), KV time 40ms, actual rows 50000, estimated rows 50000 (high confidence)",
+		"slowest: filter, time 3ms, actual rows 10, estimated rows 12 (high confidence)",
+		"query: Full scan of users@users_pkey reads every row (50000 rows) (high confidence)",
+		"query: SELECT * fetches every column; selecting only the columns needed reduces the data read and may allow a covering index (medium confidence)",
+		"query: No WHERE clause restricts the rows read from users, which has 50000 rows (high confidence)",
+	}
+	if got := findings(a); !slices.Equal(got, want) {
+		t.Errorf("got findings:\n%q\nwant:\n%q", got, want)
+	}
+	if len(a.RedundantIndexes) != 1 {
+		t.Errorf("got redundant indexes %v, want users_email_idx", a.RedundantIndexes)
+	}
+
+	// The findings are empty rather than null in JSON, even if no rule
+	// fires.
+	a = Analyze(map[string]string{"statement.sql": "SELECT 1"})
+	if a.SlowestOperations == nil || a.QueryAntipatterns == nil || a.MissingIndexes == nil || a.SchemaAntipatterns == nil {
+		t.Errorf("got nil findings: %+v", a)
+	}
+	if got := findings(a); len(got) != 0 {
+		t.Errorf("got findings %q, want none", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// runInteractive reads follow-up questions about an analysis from stdin and
+// prints the model's answers to out, until EOF or an empty line. Each question
+// and answer is appended to the conversation so that the model has the
+// context of the bundle and the earlier answers.
+func runInteractive(ctx context.Context, analyzer analyze.Analyzer, conversation []analyze.Message, out io.Writer, cfg config) error {
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprint(os.Stderr, "\n> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(os.Stderr)
+			return scanner.Err()
+		}
+		question := strings.TrimSpace(scanner.Text())
+		if question == "" {
+			return nil
+		}
+
+		conversation = append(conversation, analyze.Message{Role: analyze.RoleUser, Content: question})
+		if err := waitForLimiter(ctx, cfg, ""); err != nil {
+			return err
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		stop := startSpinner(cfg)
+		comp, err := analyzer.Chat(reqCtx, conversation)
+		stop()
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("request timed out after %s", cfg.timeout)
+		}
+		if err != nil {
+			// Drop the unanswered question so that it can be asked again.
+			conversation = conversation[:len(conversation)-1]
+			log.Printf("API error: %v", err)
+			continue
+		}
+
+		if !cfg.stream {
+			fmt.Fprint(out, comp.Content)
+		}
+		if !strings.HasSuffix(comp.Content, "\n") {
+			fmt.Fprintln(out)
+		}
+		if !cfg.quiet {
+			fmt.Fprintf(os.Stderr, "%s\n", analyze.SummarizeUsage(comp.Model, comp.Usage))
+		}
+		warnFinishReason(comp, log.Printf)
+		conversation = append(conversation, analyze.Message{Role: analyze.RoleAssistant, Content: comp.Content})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mgartner/bundlebot/bundle"
+)
+
+// listBundle prints each file in the bundle at path with its uncompressed
+// size, in the style of unzip -l, without analyzing it. Bundles larger than
+// maxBytes are refused, unless it is 0. The files of a bundle that has already
+// been extracted to a directory are those that would be analyzed.
+func listBundle(path string, maxBytes int64, out io.Writer) error {
+	if isBundleDir(path) {
+		entries, err := bundle.ListDir(path)
+		if err != nil {
+			return fmt.Errorf("Failed to list directory: %w", err)
+		}
+		printEntries(entries, out)
+		return nil
+	}
+	data, err := readBundle(path, maxBytes)
+	if err != nil {
+		return fmt.Errorf("Failed to read file: %w", err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("Failed to read file: %w", bundle.ErrEmpty)
+	}
+	entries, err := bundle.List(data)
+	if err != nil {
+		return fmt.Errorf("Failed to list bundle: %w", err)
+	}
+	printEntries(entries, out)
+	return nil
+}
+
+// printEntries prints the files of a bundle, with their sizes and a total.
+func printEntries(entries []bundle.Entry, out io.Writer) {
+	var total int64
+	fmt.Fprintf(out, "%10s  %s\n%10s  %s\n", "Length", "Name", "----------", "----")
+	for _, e := range entries {
+		fmt.Fprintf(out, "%10d  %s\n", e.Size, e.Name)
+		total += e.Size
+	}
+	fmt.Fprintf(out, "%10s  %s\n%10d  %d files\n", "----------", "----", total, len(entries))
+}
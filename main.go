@@ -1,160 +1,1821 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"maps"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/mgartner/bundlebot/analyze"
+	"github.com/mgartner/bundlebot/bundle"
+	"github.com/mgartner/bundlebot/heuristic"
+	"github.com/mgartner/bundlebot/plan"
+	"github.com/mgartner/bundlebot/schema"
+	"github.com/mgartner/bundlebot/stats"
+	"github.com/mgartner/bundlebot/trace"
 )
 
 const (
-	openaiEndpoint = "https://api.openai.com/v1/chat/completions"
-	model          = "gpt-4"
-	basePrompt     = `You are a CockroachDB expert. Analyze the following
-		files and identify inefficiences and anti-patterns. Only include
-		suggestions that you are highly confident in being relevant to query
-		performance. Include only the list not any summary text beforehand.
-
-		* What are the slowest operations as shown in the plan?
-		* What are the most common anti-patterns in the schema?
-		* What are the most common anti-patterns in the query?
-		* What missing indexes might speed up this query?
-	`
+	defaultTimeout = 60 * time.Second
+	defaultRetries = 3
+	// defaultConcurrency is the default number of bundles analyzed at once.
+	defaultConcurrency = 4
+	// defaultTopOperators is the default number of the plan's most expensive
+	// operators to summarize.
+	defaultTopOperators = 5
+	// defaultSummaryChars is the default maximum length of the -summary,
+	// which fits in a chat message without being folded.
+	defaultSummaryChars = 200
 )
 
-// fileNames is the list of files to use for analysis.
-var fileNames = [...]string{"schema.sql", "statement.sql", "plan.txt"}
+// Exit statuses other than 0 for success and 1 for a failed analysis.
+const (
+	// exitFindings is the exit status when -fail-on-findings is set and the
+	// analysis found anti-patterns or missing indexes.
+	exitFindings = 2
+	// exitUsage is the exit status of an invalid command line. It is
+	// EX_USAGE from sysexits.h rather than the 2 used by the flag package,
+	// so that CI can tell a typo from a finding.
+	exitUsage = 64
+)
+
+// Output formats supported by the -format flag.
+const (
+	formatText     = "text"
+	formatJSON     = "json"
+	formatMarkdown = "markdown"
+	// formatJSONL prints one JSON object per bundle on its own line.
+	formatJSONL = "jsonl"
+)
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatalf("Usage: %s <statement_bundle.zip>", os.Args[0])
+	if len(os.Args) > 1 && os.Args[1] == "extract" {
+		runExtract(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		printVersion(os.Stdout)
+		return
+	}
+	// The compare, serve, and analyze-dir subcommands accept the same flags as analysis.
+	args := os.Args[1:]
+	compareMode := len(args) > 0 && args[0] == "compare"
+	serveMode := len(args) > 0 && args[0] == "serve"
+	watchMode := len(args) > 0 && args[0] == "analyze-dir"
+	if compareMode || serveMode || watchMode {
+		args = args[1:]
 	}
-	zipFile := os.Args[1]
 
-	data, err := os.ReadFile(zipFile)
-	if err != nil {
-		log.Fatalf("Failed to read file: %v", err)
+	configPath := flag.String("config", "", "read default flag values from the JSON config file at `path` (default ~/.config/bundlebot/config.json)")
+	provider := flag.String("provider", analyze.ProviderOpenAI, "language model `provider`: openai, anthropic, or gemini")
+	modelName := flag.String("model", "", "model to use for the analysis (default \""+analyze.DefaultOpenAIModel+"\" for openai, \""+analyze.DefaultAnthropicModel+"\" for anthropic, \""+analyze.DefaultGeminiModel+"\" for gemini)")
+	apiKeyFile := flag.String("api-key-file", "", "read the API key from the file at `path` instead of OPENAI_API_KEY_FILE or the provider's API key environment variable")
+	organization := flag.String("organization", "", "OpenAI organization `ID` to bill requests to, sent in the OpenAI-Organization header (default $OPENAI_ORG_ID)")
+	project := flag.String("project", "", "OpenAI project `ID` to bill requests to, sent in the OpenAI-Project header (default $OPENAI_PROJECT_ID)")
+	noAuth := flag.Bool("no-auth", false, "send requests without an API key if none is set, for OpenAI-compatible servers that don't require one")
+	proxy := flag.String("proxy", "", "send API requests through the proxy at `URL` instead of the one set by HTTPS_PROXY")
+	endpoint := flag.String("endpoint", "", "API `URL` to use instead of the provider's default; for openai, overrides OPENAI_BASE_URL")
+	systemPrompt := flag.String("system-prompt", analyze.DefaultSystemPrompt, "content of the system message sent to the model")
+	seed := flag.Int64("seed", 0, "ask the model to sample deterministically with this `seed`, for reproducible analyses with -temperature 0; not supported by -provider anthropic")
+	lang := flag.String("lang", "", "ISO 639-1 `code` of the language to request the analysis in, such as fr or de (default English)")
+	temperature := flag.Float64("temperature", 0, "sampling temperature; 0 gives the most stable suggestions, for automated use such as CI")
+	noTemperature := flag.Bool("no-temperature", false, "leave the temperature out of requests, using the model's default, for models that reject it")
+	maxCompletionTokens := flag.Int("max-completion-tokens", 0, "maximum number of tokens in the model's reply (0 for the provider's default)")
+	retries := flag.Int("retries", defaultRetries, "number of times to retry rate-limited or failed API requests")
+	failOnFindings := flag.Bool("fail-on-findings", false, "exit with status 2 if any anti-patterns or missing indexes are found; requires -format json, jsonl, or markdown")
+	questionFlags := map[analyze.Question]*bool{
+		analyze.QuestionSlowest: flag.Bool("q-slowest", true, "ask for the slowest operations in the plan"),
+		analyze.QuestionSchema:  flag.Bool("q-schema", true, "ask for anti-patterns in the schema"),
+		analyze.QuestionQuery:   flag.Bool("q-query", true, "ask for anti-patterns in the query"),
+		analyze.QuestionIndexes: flag.Bool("q-indexes", true, "ask for missing indexes"),
+	}
+	profileName := flag.String("profile-name", "", "use the system message and questions of the named prompt `profile`, tailored to a workload: "+strings.Join(analyze.ProfileNames(analyze.BuiltinProfiles), ", ")+", or one defined in the config file")
+	promptFile := flag.String("prompt-file", "", "read the analysis prompt from `path` instead of using the built-in CockroachDB prompt")
+	output := flag.String("output", "", "write the analysis to `path` instead of stdout")
+	noPager := flag.Bool("no-pager", false, "print the analysis to the terminal even if it is taller than the terminal, instead of piping it through $PAGER")
+	interactive := flag.Bool("interactive", false, "after the analysis, read follow-up questions from stdin until EOF or an empty line")
+	rpm := flag.Int("rpm", 0, "maximum number of API requests per minute across all bundles (0 for no limit)")
+	concurrency := flag.Int("concurrency", defaultConcurrency, "maximum number of bundles to analyze at once")
+	var cfg config
+	flag.StringVar(&cfg.format, "format", formatText, "output `format`: text, json, jsonl, or markdown")
+	minConfidence := flag.String("min-confidence", "", "drop findings the model rated less confident than `level` (low, medium, or high) from structured and -offline output")
+	toolCalling := flag.Bool("tool-calling", false, "with -format json, jsonl, or markdown, have the model report its findings by calling a tool rather than in JSON mode; requires -provider openai")
+	flag.BoolVar(&cfg.jsonSchema, "json-schema", false, "reject a structured reply that does not conform to the analysis JSON schema, after asking the model once to correct it")
+	flag.BoolVar(&cfg.stream, "stream", false, "stream the analysis to stdout as it is generated")
+	flag.IntVar(&cfg.choices, "n", 1, "number of candidate analyses to request from the model, each adding to the cost of the reply; requires -provider openai")
+	flag.BoolVar(&cfg.best, "best", false, "with -n, make another request asking the model to pick the most actionable candidate")
+	flag.BoolVar(&cfg.offline, "offline", false, "detect anti-patterns with local heuristics instead of calling the API")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "print the prompt without sending it to the API")
+	flag.BoolVar(&cfg.prompt.Redact, "redact", false, "replace string and numeric literals in the statement files with placeholders")
+	flag.BoolVar(&cfg.explain, "explain", false, "ask for a one-sentence rationale and the expected impact of each finding")
+	flag.BoolVar(&cfg.summary, "summary", false, "after the analysis, make another request for a one-line summary of it, such as for an incident channel, and print it after the analysis")
+	flag.BoolVar(&cfg.summaryOnly, "summary-only", false, "like -summary, but print only the one-line summary")
+	flag.IntVar(&cfg.summaryChars, "summary-chars", defaultSummaryChars, "maximum length in `characters` of the -summary (0 for no limit)")
+	flag.BoolVar(&cfg.ddlOnly, "ddl-only", false, "print only the suggested CREATE INDEX statements")
+	flag.BoolVar(&cfg.quiet, "quiet", false, "do not print informational messages, such as the progress banner and token usage, to stderr")
+	noEmoji := flag.Bool("no-emoji", false, "print the progress banner without its emoji, for terminals that render it poorly")
+	flag.IntVar(&cfg.prompt.MaxTokens, "max-tokens", 0, "truncate the bundle's files so the prompt is at most this many estimated tokens (0 for no limit)")
+	flag.BoolVar(&cfg.noCache, "no-cache", false, "do not read or write cached API responses")
+	clearCacheFlag := flag.Bool("clear-cache", false, "remove all cached API responses")
+	flag.BoolVar(&cfg.diffStats, "diff-stats", false, "print a table of the plan's operators with their estimated and actual rows and time to stderr")
+	flag.BoolVar(&cfg.diffStatsStdout, "diff-stats-stdout", false, "print the -diff-stats table before the analysis in the output instead of to stderr")
+	flag.IntVar(&cfg.prompt.TopOperators, "top-operators", defaultTopOperators, "number of the plan's most expensive operators to summarize (0 to disable)")
+	flag.BoolVar(&cfg.prompt.StripANSI, "strip-ansi", false, "remove ANSI escape sequences, such as terminal color codes, from the bundle's files")
+	flag.BoolVar(&cfg.prompt.Compact, "compact", false, "trim trailing whitespace and collapse blank lines in the bundle's files to save tokens")
+	flag.Var((*globList)(&cfg.prompt.Include), "include", "send the bundle files matching these comma-separated glob `patterns` instead of schema.sql, statement.sql, and plan.txt")
+	flag.Var((*globList)(&cfg.prompt.Exclude), "exclude", "do not send the bundle files matching these comma-separated glob `patterns`; takes precedence over -include")
+	flag.Var((*tableList)(&cfg.prompt.Tables), "tables", "focus the analysis on these comma-separated `tables`, leaving the DDL of other tables out of schema.sql")
+	flag.BoolVar(&cfg.prompt.RelevantSchema, "relevant-schema", false, "send only the DDL of the tables the statement references, and the tables their foreign keys reference, from schema.sql")
+	flag.Var((*noteList)(&cfg.prompt.Notes), "note", "add `text` the bundle doesn't show, such as \"table users is 2TB\", to the prompt; may be repeated")
+	flag.Var((*whatIfList)(&cfg.prompt.WhatIf), "what-if", "ask whether the index created by the CREATE INDEX `statement` would improve the plan, without creating it; may be repeated")
+	flag.BoolVar(&cfg.prompt.Trace, "include-trace", false, "include a summary of the longest spans and the retries, contention, and waits in the bundle's trace.json in the prompt, and print it to stderr")
+	flag.BoolVar(&cfg.prompt.ExtraFiles, "extra-files", false, "also include env.sql and opt.txt from the bundle in the prompt")
+	cfg.limits = bundle.DefaultLimits
+	flag.Int64Var(&cfg.limits.MaxFileSize, "max-file-size", bundle.DefaultMaxFileSize, "maximum uncompressed size in `bytes` of each file in a bundle (0 for no limit)")
+	flag.Int64Var(&cfg.maxBundleBytes, "max-bundle-bytes", defaultMaxBundleBytes, "maximum size in `bytes` of a bundle file or of the bundle read from stdin (0 for no limit)")
+	flag.DurationVar(&cfg.timeout, "timeout", defaultTimeout, "maximum time to wait for the API to respond")
+	list := flag.Bool("list", false, "print the files in each bundle with their uncompressed sizes and exit without analyzing")
+	showVersion := flag.Bool("version", false, "print the version and build information and exit")
+	flag.BoolVar(&verbose, "v", false, "log details of each step to stderr")
+	flag.BoolVar(&verbose, "verbose", false, "same as -v")
+	planDot := flag.String("plan-dot", "", "write the operator tree of plan.txt as a Graphviz DOT graph to the file at `path`, or to a file per bundle in the directory path if there are several")
+	flag.BoolVar(&cfg.autoShrink, "auto-shrink", false, "if the prompt is too long for the model's context, retry once with plan.txt and the other files truncated to fit")
+	flag.BoolVar(&cfg.strict, "strict", false, "refuse to analyze a bundle that does not look like a CockroachDB statement bundle instead of warning")
+	flag.StringVar(&cfg.debugDump, "debug-dump", "", "if an analysis fails, write the prompt, the last API request and response, and the flags to a new directory in `dir`, with the API key redacted")
+	addr := flag.String("addr", defaultServeAddr, "with serve, the `address` to listen on for HTTP requests")
+	requestTimeout := flag.Duration("request-timeout", defaultRequestTimeout, "with serve, the maximum time to handle a request, from reading the upload to writing the analysis")
+	pollInterval := flag.Duration("poll-interval", defaultPollInterval, "with analyze-dir, the time between scans of the directory for new bundles")
+	profileTimes := flag.Bool("profile", false, "print the wall-clock time spent in each stage of the analysis, such as reading the bundle and waiting for the API, to stderr")
+	flag.StringVar(&cfg.transcript, "transcript", "", "write the messages sent to the model, its replies, and their token usage to the JSON file at `path`, or to a file per bundle in the directory path if there are several")
+	flag.Usage = usage
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		// The error and usage have been printed.
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(exitUsage)
+	}
+	if *showVersion {
+		printVersion(os.Stdout)
+		return
+	}
+
+	// onCommandLine are the flags set on the command line, which, unlike
+	// those set by the config file, conflict with -profile-name.
+	onCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	// profiles are the prompt profiles that -profile-name may select, those
+	// defined in the config file taking precedence over the built-in ones.
+	profiles := maps.Clone(analyze.BuiltinProfiles)
+	var configProfiles map[string]analyze.PromptProfile
+	var err error
+	if *configPath != "" {
+		if configProfiles, err = applyConfigFile(flag.CommandLine, *configPath, true /* mustExist */); err != nil {
+			fatalUsage(fmt.Sprintf("invalid -config: %v", err))
+		}
+	} else if path := defaultConfigPath(); path != "" {
+		if configProfiles, err = applyConfigFile(flag.CommandLine, path, false /* mustExist */); err != nil {
+			fatalUsage(fmt.Sprintf("invalid config file: %v", err))
+		}
+	}
+	maps.Copy(profiles, configProfiles)
+
+	var apiKey string
+	if *apiKeyFile != "" {
+		key, err := analyze.ReadAPIKeyFile(*apiKeyFile)
+		if err != nil {
+			fatalUsage(fmt.Sprintf("invalid -api-key-file: %v", err))
+		}
+		apiKey = key
+	}
+	var proxyURL *url.URL
+	if *proxy != "" {
+		u, err := url.Parse(*proxy)
+		if err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "socks5"}, u.Scheme) {
+			fatalUsage(fmt.Sprintf("invalid -proxy %q: expected a URL such as http://proxy.example.com:8080", *proxy))
+		}
+		proxyURL = u
+	}
+	if isFlagSet("model") && *modelName == "" {
+		fatalUsage("-model must not be empty")
+	}
+	if (*organization != "" || *project != "") && *provider != analyze.ProviderOpenAI {
+		fatalUsage("-organization and -project require -provider openai")
+	}
+	if strings.TrimSpace(*systemPrompt) == "" {
+		fatalUsage("-system-prompt must not be empty")
+	}
+	// promptProfile is the prompt profile selected with -profile-name, which
+	// replaces the system message and the questions, including those set by
+	// the config file.
+	var promptProfile *analyze.PromptProfile
+	if *profileName != "" {
+		p, ok := profiles[*profileName]
+		if !ok {
+			fatalUsage(fmt.Sprintf("unknown -profile-name %q: expected one of %s", *profileName, strings.Join(analyze.ProfileNames(profiles), ", ")))
+		}
+		if onCommandLine["system-prompt"] || onCommandLine["prompt-file"] {
+			fatalUsage("-profile-name cannot be used with -system-prompt or -prompt-file")
+		}
+		promptProfile = &p
+		*systemPrompt = p.SystemPrompt
+		cfg.profileName = *profileName
+	}
+	if *lang != "" {
+		instruction, err := analyze.LanguageInstruction(*lang)
+		if err != nil {
+			fatalUsage(fmt.Sprintf("invalid -lang: %v", err))
+		}
+		if instruction != "" {
+			*systemPrompt += "\n" + instruction
+			cfg.lang = strings.ToLower(*lang)
+		}
+	}
+	if *temperature < 0 || *temperature > 2 {
+		fatalUsage("-temperature must be between 0 and 2")
+	}
+	if *noTemperature && isFlagSet("temperature") {
+		fatalUsage("-no-temperature cannot be used with -temperature")
+	}
+	if *maxCompletionTokens < 0 {
+		fatalUsage("-max-completion-tokens must not be negative")
+	}
+	if cfg.choices < 1 {
+		fatalUsage("-n must be at least 1")
+	}
+	if cfg.best && cfg.choices < 2 {
+		fatalUsage("-best requires -n greater than 1")
+	}
+	if cfg.choices > 1 && (cfg.stream || cfg.dryRun || cfg.offline) {
+		fatalUsage("-n cannot be used with -stream, -dry-run, or -offline")
+	}
+	// Without -best, every candidate is printed, so the output can't be used
+	// where a single analysis is expected.
+	if cfg.choices > 1 && !cfg.best && (cfg.format != formatText || cfg.ddlOnly || *interactive || compareMode || serveMode) {
+		fatalUsage("-n requires -best with -format json, jsonl, or markdown, -ddl-only, -interactive, compare, or serve")
+	}
+	if *retries < 0 {
+		fatalUsage("-retries must not be negative")
+	}
+	if *concurrency < 1 {
+		fatalUsage("-concurrency must be at least 1")
+	}
+	if *rpm < 0 {
+		fatalUsage("-rpm must not be negative")
+	}
+	if *rpm > 0 {
+		// Space the requests evenly rather than sending a minute's worth at
+		// once, which would trip limits enforced over shorter windows.
+		cfg.limiter = newRateLimiter(*rpm, 1)
+	}
+	if cfg.prompt.MaxTokens < 0 {
+		fatalUsage("-max-tokens must not be negative")
+	}
+	if cfg.prompt.TopOperators < 0 {
+		fatalUsage("-top-operators must not be negative")
+	}
+	if cfg.limits.MaxFileSize < 0 {
+		fatalUsage("-max-file-size must not be negative")
+	}
+	if cfg.maxBundleBytes < 0 {
+		fatalUsage("-max-bundle-bytes must not be negative")
+	}
+	if cfg.timeout <= 0 {
+		fatalUsage("-timeout must be positive")
+	}
+	fetchClient = analyze.NewHTTPClient(cfg.timeout, proxyURL)
+	switch cfg.format {
+	case formatText:
+	case formatJSON, formatJSONL, formatMarkdown:
+		if cfg.stream || (cfg.dryRun && cfg.format == formatJSONL) {
+			fatalUsage("-stream and -dry-run cannot be used with -format " + cfg.format)
+		}
+	default:
+		fatalUsage(fmt.Sprintf("unknown -format %q", cfg.format))
+	}
+	if *minConfidence != "" {
+		if !cfg.structured() && !cfg.offline || cfg.ddlOnly {
+			fatalUsage("-min-confidence requires -format json, jsonl, or markdown, or -offline, and cannot be used with -ddl-only")
+		}
+		c, err := analyze.ParseConfidence(*minConfidence)
+		if err != nil {
+			fatalUsage("invalid -min-confidence: " + err.Error())
+		}
+		cfg.minConfidence = c
+	}
+	if *toolCalling && (!cfg.structured() || cfg.ddlOnly || cfg.offline) {
+		fatalUsage("-tool-calling requires -format json, jsonl, or markdown and cannot be used with -ddl-only or -offline")
+	}
+	if cfg.jsonSchema && (!cfg.structured() || cfg.ddlOnly) {
+		fatalUsage("-json-schema requires -format json, jsonl, or markdown and cannot be used with -ddl-only")
+	}
+	if *interactive && (cfg.format != formatText || cfg.dryRun) {
+		fatalUsage("-interactive requires -format text and cannot be used with -dry-run")
+	}
+	if cfg.ddlOnly && (cfg.stream || *interactive || cfg.format == formatJSONL) {
+		fatalUsage("-ddl-only cannot be used with -stream, -interactive, or -format jsonl")
+	}
+	if cfg.offline && (cfg.stream || cfg.dryRun || cfg.ddlOnly || *interactive) {
+		fatalUsage("-offline cannot be used with -stream, -dry-run, -ddl-only, or -interactive")
+	}
+	if cfg.explain && (cfg.ddlOnly || cfg.offline || compareMode) {
+		fatalUsage("-explain cannot be used with -ddl-only, -offline, or compare")
+	}
+	cfg.summary = cfg.summary || cfg.summaryOnly
+	if cfg.summary && (cfg.dryRun || cfg.ddlOnly || cfg.offline || compareMode) {
+		fatalUsage("-summary and -summary-only cannot be used with -dry-run, -ddl-only, -offline, or compare")
+	}
+	if cfg.summaryOnly && (cfg.format != formatText || cfg.stream || *interactive) {
+		fatalUsage("-summary-only requires -format text and cannot be used with -stream or -interactive")
+	}
+	if cfg.summaryChars < 0 {
+		fatalUsage("-summary-chars must not be negative")
+	}
+	if *profileTimes && (compareMode || serveMode || watchMode) {
+		fatalUsage("-profile cannot be used with compare, serve, or analyze-dir")
+	}
+	if len(cfg.prompt.WhatIf) > 0 && (cfg.offline || compareMode) {
+		fatalUsage("-what-if cannot be used with -offline or compare")
+	}
+	if cfg.prompt.Trace && compareMode {
+		fatalUsage("-include-trace cannot be used with compare")
+	}
+	if cfg.diffStatsStdout && (cfg.format != formatText || cfg.stream || cfg.ddlOnly) {
+		fatalUsage("-diff-stats-stdout requires -format text and cannot be used with -stream or -ddl-only")
+	}
+	if *failOnFindings && !cfg.structured() {
+		fatalUsage("-fail-on-findings requires -format json, jsonl, or markdown")
+	}
+
+	// If any question is explicitly enabled, only the enabled questions are
+	// asked. Otherwise, every question that isn't disabled is asked.
+	explicit := false
+	for q, enabled := range questionFlags {
+		if isFlagSet("q-" + string(q)) {
+			if *promptFile != "" && promptProfile == nil {
+				fatalUsage("-q-" + string(q) + " cannot be used with -prompt-file")
+			}
+			if onCommandLine["q-"+string(q)] && promptProfile != nil {
+				fatalUsage("-q-" + string(q) + " cannot be used with -profile-name")
+			}
+			explicit = explicit || *enabled
+		}
+	}
+	var questions []analyze.Question
+	for _, q := range analyze.AllQuestions {
+		if explicit && !isFlagSet("q-"+string(q)) {
+			continue
+		}
+		if *questionFlags[q] {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		fatalUsage("at least one of -q-slowest, -q-schema, -q-query, and -q-indexes must be enabled")
+	}
+	cfg.basePrompt = analyze.BasePrompt(questions...)
+	if slices.Contains(questions, analyze.QuestionIndexes) {
+		cfg.basePrompt += analyze.IndexInstructions
+	}
+	// A statement without a plan or schema can only be reviewed for query
+	// anti-patterns.
+	cfg.statementPrompt = analyze.BasePrompt(analyze.QuestionQuery)
+	if *promptFile != "" && promptProfile == nil {
+		p, err := readPromptFile(*promptFile)
+		if err != nil {
+			fatalUsage(fmt.Sprintf("invalid -prompt-file: %v", err))
+		}
+		cfg.basePrompt = p + analyze.IndexInstructions
+		cfg.statementPrompt = cfg.basePrompt
+	}
+	if promptProfile != nil {
+		cfg.basePrompt = promptProfile.Prompt() + analyze.IndexInstructions
+		cfg.statementPrompt = cfg.basePrompt
+	}
+
+	if *clearCacheFlag {
+		if err := clearCache(); err != nil {
+			log.Fatalf("Failed to clear cache: %v", err)
+		}
+		if flag.NArg() == 0 {
+			return
+		}
+	}
+
+	var paths []string
+	switch {
+	case serveMode:
+		if flag.NArg() > 0 {
+			fatalUsage("serve does not take statement bundle paths; upload them to POST /analyze")
+		}
+	case watchMode:
+		if flag.NArg() != 1 {
+			fatalUsage("analyze-dir requires a single directory")
+		}
+		if err := checkWatchDir(flag.Arg(0)); err != nil {
+			fatalUsage(fmt.Sprintf("invalid directory: %v", err))
+		}
+	case flag.NArg() > 0:
+		paths = flag.Args()
+	case !stdinIsTerminal():
+		paths = []string{"-"}
+	default:
+		fatalUsage("missing statement bundle")
+	}
+	if compareMode {
+		if len(paths) != 2 || slices.Contains(paths, "-") {
+			fatalUsage("compare requires two statement bundle paths")
+		}
+		if cfg.format != formatText || cfg.ddlOnly || cfg.offline || *interactive {
+			fatalUsage("compare cannot be used with -format json, jsonl, or markdown, -ddl-only, -offline, or -interactive")
+		}
+	} else if serveMode {
+		if cfg.stream || cfg.dryRun || cfg.ddlOnly || *interactive || *list || *output != "" || *planDot != "" || cfg.transcript != "" {
+			fatalUsage("serve cannot be used with -stream, -dry-run, -ddl-only, -interactive, -list, -output, -plan-dot, or -transcript")
+		}
+		if cfg.format == formatJSONL {
+			fatalUsage("serve cannot be used with -format jsonl; use -format json")
+		}
+		if *requestTimeout <= 0 {
+			fatalUsage("-request-timeout must be positive")
+		}
+		// Messages are prefixed with the name of the uploaded bundle.
+		cfg.batch = true
+	} else if watchMode {
+		if cfg.stream || cfg.dryRun || *interactive || *list || *output != "" || *planDot != "" || cfg.transcript != "" {
+			fatalUsage("analyze-dir cannot be used with -stream, -dry-run, -interactive, -list, -output, -plan-dot, or -transcript")
+		}
+		if cfg.format == formatJSONL {
+			fatalUsage("analyze-dir cannot be used with -format jsonl; use -format json")
+		}
+		if *pollInterval <= 0 {
+			fatalUsage("-poll-interval must be positive")
+		}
+		// Messages are prefixed with the path of each bundle.
+		cfg.batch = true
+	} else if len(paths) > 1 {
+		if cfg.stream {
+			fatalUsage("-stream cannot be used with multiple bundles")
+		}
+		if slices.Contains(paths, "-") {
+			fatalUsage("stdin cannot be used with multiple bundles")
+		}
+		cfg.batch = true
+	}
+	if *interactive && (cfg.batch || paths[0] == "-") {
+		fatalUsage("-interactive requires a single bundle that is not read from stdin")
 	}
 
-	files, err := unzipInMemory(data)
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create output file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if *list {
+		if compareMode {
+			fatalUsage("-list cannot be used with compare")
+		}
+		failed := 0
+		for _, path := range paths {
+			if len(paths) > 1 {
+				fmt.Fprintf(out, "==> %s <==\n", path)
+			}
+			if err := listBundle(path, cfg.maxBundleBytes, out); err != nil {
+				log.Printf("%s: %v", path, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	opts := analyze.Options{
+		Model:        *modelName,
+		Endpoint:     *endpoint,
+		APIKey:       apiKey,
+		Organization: *organization,
+		Project:      *project,
+		NoAuth:       *noAuth,
+		SystemPrompt: *systemPrompt,
+		HTTPClient:   analyze.NewHTTPClient(cfg.timeout, proxyURL),
+		MaxTokens:    *maxCompletionTokens,
+		Retries:      *retries,
+		Choices:      cfg.choices,
+	}
+	if !*noTemperature {
+		opts.Temperature = temperature
+	}
+	if isFlagSet("seed") {
+		cfg.seed = seed
+		opts.Seed = seed
+	}
+	if cfg.debugDump != "" {
+		opts.HTTPClient.Transport = dumpTransport{base: opts.HTTPClient.Transport}
+	}
+	cfg.systemPrompt = *systemPrompt
+	if cfg.transcript != "" {
+		cfg.secrets = apiKeys(apiKey)
+	}
+	if cfg.stream {
+		opts.Stream = out
+	}
+	if cfg.structured() && !cfg.offline {
+		opts.JSONMode = true
+		opts.ToolCalling = *toolCalling
+	}
+	if verbose {
+		opts.Logf = debugf
+	}
+	if cfg.batch && cfg.limiter == nil {
+		cfg.limiter = &rateLimiter{}
+	}
+	opts.OnRateLimit = func(rl analyze.RateLimit) {
+		debugf("rate limit: %s", rl)
+		// When fewer requests remain than bundles are analyzed at once,
+		// the next round of requests would be rejected, so they wait
+		// until the limit resets instead of retrying after a 429.
+		if cfg.batch && rl.Remaining < *concurrency && rl.Reset > 0 {
+			cfg.limiter.pauseUntil(time.Now().Add(rl.Reset))
+			if !cfg.quiet {
+				fmt.Fprintf(os.Stderr, "rate limit nearly reached (%s), pausing requests\n", rl)
+			}
+		}
+	}
+	analyzer, err := analyze.New(*provider, opts)
 	if err != nil {
-		log.Fatalf("Failed to unzip: %v", err)
+		fatalUsage(err.Error())
+	}
+	if cfg.transcript != "" {
+		analyzer = transcriptAnalyzer{analyzer}
+	}
+	if *profileTimes {
+		analyzer = profileAnalyzer{analyzer}
+	}
+
+	if serveMode {
+		// The format of each analysis is chosen by its request, so there
+		// is an analyzer for each mode.
+		s := &server{text: analyzer, structured: analyzer, cfg: cfg, sem: make(chan struct{}, *concurrency), requestTimeout: *requestTimeout}
+		opts.JSONMode = !opts.JSONMode
+		opts.ToolCalling = opts.JSONMode && *toolCalling
+		other, err := analyze.New(*provider, opts)
+		if err != nil {
+			fatalUsage(err.Error())
+		}
+		if opts.JSONMode {
+			s.structured = other
+		} else {
+			s.text = other
+		}
+		runServe(cancelOnSignal(), *addr, s)
+		return
+	}
+	if watchMode {
+		w := &watcher{dir: flag.Arg(0), analyzer: analyzer, cfg: cfg, sem: make(chan struct{}, *concurrency), running: make(map[string]bool)}
+		runWatch(cancelOnSignal(), *pollInterval, w)
+		return
 	}
 
-	fmt.Printf("🔍 Analyzing statement bundle...\n\n")
-	prompt := buildPrompt(files)
-	response, err := sendToChatGPT(prompt)
+	// The banner goes to stderr, so that the analysis is the only thing on
+	// stdout.
+	if cfg.format == formatText && !cfg.dryRun && !cfg.quiet {
+		banner := "🔍 Analyzing statement bundle..."
+		if *noEmoji {
+			banner = "Analyzing statement bundle..."
+		}
+		fmt.Fprintf(os.Stderr, "%s\n\n", banner)
+	}
+
+	if *planDot != "" {
+		cfg.planDot = make(map[string]string, len(paths))
+		for i, file := range bundleOutputPaths(*planDot, paths, ".dot") {
+			cfg.planDot[paths[i]] = file
+		}
+	}
+
+	// Output to a terminal that doesn't fit on the screen is shown in a
+	// pager once it is complete, like git log. Streamed and interactive
+	// output is printed as it arrives instead.
+	paged := &pagedOutput{}
+	if *output == "" && !*noPager && !cfg.stream && !*interactive && isTerminal(os.Stdout, false) {
+		out = paged
+	}
+
+	ctx := cancelOnSignal()
+	if compareMode {
+		res, err := withTranscript(ctx, "compare", cfg.transcript, cfg, func(ctx context.Context) (bundleResult, error) {
+			return withDebugDump(ctx, "compare", cfg, func(ctx context.Context) (bundleResult, error) {
+				return compareBundles(ctx, paths[0], paths[1], analyzer, cfg)
+			})
+		})
+		if ctx.Err() != nil {
+			exitCancelled()
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprint(out, res.output)
+		paged.Flush()
+		return
+	}
+
+	// Analyze the bundles concurrently, but print the results in the order
+	// the bundles were given.
+	results := make([]chan bundleResult, len(paths))
+	sem := make(chan struct{}, *concurrency)
+	transcripts := make([]string, len(paths))
+	if cfg.transcript != "" {
+		transcripts = bundleOutputPaths(cfg.transcript, paths, ".json")
+	}
+	start := time.Now()
+	for i, path := range paths {
+		results[i] = make(chan bundleResult, 1)
+		go func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res, err := withProfile(ctx, *profileTimes, func(ctx context.Context) (bundleResult, error) {
+				return withTranscript(ctx, path, transcripts[i], cfg, func(ctx context.Context) (bundleResult, error) {
+					return withDebugDump(ctx, path, cfg, func(ctx context.Context) (bundleResult, error) {
+						return analyzeBundle(ctx, path, analyzer, cfg)
+					})
+				})
+			})
+			res.err = err
+			results[i] <- res
+		}()
+	}
+
+	failed, findings := 0, 0
+	// conversation is the analysis of the last bundle, continued in
+	// interactive mode.
+	var conversation []analyze.Message
+	// batchProfile is the total time spent in each stage by all of the
+	// bundles, with -profile.
+	batchProfile := &profile{}
+	for i, path := range paths {
+		res := <-results[i]
+		if ctx.Err() != nil {
+			exitCancelled()
+		}
+		if res.profile != nil {
+			prefix := ""
+			if cfg.batch {
+				prefix = path + ": "
+			}
+			fmt.Fprintf(os.Stderr, "%sprofile: %s\n", prefix, res.profile)
+			batchProfile.addProfile(res.profile)
+		}
+		if cfg.format == formatJSONL {
+			// Errors are reported in the output rather than ending the
+			// batch.
+			if err := writeJSONLine(out, path, res); err != nil {
+				log.Fatalf("Failed to write output: %v", err)
+			}
+			if res.err != nil {
+				failed++
+			}
+			findings += res.findings
+			continue
+		}
+		if cfg.batch {
+			fmt.Fprintf(out, "==> %s <==\n", path)
+		}
+		if res.err != nil {
+			if !cfg.batch {
+				log.Fatal(res.err)
+			}
+			log.Printf("%s: %v", path, res.err)
+			failed++
+			continue
+		}
+		fmt.Fprint(out, res.output)
+		if cfg.batch {
+			fmt.Fprintln(out)
+		}
+		findings += res.findings
+		conversation = res.conversation
+	}
+	paged.Flush()
+	if *profileTimes && cfg.batch {
+		// The bundles' totals exceed the wall-clock time when they are
+		// analyzed concurrently.
+		fmt.Fprintf(os.Stderr, "profile: %d bundles: %s (wall clock %s)\n", len(paths), batchProfile, roundDuration(time.Since(start)))
+	}
+	if failed > 0 {
+		log.Fatalf("Failed to analyze %d of %d bundles", failed, len(paths))
+	}
+	if *interactive {
+		err := runInteractive(ctx, analyzer, conversation, out, cfg)
+		if ctx.Err() != nil {
+			exitCancelled()
+		}
+		if err != nil {
+			log.Fatalf("Failed to read question: %v", err)
+		}
+	}
+	if *failOnFindings && findings > 0 {
+		log.Printf("Found %d anti-patterns and missing indexes", findings)
+		os.Exit(exitFindings)
+	}
+}
+
+// config holds the command line settings that control how each bundle is
+// analyzed.
+type config struct {
+	// basePrompt is the prompt prepended to the bundle's files, including
+	// any instructions for the format of suggested indexes.
+	basePrompt string
+	// statementPrompt replaces basePrompt when the bundle is a lone
+	// statement, such as a .sql file, without a plan or schema.
+	statementPrompt string
+	format          string
+	stream          bool
+	dryRun          bool
+	offline         bool
+	ddlOnly         bool
+	// choices is the number of candidate analyses requested, and best is
+	// true if the model picks one of them instead of printing them all.
+	choices int
+	best    bool
+	quiet   bool
+	noCache bool
+	timeout time.Duration
+	// limiter paces the API requests made for all bundles, if -rpm is set.
+	limiter *rateLimiter
+	prompt  analyze.PromptOptions
+	limits  bundle.Limits
+	// maxBundleBytes is the maximum size of a bundle before it is
+	// extracted, or 0 for no limit.
+	maxBundleBytes int64
+	// diffStats prints the table of the plan's operators to stderr, or to
+	// the output before the analysis if diffStatsStdout is set.
+	diffStats       bool
+	diffStatsStdout bool
+	// debugDump is the directory that diagnostics of failed analyses are
+	// written to, if set.
+	debugDump string
+	// jsonSchema requires the structured reply to conform to
+	// analyze.AnalysisSchema.
+	jsonSchema bool
+	// minConfidence, if set, is the confidence below which findings are
+	// left out of a structured analysis.
+	minConfidence analyze.Confidence
+	// lang is the code of the language the analysis is requested in, or ""
+	// for English.
+	lang string
+	// strict refuses bundles that don't look like CockroachDB statement
+	// bundles rather than warning about them.
+	strict bool
+	// seed is the seed the model samples with, if -seed is set.
+	seed *int64
+	// explain asks for the rationale and expected impact of each finding.
+	explain bool
+	// summary asks for a one-line summary of the analysis of at most
+	// summaryChars characters, which is printed after the analysis, or
+	// instead of it if summaryOnly is set.
+	summary      bool
+	summaryOnly  bool
+	summaryChars int
+	// autoShrink retries a prompt that is too long for the model's context
+	// once with the bundle's files truncated.
+	autoShrink bool
+	// planDot maps the path of each bundle to the file that the Graphviz
+	// graph of its plan is written to, if -plan-dot is set.
+	planDot map[string]string
+	// transcript is the file, or with several bundles the directory, that
+	// the exchanges with the model are written to, if set.
+	transcript string
+	// systemPrompt is the system message sent to the model, including the
+	// -lang instruction, which transcripts record and replies are cached
+	// under.
+	systemPrompt string
+	// profileName is the prompt profile selected with -profile-name, if
+	// any, which the reply is cached under.
+	profileName string
+	// secrets are the API keys redacted from transcripts.
+	secrets []string
+	// batch is true when more than one bundle is being analyzed.
+	batch bool
+}
+
+// structured returns true if the model is asked for a structured analysis,
+// which is parsed and printed in the output format.
+func (c config) structured() bool {
+	return c.format == formatJSON || c.format == formatJSONL || c.format == formatMarkdown
+}
+
+// bundleResult is the outcome of analyzing a single bundle.
+type bundleResult struct {
+	output string
+	// findings is the number of anti-patterns and missing indexes found by a
+	// structured analysis.
+	findings int
+	// conversation is the prompt and the model's reply, which follow-up
+	// questions are appended to in interactive mode.
+	conversation []analyze.Message
+	// analysis and usage are the structured analysis and the number of
+	// tokens it consumed, if known, which are printed with -format jsonl.
+	analysis *analyze.Analysis
+	usage    *analyze.Usage
+	// profile is the time spent in each stage of the analysis, with
+	// -profile.
+	profile *profile
+	err     error
+}
+
+// analyzeBundle reads and analyzes the bundle at path, returning the output to
+// print. If streaming, the analysis has already been written to the output and
+// the returned output is empty.
+func analyzeBundle(ctx context.Context, path string, analyzer analyze.Analyzer, cfg config) (bundleResult, error) {
+	warnf := log.Printf
+	// prefix identifies the bundle in messages when analyzing more than one.
+	prefix := ""
+	if cfg.batch {
+		prefix = path + ": "
+		warnf = func(format string, args ...any) {
+			log.Printf(prefix+format, args...)
+		}
+	}
+
+	files, err := readBundleFiles(ctx, path, cfg, prefix, warnf)
 	if err != nil {
-		log.Fatalf("API error: %v\n", err)
+		return bundleResult{}, err
 	}
-	fmt.Print(response)
+	return analyzeFiles(ctx, path, files, analyzer, cfg, prefix, warnf)
 }
 
-func unzipInMemory(zipData []byte) (map[string]string, error) {
-	reader, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+// analyzeFiles analyzes the files of the bundle at path, which have been read
+// and validated, like analyzeBundle. Messages about the bundle are prefixed
+// with prefix, and warnings are reported with warnf.
+func analyzeFiles(ctx context.Context, path string, files map[string]string, analyzer analyze.Analyzer, cfg config, prefix string, warnf func(format string, args ...any)) (bundleResult, error) {
+	stopParse := timeStage(ctx, stageParse)
+	if !cfg.quiet && !bundle.StatementOnly(files) {
+		if version, ok := bundle.Version(files); ok {
+			fmt.Fprintf(os.Stderr, "%sCockroachDB version: %s\n", prefix, version)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sCockroachDB version: unknown (not found in env.sql or version.txt)\n", prefix)
+		}
+	}
+
+	if schemaSQL, ok := files["schema.sql"]; ok && len(cfg.prompt.Tables) > 0 {
+		_, missing := schema.Filter(schemaSQL, cfg.prompt.Tables)
+		for _, name := range missing {
+			warnf("warning: table %s not found in schema.sql", name)
+		}
+	}
+
+	tables, errs := stats.ParseFiles(files)
+	for name, err := range errs {
+		warnf("warning: failed to parse %s: %v", name, err)
+	}
+	captured := analyze.CaptureTime(files)
+	for _, t := range tables {
+		if t.Stale(captured) {
+			warnf("warning: stale statistics: %s", t.Freshness(captured))
+		}
+	}
+	if summary := stats.SummarizeDistributions(tables); summary != "" && !cfg.quiet {
+		fmt.Fprintf(os.Stderr, "%sColumn distributions:\n%s\n", prefix, summary)
+	}
+
+	if cfg.prompt.Trace {
+		reportTrace(files, cfg, prefix, warnf)
+	}
+
+	// fingerprint identifies the statement across bundles.
+	var fingerprint string
+	if stmt, ok := files["statement.sql"]; ok {
+		fingerprint = analyze.Fingerprint(stmt)
+	}
+	// execution is whether the plan was distributed and vectorized, which is
+	// reported in structured output.
+	var execution plan.Execution
+	if planText, ok := files["plan.txt"]; ok {
+		if p, err := plan.Parse(planText); err == nil {
+			execution = p.Execution()
+		}
+	}
+
+	// redundant are the indexes in schema.sql that another index makes
+	// redundant, which are reported whatever the model finds.
+	redundant := heuristic.RedundantIndexes(files)
+
+	if schemaSQL, ok := files["schema.sql"]; ok && len(cfg.prompt.WhatIf) > 0 {
+		sch := schema.Parse(schemaSQL)
+		for _, stmt := range cfg.prompt.WhatIf {
+			for _, s := range analyze.ParseIndexSuggestions(stmt) {
+				if err := s.Validate(sch); err != nil {
+					warnf("warning: -what-if index %q: %v", s.Statement, err)
+				}
+			}
+		}
+	}
+
+	if dotPath := cfg.planDot[path]; dotPath != "" {
+		writePlanDot(files, dotPath, prefix, warnf)
+	}
+
+	showTopOperators := cfg.prompt.TopOperators > 0 && !cfg.quiet
+	// costTable is the -diff-stats-stdout table that precedes the analysis.
+	var costTable string
+	if planText, ok := files["plan.txt"]; ok && (showTopOperators || cfg.diffStats || cfg.diffStatsStdout) {
+		if p, err := plan.Parse(planText); err != nil {
+			warnf("warning: failed to parse plan.txt: %v", err)
+		} else {
+			if showTopOperators {
+				fmt.Fprintf(os.Stderr, "%sMost expensive operators:\n%s\n", prefix, p.SummarizeOperators(cfg.prompt.TopOperators))
+			}
+			if cfg.diffStatsStdout {
+				costTable = p.CostTable() + "\n"
+			} else if cfg.diffStats {
+				fmt.Fprintf(os.Stderr, "%sPlan operators:\n%s\n", prefix, p.CostTable())
+			}
+		}
+	}
+
+	stopParse()
+
+	if cfg.offline {
+		a := heuristic.Analyze(files)
+		a.Fingerprint = fingerprint
+		a.SetExecution(execution)
+		if cfg.minConfidence != "" {
+			a.FilterConfidence(cfg.minConfidence)
+		}
+		if cfg.structured() {
+			return formatAnalysis(a, cfg.format)
+		}
+		return bundleResult{output: costTable + a.Text(), findings: a.Findings()}, nil
+	}
+
+	instructions := cfg.basePrompt
+	if bundle.StatementOnly(files) {
+		instructions = cfg.statementPrompt
+	}
+	if cfg.structured() {
+		instructions += analyze.JSONInstructions
+		if cfg.explain {
+			instructions += analyze.ExplainJSONInstructions
+		}
+	} else if cfg.explain {
+		instructions += analyze.ExplainInstructions
+	}
+	promptOpts := cfg.prompt
+	if verbose {
+		promptOpts.Logf = func(format string, args ...any) {
+			debugf(prefix+format, args...)
+		}
+	}
+	if !cfg.quiet {
+		promptOpts.Truncatef = func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, prefix+format+"\n", args...)
+		}
+	}
+	stopPrompt := timeStage(ctx, stagePrompt)
+	prompt := analyze.BuildPrompt(instructions, files, promptOpts)
+	stopPrompt()
+	if rec := dumpRecorderFrom(ctx); rec != nil {
+		rec.setPrompt(prompt)
+	}
+	debugf("%sprompt is %d bytes (~%d tokens)", prefix, len(prompt), analyze.EstimateTokens(prompt))
+	if cfg.dryRun {
+		return bundleResult{output: prompt}, nil
+	}
+
+	if cfg.stream && fingerprint != "" && !cfg.quiet {
+		// The header can't precede the streamed analysis in the output.
+		fmt.Fprintf(os.Stderr, "%sFingerprint: %s\n", prefix, fingerprint)
+	}
+	r, err := complete(ctx, analyzer, prompt, cfg, prefix, warnf)
+	if limit, used, ok := analyze.ContextLengthExceeded(err); ok && cfg.autoShrink {
+		shrunk, budget, ok := shrinkPrompt(instructions, files, promptOpts, prompt, limit, used)
+		if ok {
+			warnf("warning: the prompt is too long for the model's context, retrying with the bundle's files truncated to ~%d tokens", budget)
+			if rec := dumpRecorderFrom(ctx); rec != nil {
+				rec.setPrompt(shrunk)
+			}
+			r, err = complete(ctx, analyzer, shrunk, cfg, prefix, warnf)
+		}
+	}
 	if err != nil {
-		return nil, err
+		return bundleResult{}, err
+	}
+	response := r.content
+	header := ""
+	if fingerprint != "" && !r.streamed {
+		header = "Fingerprint: " + fingerprint + "\n\n"
 	}
+	cfg.stream = r.streamed
 
-	files := make(map[string]string)
-	for _, file := range reader.File {
-		if file.FileInfo().IsDir() {
-			continue
+	suggestions := analyze.ParseIndexSuggestions(response)
+	if schemaSQL, ok := files["schema.sql"]; ok {
+		sch := schema.Parse(schemaSQL)
+		for _, s := range suggestions {
+			if err := s.Validate(sch); err != nil {
+				warnf("warning: suggested index %q: %v", s.Statement, err)
+			}
 		}
-		rc, err := file.Open()
+	}
+
+	conversation := []analyze.Message{
+		{Role: analyze.RoleUser, Content: prompt},
+		{Role: analyze.RoleAssistant, Content: response},
+	}
+	// summaryLine follows the text analysis, which may have been streamed
+	// already.
+	var summary, summaryLine string
+	if cfg.summary {
+		var summaryUsage *analyze.Usage
+		summary, summaryUsage, err = summarize(ctx, analyzer, conversation, cfg, prefix, warnf)
 		if err != nil {
-			return nil, err
+			return bundleResult{}, err
+		}
+		if r.usage == nil {
+			r.usage = summaryUsage
+		} else {
+			r.usage.PromptTokens += summaryUsage.PromptTokens
+			r.usage.CompletionTokens += summaryUsage.CompletionTokens
+			r.usage.TotalTokens += summaryUsage.TotalTokens
 		}
-		defer rc.Close()
+		if cfg.summaryOnly {
+			return bundleResult{output: summary + "\n"}, nil
+		}
+		summaryLine = "\nSummary: " + summary + "\n"
+	}
+	switch {
+	case cfg.ddlOnly:
+		return bundleResult{output: formatIndexStatements(suggestions)}, nil
+	case cfg.structured():
+		a, correctionUsage, err := parseAnalysis(ctx, analyzer, conversation, cfg, prefix, warnf)
+		if err != nil {
+			return bundleResult{}, fmt.Errorf("Invalid response: %w", err)
+		}
+		a.Fingerprint = fingerprint
+		a.SetExecution(execution)
+		a.Summary = summary
+		a.RedundantIndexes = redundant
+		if cfg.minConfidence != "" {
+			a.FilterConfidence(cfg.minConfidence)
+		}
+		res, err := formatAnalysis(a, cfg.format)
+		res.usage = r.usage
+		if correctionUsage != nil {
+			res.usage = correctionUsage
+			if r.usage != nil {
+				res.usage.PromptTokens += r.usage.PromptTokens
+				res.usage.CompletionTokens += r.usage.CompletionTokens
+				res.usage.TotalTokens += r.usage.TotalTokens
+			}
+		}
+		return res, err
+	case cfg.stream:
+		indexes := indexSection(response, suggestions)
+		return bundleResult{output: indexes + redundantSection(response+indexes, redundant) + summaryLine, conversation: conversation}, nil
+	default:
+		analysis := response + indexSection(response, suggestions)
+		return bundleResult{output: costTable + header + analysis + redundantSection(analysis, redundant) + summaryLine, conversation: conversation}, nil
+	}
+}
+
+// shrinkPrompt rebuilds prompt, which the model rejected as too long for its
+// context, with the bundle's files truncated so that it should fit. limit and
+// used are the tokens the model allows and the tokens it counted in prompt, if
+// it reported them. It returns the new prompt and the budget its files were
+// truncated to, or false if the files can't be truncated any further.
+func shrinkPrompt(instructions string, files map[string]string, opts analyze.PromptOptions, prompt string, limit, used int) (string, int, bool) {
+	estimate := analyze.EstimateTokens(prompt)
+	budget := estimate / 2
+	if limit > 0 && used > limit {
+		// Cut the tokens the prompt is over by, which also counts the system
+		// prompt, with a tenth of the limit to spare for the estimate, which
+		// may count tokens differently than the model does.
+		budget = estimate - (used - limit) - limit/10
+	}
+	if budget <= 0 {
+		return "", 0, false
+	}
+	opts.MaxTokens = budget
+	shrunk := analyze.BuildPrompt(instructions, files, opts)
+	return shrunk, budget, len(shrunk) < len(prompt)
+}
 
-		buf := new(strings.Builder)
-		_, err = io.Copy(buf, rc)
+// readBundleFiles reads and validates the bundle at path, returning its files
+// keyed by name. Messages about the bundle are prefixed with prefix.
+func readBundleFiles(ctx context.Context, path string, cfg config, prefix string, warnf func(format string, args ...any)) (map[string]string, error) {
+	if isBundleDir(path) {
+		stop := timeStage(ctx, stageRead)
+		files, err := bundle.ReadDir(path, cfg.limits)
+		stop()
+		var unreadable *bundle.UnreadableError
+		if errors.As(err, &unreadable) {
+			// Analyze the files that could be read.
+			warnf("warning: skipping unreadable files: %v", err)
+			err = nil
+		}
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Failed to read directory: %w", err)
+		}
+		defer timeStage(ctx, stageExtract)()
+		return checkBundleFiles(files, cfg, prefix, warnf)
+	}
+	stop := timeStage(ctx, stageRead)
+	data, err := readBundle(path, cfg.maxBundleBytes)
+	stop()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file: %w", err)
+	}
+	if len(data) == 0 && path == "-" {
+		return nil, fmt.Errorf("Failed to read file: no data on stdin, expected a statement bundle zip")
+	}
+	defer timeStage(ctx, stageExtract)()
+	return extractBundleFiles(path, data, cfg, prefix, warnf)
+}
+
+// extractBundleFiles extracts and validates the files of the bundle at path,
+// whose contents are data, like readBundleFiles.
+func extractBundleFiles(path string, data []byte, cfg config, prefix string, warnf func(format string, args ...any)) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Failed to extract bundle: %w", bundle.ErrEmpty)
+	}
+	// A .sql file, or any other text that isn't an archive, is a lone
+	// statement to review.
+	isSQL := strings.EqualFold(filepath.Ext(bundleBaseName(path)), ".sql")
+	var err error
+	var files map[string]string
+	if !isSQL {
+		files, err = bundle.Extract(data, cfg.limits)
+		var unreadable *bundle.UnreadableError
+		if errors.As(err, &unreadable) {
+			// Analyze the files that could be read.
+			warnf("warning: skipping unreadable files: %v", err)
+			err = nil
+		}
+		isSQL = err != nil && !bundle.IsBinary(string(data))
+	}
+	if isSQL {
+		if cfg.limits.MaxFileSize > 0 && int64(len(data)) > cfg.limits.MaxFileSize {
+			return nil, fmt.Errorf("Failed to read file: %s exceeds the maximum file size of %d bytes", path, cfg.limits.MaxFileSize)
 		}
+		debugf("%sreading %s as statement.sql", prefix, path)
+		return map[string]string{"statement.sql": string(data)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to extract bundle: %w", err)
+	}
+	return checkBundleFiles(files, cfg, prefix, warnf)
+}
 
-		files[file.Name] = buf.String()
+// checkBundleFiles drops the binary files of an extracted bundle and flattens
+// the rest, returning an error if it holds none of the files used for
+// analysis, and warning about the other files it lacks.
+func checkBundleFiles(files map[string]string, cfg config, prefix string, warnf func(format string, args ...any)) (map[string]string, error) {
+	if verbose {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			debugf("%sfound %s (%d bytes)", prefix, name, len(files[name]))
+		}
+	}
+	for _, name := range bundle.RemoveBinary(files) {
+		warnf("warning: skipping binary file %s", name)
+	}
+	for _, name := range bundle.Flatten(files) {
+		warnf("warning: skipping %s, which has the same base name as a file nearer the top of the bundle", name)
+	}
+	if err := bundle.Validate(files); err != nil {
+		return nil, fmt.Errorf("Invalid bundle: %w", err)
+	}
+	if missing := bundle.MissingMarkers(files); len(missing) > 0 {
+		if cfg.strict {
+			return nil, fmt.Errorf("Invalid bundle: does not look like a CockroachDB statement bundle: %s", strings.Join(missing, "; "))
+		}
+		warnf("warning: bundle does not look like a CockroachDB statement bundle: %s", strings.Join(missing, "; "))
+	}
+	for _, name := range bundle.FileNames {
+		if _, ok := files[name]; !ok {
+			warnf("warning: %s not found in bundle, analysis may be incomplete", name)
+		}
 	}
 	return files, nil
 }
 
-func buildPrompt(files map[string]string) string {
-	var buf bytes.Buffer
-	buf.WriteString(basePrompt)
-	for _, name := range fileNames {
-		if content, ok := files[name]; ok {
-			buf.WriteString(content)
-			buf.WriteByte('\n')
+// reply is the model's reply to a prompt.
+type reply struct {
+	content string
+	// streamed is true if the reply was streamed to the output as it was
+	// generated.
+	streamed bool
+	// usage is the number of tokens consumed, or nil if the reply was
+	// cached.
+	usage *analyze.Usage
+}
+
+// complete returns the model's reply to prompt, from the cache if possible.
+func complete(ctx context.Context, analyzer analyze.Analyzer, prompt string, cfg config, prefix string, warnf func(format string, args ...any)) (reply, error) {
+	model := analyzer.Model()
+	if cfg.choices > 1 {
+		// Distinguish the candidates, or the chosen one, from a single reply.
+		model = fmt.Sprintf("%s n=%d best=%t", model, cfg.choices, cfg.best)
+	}
+	if cfg.lang != "" {
+		// The language is asked for in the system prompt, which isn't part
+		// of the prompt the reply is cached under.
+		model += " lang=" + cfg.lang
+	}
+	if cfg.profileName != "" {
+		// Like the language, the profile's system message isn't part of the
+		// prompt.
+		model += " profile=" + cfg.profileName
+	}
+	if cfg.systemPrompt != "" && cfg.systemPrompt != analyze.DefaultSystemPrompt {
+		// Nor is a -system-prompt, so a hash of it distinguishes the
+		// replies to different ones.
+		model += " system=" + systemPromptHash(cfg.systemPrompt)
+	}
+	if cfg.seed != nil {
+		// Each seed gives its own reply.
+		model += fmt.Sprintf(" seed=%d", *cfg.seed)
+	}
+	key := cacheKey(model, prompt)
+	if !cfg.noCache {
+		if response, ok := readCache(key); ok {
+			if !cfg.quiet {
+				fmt.Fprintf(os.Stderr, "%s(cached)\n", prefix)
+			}
+			recordCached(ctx, analyzer.Model(), prompt, response)
+			// Nothing has been streamed, so the cached response is printed
+			// as usual.
+			return reply{content: response}, nil
+		}
+	}
+
+	if err := waitForLimiter(ctx, cfg, prefix); err != nil {
+		return reply{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	stop := startSpinner(cfg)
+	comp, err := analyzer.Analyze(ctx, prompt)
+	stop()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return reply{}, fmt.Errorf("API error: request timed out after %s", cfg.timeout)
+	}
+	if err != nil {
+		return reply{}, fmt.Errorf("Failed to analyze bundle: %w", err)
+	}
+	if !cfg.quiet {
+		fmt.Fprintf(os.Stderr, "%s%s\n", prefix, analyze.SummarizeUsage(comp.Model, comp.Usage))
+		if comp.SystemFingerprint != "" {
+			// The fingerprint changes when the backend does, which may
+			// change the reply despite -seed.
+			fmt.Fprintf(os.Stderr, "%ssystem fingerprint: %s\n", prefix, comp.SystemFingerprint)
+		}
+	}
+	warnFinishReason(comp, warnf)
+	// Don't cache a reply that was cut off, so that it isn't reused once
+	// the problem is fixed.
+	truncated := comp.FinishReason == analyze.FinishLength || comp.FinishReason == analyze.FinishContentFilter
+	r := reply{content: comp.Content, streamed: cfg.stream, usage: &comp.Usage}
+	if len(comp.Choices) > 1 {
+		var pickUsage analyze.Usage
+		r.content, pickUsage, err = chooseCandidate(ctx, analyzer, comp.Choices, cfg, prefix)
+		if err != nil {
+			return reply{}, err
+		}
+		r.usage.PromptTokens += pickUsage.PromptTokens
+		r.usage.CompletionTokens += pickUsage.CompletionTokens
+		r.usage.TotalTokens += pickUsage.TotalTokens
+	}
+	if !cfg.noCache && !truncated {
+		if err := writeCache(key, r.content); err != nil {
+			warnf("warning: failed to cache response: %v", err)
 		}
 	}
+	return r, nil
+}
+
+// warnFinishReason warns if the model's reply was cut off before it was
+// complete.
+func warnFinishReason(comp *analyze.Completion, warnf func(format string, args ...any)) {
+	switch comp.FinishReason {
+	case analyze.FinishLength:
+		warnf("warning: the reply was cut off at the token limit and may be incomplete; try a larger -max-completion-tokens")
+	case analyze.FinishContentFilter:
+		warnf("warning: the reply was cut off by the provider's content filter and may be incomplete; " +
+			"if literals in the statement triggered it, try -redact")
+	}
+}
+
+// waitForLimiter waits until -rpm, or the rate limit reported by the API,
+// allows another API request. The wait does not count toward the request's
+// timeout.
+func waitForLimiter(ctx context.Context, cfg config, prefix string) error {
+	if cfg.limiter != nil {
+		defer timeStage(ctx, stageWait)()
+	}
+	waited, err := cfg.limiter.wait(ctx)
+	if waited > 0 {
+		debugf("%swaited %s for the rate limit", prefix, waited.Round(time.Millisecond))
+	}
+	return err
+}
+
+// chooseCandidate returns the candidate analysis picked by the model if -best
+// is set, and otherwise all of the candidates, numbered. It also returns the
+// number of tokens consumed by picking a candidate.
+func chooseCandidate(ctx context.Context, analyzer analyze.Analyzer, candidates []string, cfg config, prefix string) (string, analyze.Usage, error) {
+	if !cfg.best {
+		var buf strings.Builder
+		for i, c := range candidates {
+			if i > 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(&buf, "--- Candidate %d of %d ---\n\n%s", i+1, len(candidates), strings.TrimRight(c, "\n")+"\n")
+		}
+		return buf.String(), analyze.Usage{}, nil
+	}
+
+	if err := waitForLimiter(ctx, cfg, prefix); err != nil {
+		return "", analyze.Usage{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	stop := startSpinner(cfg)
+	i, comp, err := analyze.PickBest(ctx, analyzer, candidates)
+	stop()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", analyze.Usage{}, fmt.Errorf("API error: request timed out after %s", cfg.timeout)
+	}
+	if err != nil {
+		return "", analyze.Usage{}, fmt.Errorf("Failed to pick the best analysis: %w", err)
+	}
+	if !cfg.quiet {
+		fmt.Fprintf(os.Stderr, "%spicked candidate %d of %d (%s)\n", prefix, i+1, len(candidates), analyze.SummarizeUsage(comp.Model, comp.Usage))
+	}
+	return candidates[i], comp.Usage, nil
+}
+
+// parseAnalysis parses the model's structured reply, the last message of the
+// conversation. With -json-schema, a reply that does not conform to
+// analyze.AnalysisSchema is sent back to the model once to be corrected, and
+// the number of tokens consumed by the correction is also returned.
+func parseAnalysis(ctx context.Context, analyzer analyze.Analyzer, conversation []analyze.Message, cfg config, prefix string, warnf func(format string, args ...any)) (*analyze.Analysis, *analyze.Usage, error) {
+	response := conversation[len(conversation)-1].Content
+	if !cfg.jsonSchema {
+		a, err := analyze.ParseAnalysis(response)
+		return a, nil, err
+	}
+	a, invalid := analyze.ValidateAnalysis(response)
+	if invalid == nil {
+		return a, nil, nil
+	}
+	warnf("warning: %v; asking the model to correct it", invalid)
+
+	if err := waitForLimiter(ctx, cfg, prefix); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	stop := startSpinner(cfg)
+	comp, err := analyzer.Chat(ctx, append(conversation, analyze.Message{Role: analyze.RoleUser, Content: analyze.SchemaCorrection(invalid)}))
+	stop()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, nil, fmt.Errorf("API error: request timed out after %s", cfg.timeout)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to correct the analysis: %w", err)
+	}
+	if !cfg.quiet {
+		fmt.Fprintf(os.Stderr, "%scorrected the analysis (%s)\n", prefix, analyze.SummarizeUsage(comp.Model, comp.Usage))
+	}
+	warnFinishReason(comp, warnf)
+	a, err = analyze.ValidateAnalysis(comp.Content)
+	return a, &comp.Usage, err
+}
+
+// summarize asks the model for a one-line summary of the analysis, the last
+// message of the conversation, returning it and the number of tokens it
+// consumed.
+func summarize(ctx context.Context, analyzer analyze.Analyzer, conversation []analyze.Message, cfg config, prefix string, warnf func(format string, args ...any)) (string, *analyze.Usage, error) {
+	if err := waitForLimiter(ctx, cfg, prefix); err != nil {
+		return "", nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	stop := startSpinner(cfg)
+	summary, comp, err := analyze.Summarize(ctx, analyzer, conversation, cfg.summaryChars)
+	stop()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "", nil, fmt.Errorf("API error: request timed out after %s", cfg.timeout)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to summarize the analysis: %w", err)
+	}
+	if !cfg.quiet {
+		fmt.Fprintf(os.Stderr, "%ssummarized the analysis (%s)\n", prefix, analyze.SummarizeUsage(comp.Model, comp.Usage))
+	}
+	warnFinishReason(comp, warnf)
+	return summary, &comp.Usage, nil
+}
+
+// formatAnalysis returns the result for a structured analysis, printed in the
+// given structured format.
+func formatAnalysis(a *analyze.Analysis, format string) (bundleResult, error) {
+	switch format {
+	case formatMarkdown:
+		return bundleResult{output: a.Markdown(), findings: a.Findings()}, nil
+	case formatJSONL:
+		// The line is printed along with the bundle's name and any error.
+		return bundleResult{analysis: a, findings: a.Findings()}, nil
+	default:
+		return formatJSONAnalysis(a)
+	}
+}
+
+// jsonLine is a line of -format jsonl output, describing the analysis of a
+// single bundle or the error that prevented it.
+type jsonLine struct {
+	Bundle string `json:"bundle"`
+	*analyze.Analysis
+	Usage *analyze.Usage `json:"usage,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// writeJSONLine writes the result of analyzing the bundle at path to out as a
+// single line of JSON.
+func writeJSONLine(out io.Writer, path string, res bundleResult) error {
+	line := jsonLine{Bundle: path, Analysis: res.analysis, Usage: res.usage}
+	if res.err != nil {
+		line = jsonLine{Bundle: path, Error: res.err.Error()}
+	}
+	// Encode writes a trailing newline.
+	return json.NewEncoder(out).Encode(line)
+}
+
+// formatJSONAnalysis returns the result for a structured analysis, printed as
+// indented JSON.
+func formatJSONAnalysis(a *analyze.Analysis) (bundleResult, error) {
+	out, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return bundleResult{}, fmt.Errorf("Failed to encode analysis: %w", err)
+	}
+	return bundleResult{output: string(out) + "\n", findings: a.Findings()}, nil
+}
+
+// redundantSection returns the redundant indexes found in schema.sql, listed
+// after the analysis in text output.
+func redundantSection(analysis string, redundant []analyze.Finding) string {
+	if len(redundant) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteString("\n")
+	if !strings.HasSuffix(analysis, "\n") {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("Redundant indexes:\n")
+	for _, f := range redundant {
+		fmt.Fprintf(&buf, "- %s\n", f.Text)
+	}
 	return buf.String()
 }
 
-type request struct {
-	Model    string    `json:"model"`
-	Messages []message `json:"messages"`
+// formatIndexStatements returns the suggested CREATE INDEX statements, one per
+// line.
+func formatIndexStatements(suggestions []analyze.IndexSuggestion) string {
+	var buf strings.Builder
+	for _, s := range suggestions {
+		buf.WriteString(s.Statement)
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// indexSection returns the section listing the suggested CREATE INDEX
+// statements that follows the analysis, or "" if there are none.
+func indexSection(response string, suggestions []analyze.IndexSuggestion) string {
+	if len(suggestions) == 0 {
+		return ""
+	}
+	sep := "\n"
+	if !strings.HasSuffix(response, "\n") {
+		sep = "\n\n"
+	}
+	return sep + "Suggested indexes:\n" + formatIndexStatements(suggestions)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <statement_bundle.zip | -> [statement_bundle.zip...]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s compare [flags] <before.zip> <after.zip>\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s serve [flags]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s analyze-dir [flags] <dir>\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s extract <statement_bundle.zip | -> <filename>\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "       %s version\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
 }
 
-type message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+// verbose enables the messages logged by debugf. It is set by -v.
+var verbose bool
+
+// debugf logs a message to stderr if -v is set.
+func debugf(format string, args ...any) {
+	if verbose {
+		log.Printf(format, args...)
+	}
+}
+
+// isFlagSet returns true if the named flag was passed on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// globList is a flag holding a list of file name glob patterns. It may be
+// repeated, and each value may hold several comma-separated patterns.
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		*g = append(*g, pattern)
+	}
+	return nil
 }
 
-type response struct {
-	Choices []struct {
-		Message message `json:"message"`
-	} `json:"choices"`
+// tableList is a flag holding the names of the tables passed to -tables. It
+// may be repeated, and each value may hold several comma-separated names.
+type tableList []string
+
+func (t *tableList) String() string {
+	return strings.Join(*t, ",")
 }
 
-func sendToChatGPT(prompt string) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY not set")
+func (t *tableList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			*t = append(*t, name)
+		}
 	}
+	return nil
+}
+
+// noteList is a flag holding the notes passed with each use of -note.
+type noteList []string
 
-	reqBody := request{
-		Model: model,
-		Messages: []message{
-			{Role: "system", Content: "You are a database performance expert."},
-			{Role: "user", Content: prompt},
-		},
+func (n *noteList) String() string {
+	return strings.Join(*n, "; ")
+}
+
+func (n *noteList) Set(value string) error {
+	if value = strings.TrimSpace(value); value == "" {
+		return errors.New("note must not be empty")
 	}
+	*n = append(*n, value)
+	return nil
+}
+
+// whatIfList is a flag holding the CREATE INDEX statements passed with each use
+// of -what-if.
+type whatIfList []string
+
+func (w *whatIfList) String() string {
+	return strings.Join(*w, " ")
+}
 
-	jsonBody, err := json.Marshal(reqBody)
+func (w *whatIfList) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if len(analyze.ParseIndexSuggestions(value)) != 1 {
+		return fmt.Errorf("expected a CREATE INDEX statement, such as \"CREATE INDEX ON users (email)\", got %q", value)
+	}
+	if !strings.HasSuffix(value, ";") {
+		value += ";"
+	}
+	*w = append(*w, value)
+	return nil
+}
+
+// fatalUsage prints msg followed by the usage text and exits with status
+// exitUsage.
+func fatalUsage(msg string) {
+	fmt.Fprintf(flag.CommandLine.Output(), "%s\n\n", msg)
+	flag.Usage()
+	os.Exit(exitUsage)
+}
+
+// readPromptFile returns the contents of the custom prompt file at path.
+func readPromptFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
+	if strings.TrimSpace(string(data)) == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return string(data), nil
+}
+
+// defaultMaxBundleBytes is the default -max-bundle-bytes, far larger than any
+// statement bundle, but small enough to refuse a database dump given by
+// mistake before it is read into memory.
+const defaultMaxBundleBytes = 100 << 20
 
-	req, err := http.NewRequestWithContext(context.Background(), "POST", openaiEndpoint, bytes.NewBuffer(jsonBody))
+// openBundle opens the bundle at path, returning its size, or -1 if it is
+// unknown. A path of "-" reads the bundle from stdin, and an http:// or
+// https:// URL fetches it.
+func openBundle(path string) (io.ReadCloser, int64, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), -1, nil
+	}
+	if isBundleURL(path) {
+		return fetchBundle(path)
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		return "", err
+		return nil, 0, err
+	}
+	// Pipes and other special files have no size, so they are limited as
+	// they are read, like stdin.
+	if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+		return f, fi.Size(), nil
 	}
+	return f, -1, nil
+}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
+// isBundleDir returns true if the bundle path is a directory holding a bundle
+// that has already been extracted.
+func isBundleDir(path string) bool {
+	if path == "-" || isBundleURL(path) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// readBundle returns the contents of the bundle at path, or "-" for stdin. If
+// maxBytes is positive, a bundle larger than maxBytes is refused: a file, or a
+// URL whose response has a Content-Length, is checked before any of it is
+// read, and stdin once more than maxBytes have been read from it.
+func readBundle(path string, maxBytes int64) ([]byte, error) {
+	r, size, err := openBundle(path)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	defer r.Close()
+	if maxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	if size > maxBytes {
+		return nil, bundleTooLarge(path, size, maxBytes)
 	}
-	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, bundleTooLarge(path, -1, maxBytes)
+	}
+	return data, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API call failed: %s", bodyBytes)
+// bundleTooLarge returns the error for a bundle at path of size bytes, or of
+// an unknown size if it is negative, that is larger than maxBytes.
+func bundleTooLarge(path string, size, maxBytes int64) error {
+	name := path
+	if path == "-" {
+		name = "stdin"
+	} else if isBundleURL(path) {
+		name = displayURL(path)
+	}
+	if size < 0 {
+		return fmt.Errorf("%s is larger than the maximum bundle size of %d bytes; set -max-bundle-bytes to read it", name, maxBytes)
 	}
+	return fmt.Errorf("%s is %d bytes, larger than the maximum bundle size of %d bytes; set -max-bundle-bytes to read it", name, size, maxBytes)
+}
 
-	var chatResp response
-	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
-		return "", err
+// reportTrace prints the summary of the bundle's trace.json that -include-trace
+// adds to the prompt to stderr, or warns if the trace is missing or can't be
+// parsed, in which case the prompt goes without it.
+func reportTrace(files map[string]string, cfg config, prefix string, warnf func(format string, args ...any)) {
+	content, ok := files[trace.FileName]
+	if !ok {
+		if !bundle.StatementOnly(files) {
+			warnf("warning: -include-trace: %s not found in the bundle", trace.FileName)
+		}
+		return
 	}
+	t, err := trace.Parse(content)
+	if err != nil {
+		warnf("warning: failed to parse %s, leaving it out of the prompt: %v", trace.FileName, err)
+		return
+	}
+	if !cfg.quiet {
+		fmt.Fprintf(os.Stderr, "%sTrace:\n%s\n", prefix, t.Summarize(analyze.TraceSpans, analyze.TraceEvents))
+	}
+}
 
-	return chatResp.Choices[0].Message.Content, nil
+// writePlanDot writes the operator tree of the plan in files to path as a
+// Graphviz DOT graph, warning if it can't.
+func writePlanDot(files map[string]string, path, prefix string, warnf func(format string, args ...any)) {
+	planText, ok := files["plan.txt"]
+	if !ok {
+		warnf("warning: no plan.txt to write to %s", path)
+		return
+	}
+	p, err := plan.Parse(planText)
+	if err != nil {
+		warnf("warning: failed to parse plan.txt: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+		err = os.WriteFile(path, []byte(p.DOT()), 0o644)
+	}
+	if err != nil {
+		warnf("warning: failed to write plan graph: %v", err)
+		return
+	}
+	debugf("%swrote plan graph to %s", prefix, path)
+}
+
+// bundleOutputPaths returns the file that a flag such as -transcript writes
+// for each of the bundles. With a single bundle it is path itself, and with
+// several it is a file in the directory path named after the bundle, with the
+// extension ext.
+func bundleOutputPaths(path string, bundles []string, ext string) []string {
+	if len(bundles) == 1 {
+		return []string{path}
+	}
+	paths := make([]string, len(bundles))
+	used := make(map[string]bool)
+	for i, b := range bundles {
+		base := bundleBaseName(b)
+		name := strings.TrimSuffix(base, filepath.Ext(base))
+		if b == "-" {
+			name = "stdin"
+		}
+		// Bundles with the same name in different directories get
+		// distinct files.
+		file := name + ext
+		for n := 2; used[file]; n++ {
+			file = fmt.Sprintf("%s-%d%s", name, n, ext)
+		}
+		used[file] = true
+		paths[i] = filepath.Join(path, file)
+	}
+	return paths
+}
+
+// stdinIsTerminal returns true if stdin is attached to a terminal rather than
+// a pipe or file.
+func stdinIsTerminal() bool {
+	return isTerminal(os.Stdin, true)
+}
+
+// isTerminal returns true if f is attached to a terminal rather than a pipe or
+// file, or def if that cannot be determined.
+func isTerminal(f *os.File, def bool) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return def
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultPager is the pager used when PAGER is not set. -R passes the color
+// codes of the analysis through.
+const defaultPager = "less -R"
+
+// pagedOutput buffers the output printed to a terminal, so that it can be
+// shown in a pager once it is complete if it doesn't fit on the screen.
+type pagedOutput struct {
+	buf bytes.Buffer
+}
+
+// Write implements the io.Writer interface.
+func (p *pagedOutput) Write(b []byte) (int, error) {
+	return p.buf.Write(b)
+}
+
+// Flush shows the output in the pager named by PAGER if it is taller than the
+// terminal, and otherwise prints it to stdout, as it also does if the pager
+// can't be run. The output is emptied, so Flush may be called more than once.
+func (p *pagedOutput) Flush() {
+	defer p.buf.Reset()
+	if p.buf.Len() == 0 {
+		return
+	}
+	width, height, ok := terminalSize(os.Stdout)
+	if !ok || rows(p.buf.String(), width) < height {
+		os.Stdout.Write(p.buf.Bytes())
+		return
+	}
+	if err := runPager(p.buf.Bytes()); err != nil {
+		debugf("not paging the output: %v", err)
+		os.Stdout.Write(p.buf.Bytes())
+	}
+}
+
+// errNoPager is returned by runPager when PAGER is set to disable paging.
+var errNoPager = errors.New("PAGER is empty or cat")
+
+// runPager runs the pager named by PAGER, or defaultPager if it is not set,
+// with output as its input, and waits for the user to quit it. If the pager
+// can't be started, nothing has been printed and the error is returned.
+func runPager(output []byte) error {
+	command, ok := os.LookupEnv("PAGER")
+	if !ok {
+		command = defaultPager
+	}
+	args := strings.Fields(command)
+	if len(args) == 0 || args[0] == "cat" {
+		return errNoPager
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Stdin = bytes.NewReader(output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// The pager handles Ctrl-C itself, such as to stop a search in less,
+	// so it mustn't cancel bundlebot while the pager is running.
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// Once the pager has started, it may have shown some of the output, so
+	// it isn't printed again if the pager fails.
+	cmd.Wait()
+	return nil
+}
+
+// rows returns the number of terminal rows that s takes up on a terminal
+// width columns wide, counting lines that wrap.
+func rows(s string, width int) int {
+	n := 0
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		n += max(1, (utf8.RuneCountInString(line)+width-1)/max(width, 1))
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DOT returns the plan's operator tree as a Graphviz DOT graph, with the root
+// at the top. Each operator is labeled with its table or index, its estimated
+// and actual rows, and its time, leaving out those that are unknown, such as
+// the actual rows and time of a plan from EXPLAIN without ANALYZE. Each edge
+// points from an operator to its parent, the way rows flow, and is labeled
+// with the number of rows the operator produced.
+func (p *Plan) DOT() string {
+	var buf strings.Builder
+	buf.WriteString("digraph plan {\n")
+	buf.WriteString("  rankdir=BT;\n")
+	buf.WriteString("  node [shape=box, fontname=\"Helvetica\"];\n")
+	buf.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+	id := 0
+	var walk func(n *Node) int
+	walk = func(n *Node) int {
+		nodeID := id
+		id++
+		fmt.Fprintf(&buf, "  n%d [label=%s];\n", nodeID, dotQuote(n.dotLabel()))
+		for _, child := range n.Children {
+			childID := walk(child)
+			fmt.Fprintf(&buf, "  n%d -> n%d", childID, nodeID)
+			if rows := child.dotRows(); rows != "" {
+				fmt.Fprintf(&buf, " [label=%s]", dotQuote(rows))
+			}
+			buf.WriteString(";\n")
+		}
+		return nodeID
+	}
+	if p.Root != nil {
+		walk(p.Root)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// dotLabel returns the lines of the operator's label in a DOT graph.
+func (n *Node) dotLabel() string {
+	lines := []string{n.Label()}
+	if n.EstimatedRows >= 0 {
+		lines = append(lines, fmt.Sprintf("estimated rows: %d", n.EstimatedRows))
+	}
+	if n.ActualRows >= 0 {
+		lines = append(lines, fmt.Sprintf("actual rows: %d", n.ActualRows))
+	}
+	if n.ExecTime >= 0 {
+		lines = append(lines, "time: "+n.ExecTime.String())
+	}
+	if n.KVTime >= 0 {
+		lines = append(lines, "KV time: "+n.KVTime.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dotRows returns the label of the edge from the operator to its parent: the
+// number of rows it produced, or its estimate if that is unknown.
+func (n *Node) dotRows() string {
+	switch {
+	case n.ActualRows >= 0:
+		return fmt.Sprintf("%d rows", n.ActualRows)
+	case n.EstimatedRows >= 0:
+		return fmt.Sprintf("~%d rows", n.EstimatedRows)
+	}
+	return ""
+}
+
+// dotQuote returns s as a quoted DOT string, with its newlines as line breaks
+// that center each line.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
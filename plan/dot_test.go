@@ -0,0 +1,87 @@
+package plan
+
+import "testing"
+
+func TestDOT(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "explain analyze",
+			text: analyzePlan,
+			want: `digraph plan {
+  rankdir=BT;
+  node [shape=box, fontname="Helvetica"];
+  edge [fontname="Helvetica", fontsize=10];
+  n0 [label="hash join\nestimated rows: 1000\nactual rows: 1000\ntime: 2ms"];
+  n1 [label="scan (orders@orders_pkey)\nestimated rows: 1000\nactual rows: 1000\nKV time: 1ms"];
+  n1 -> n0 [label="1000 rows"];
+  n2 [label="scan (customers@customers_pkey)\nestimated rows: 1000\nactual rows: 1000\nKV time: 2ms"];
+  n2 -> n0 [label="1000 rows"];
+}
+`,
+		},
+		{
+			// Without ANALYZE, edges are labeled with estimates, and
+			// operators without one have unlabeled edges.
+			name: "explain",
+			text: explainPlan,
+			want: `digraph plan {
+  rankdir=BT;
+  node [shape=box, fontname="Helvetica"];
+  edge [fontname="Helvetica", fontsize=10];
+  n0 [label="sort\nestimated rows: 333"];
+  n1 [label="filter"];
+  n2 [label="scan (users@users_pkey)"];
+  n2 -> n1;
+  n1 -> n0;
+}
+`,
+		},
+		{
+			name: "quotes",
+			text: `• filter
+│ estimated row count: 10
+│
+└── • scan
+      estimated row count: 20
+      table: "My Table"@primary
+`,
+			want: `digraph plan {
+  rankdir=BT;
+  node [shape=box, fontname="Helvetica"];
+  edge [fontname="Helvetica", fontsize=10];
+  n0 [label="filter\nestimated rows: 10"];
+  n1 [label="scan (\"My Table\"@primary)\nestimated rows: 20"];
+  n1 -> n0 [label="~20 rows"];
+}
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Parse(tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.DOT(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDOTQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"scan":          `"scan"`,
+		"scan\nrows: 1": `"scan\nrows: 1"`,
+		`say "hi"`:      `"say \"hi\""`,
+		`C:\path`:       `"C:\\path"`,
+		`trailing \`:    `"trailing \\"`,
+	} {
+		if got := dotQuote(s); got != want {
+			t.Errorf("dotQuote(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package plan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Execution is how a plan was executed, as reported by the "distribution"
+// and "vectorized" fields of its header.
+type Execution struct {
+	// Distribution is "full" if the plan was distributed across nodes with
+	// DistSQL, "partial" if some of it was, and "local" if it ran on the
+	// gateway node alone. It is "" if the header doesn't say.
+	Distribution string
+	// Vectorized is true if the plan ran in the vectorized execution engine,
+	// false if it ran in the row-at-a-time engine, and nil if the header
+	// doesn't say.
+	Vectorized *bool
+}
+
+// Execution returns how the plan was executed.
+func (p *Plan) Execution() Execution {
+	var e Execution
+	e.Distribution = p.Header["distribution"]
+	if v, err := strconv.ParseBool(p.Header["vectorized"]); err == nil {
+		e.Vectorized = &v
+	}
+	return e
+}
+
+// Known returns true if the plan reported its distribution or whether it was
+// vectorized.
+func (e Execution) Known() bool {
+	return e.Distribution != "" || e.Vectorized != nil
+}
+
+// String returns the known fields of e as they appear in the plan's header,
+// such as "distribution: full, vectorized: true".
+func (e Execution) String() string {
+	var parts []string
+	if e.Distribution != "" {
+		parts = append(parts, "distribution: "+e.Distribution)
+	}
+	if e.Vectorized != nil {
+		parts = append(parts, fmt.Sprintf("vectorized: %t", *e.Vectorized))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Describe returns a sentence describing how the plan was executed, such as
+// "The plan was distributed across nodes with DistSQL (distribution: full),
+// and ran in the vectorized execution engine (vectorized: true).", or "" if
+// neither is known.
+func (e Execution) Describe() string {
+	var parts []string
+	switch e.Distribution {
+	case "":
+		parts = append(parts, "it is unknown whether the plan was distributed")
+	case "full":
+		parts = append(parts, "the plan was distributed across nodes with DistSQL (distribution: full)")
+	case "partial":
+		parts = append(parts, "the plan was partially distributed across nodes with DistSQL (distribution: partial)")
+	case "local":
+		parts = append(parts, "the plan ran on the gateway node alone, without being distributed (distribution: local)")
+	default:
+		parts = append(parts, fmt.Sprintf("the plan's distribution was %s", e.Distribution))
+	}
+	switch {
+	case e.Vectorized == nil:
+		parts = append(parts, "it is unknown whether it was vectorized")
+	case *e.Vectorized:
+		parts = append(parts, "it ran in the vectorized execution engine (vectorized: true)")
+	default:
+		parts = append(parts, "it ran in the row-at-a-time execution engine, not the vectorized one (vectorized: false)")
+	}
+	if !e.Known() {
+		return ""
+	}
+	s := strings.Join(parts, ", and ") + "."
+	return strings.ToUpper(s[:1]) + s[1:]
+}
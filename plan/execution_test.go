@@ -0,0 +1,64 @@
+package plan
+
+import "testing"
+
+func TestExecution(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		header   string
+		str      string
+		describe string
+	}{
+		{
+			name:     "distributed and vectorized",
+			header:   "distribution: full\nvectorized: true\n",
+			str:      "distribution: full, vectorized: true",
+			describe: "The plan was distributed across nodes with DistSQL (distribution: full), and it ran in the vectorized execution engine (vectorized: true).",
+		},
+		{
+			name:     "partially distributed and not vectorized",
+			header:   "distribution: partial\nvectorized: false\n",
+			str:      "distribution: partial, vectorized: false",
+			describe: "The plan was partially distributed across nodes with DistSQL (distribution: partial), and it ran in the row-at-a-time execution engine, not the vectorized one (vectorized: false).",
+		},
+		{
+			name:     "local",
+			header:   "distribution: local\n",
+			str:      "distribution: local",
+			describe: "The plan ran on the gateway node alone, without being distributed (distribution: local), and it is unknown whether it was vectorized.",
+		},
+		{
+			name:     "only vectorized",
+			header:   "vectorized: true\n",
+			str:      "vectorized: true",
+			describe: "It is unknown whether the plan was distributed, and it ran in the vectorized execution engine (vectorized: true).",
+		},
+		{
+			name:     "unrecognized values",
+			header:   "distribution: sideways\nvectorized: maybe\n",
+			str:      "distribution: sideways",
+			describe: "The plan's distribution was sideways, and it is unknown whether it was vectorized.",
+		},
+		{
+			name:   "neither",
+			header: "planning time: 1ms\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Parse(tc.header + "\n• scan\n  table: t@t_pkey\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := p.Execution()
+			if got, want := e.Known(), tc.str != ""; got != want {
+				t.Errorf("Known() = %t, want %t", got, want)
+			}
+			if got := e.String(); got != tc.str {
+				t.Errorf("String() = %q, want %q", got, tc.str)
+			}
+			if got := e.Describe(); got != tc.describe {
+				t.Errorf("Describe() = %q, want %q", got, tc.describe)
+			}
+		})
+	}
+}
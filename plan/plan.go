@@ -0,0 +1,291 @@
+// Package plan parses the query plans found in CockroachDB statement bundles.
+package plan
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Plan is a query plan parsed from the output of EXPLAIN or EXPLAIN ANALYZE
+// in a bundle's plan.txt.
+type Plan struct {
+	// Header holds the key-value pairs that precede the operator tree, such
+	// as "planning time" and "distribution".
+	Header map[string]string
+	Root   *Node
+}
+
+// Node is a single operator in a query plan.
+type Node struct {
+	// Name is the name of the operator, such as "scan" or "hash join".
+	Name string
+	// Attrs holds the operator's key-value annotations, such as "table" and
+	// "spans".
+	Attrs map[string]string
+	// EstimatedRows and ActualRows are the estimated and actual number of
+	// rows produced by the operator, or -1 if unknown.
+	EstimatedRows int64
+	ActualRows    int64
+	// ExecTime is the time spent executing the operator, or -1 if unknown.
+	// It is only present in EXPLAIN ANALYZE plans.
+	ExecTime time.Duration
+	// KVTime is the time spent waiting on KV, or -1 if unknown. Scans and
+	// lookups report it instead of ExecTime.
+	KVTime time.Duration
+	// KVBytesRead is the number of bytes read from KV, or -1 if unknown.
+	KVBytesRead int64
+	Children    []*Node
+}
+
+// treeChars are the box-drawing characters and spaces used to draw the
+// operator tree.
+const treeChars = " │├└─"
+
+// operatorBullet marks the start of an operator in the tree.
+const operatorBullet = "•"
+
+// Parse parses the text of plan.txt. The operator tree is reconstructed
+// from the indentation of each operator's bullet. It returns an error if the
+// text contains no operators.
+func Parse(text string) (*Plan, error) {
+	p := &Plan{Header: make(map[string]string)}
+	type frame struct {
+		indent int
+		node   *Node
+	}
+	var stack []frame
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, operatorBullet); i >= 0 && strings.Trim(line[:i], treeChars) == "" {
+			indent := len([]rune(line[:i]))
+			node := &Node{
+				Name:          strings.TrimSpace(line[i+len(operatorBullet):]),
+				Attrs:         make(map[string]string),
+				EstimatedRows: -1,
+				ActualRows:    -1,
+				ExecTime:      -1,
+				KVTime:        -1,
+				KVBytesRead:   -1,
+			}
+			for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+			switch {
+			case len(stack) > 0:
+				parent := stack[len(stack)-1].node
+				parent.Children = append(parent.Children, node)
+			case p.Root == nil:
+				p.Root = node
+			default:
+				// A second root, such as a subquery or postquery, is
+				// attached to the first so the tree stays connected.
+				p.Root.Children = append(p.Root.Children, node)
+			}
+			stack = append(stack, frame{indent: indent, node: node})
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimLeft(line, treeChars), ": ")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if len(stack) == 0 {
+			if p.Root == nil {
+				p.Header[key] = value
+			}
+			continue
+		}
+		node := stack[len(stack)-1].node
+		node.Attrs[key] = value
+		switch key {
+		case "estimated row count":
+			node.EstimatedRows = parseCount(value)
+		case "actual row count":
+			node.ActualRows = parseCount(value)
+		case "execution time":
+			node.ExecTime = parseDuration(value)
+		case "KV time":
+			node.KVTime = parseDuration(value)
+		case "KV bytes read":
+			node.KVBytesRead = parseBytes(value)
+		}
+	}
+	if p.Root == nil {
+		return nil, fmt.Errorf("no operators found in plan")
+	}
+	return p, nil
+}
+
+// Operators returns all operators in the plan in depth-first order.
+func (p *Plan) Operators() []*Node {
+	var ops []*Node
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		ops = append(ops, n)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(p.Root)
+	return ops
+}
+
+// MostExpensive returns up to n operators in the plan, ordered from most to
+// least expensive. Operators are compared by time, then by actual rows, then
+// by estimated rows.
+func (p *Plan) MostExpensive(n int) []*Node {
+	ops := p.Operators()
+	sort.SliceStable(ops, func(i, j int) bool {
+		a, b := ops[i], ops[j]
+		if a.Time() != b.Time() {
+			return a.Time() > b.Time()
+		}
+		if a.ActualRows != b.ActualRows {
+			return a.ActualRows > b.ActualRows
+		}
+		return a.EstimatedRows > b.EstimatedRows
+	})
+	return ops[:min(n, len(ops))]
+}
+
+// Time returns the operator's execution time, falling back to its KV time if
+// the execution time is unknown. It returns -1 if neither is known.
+func (n *Node) Time() time.Duration {
+	if n.ExecTime >= 0 {
+		return n.ExecTime
+	}
+	return n.KVTime
+}
+
+// Label returns the operator's name along with the table or index it reads,
+// if any.
+func (n *Node) Label() string {
+	if table, ok := n.Attrs["table"]; ok {
+		return fmt.Sprintf("%s (%s)", n.Name, table)
+	}
+	return n.Name
+}
+
+// String returns a one-line summary of the operator's cost.
+func (n *Node) String() string {
+	parts := []string{n.Label()}
+	if n.ExecTime >= 0 {
+		parts = append(parts, "time "+n.ExecTime.String())
+	} else if n.KVTime >= 0 {
+		parts = append(parts, "KV time "+n.KVTime.String())
+	}
+	if n.ActualRows >= 0 {
+		parts = append(parts, fmt.Sprintf("actual rows %d", n.ActualRows))
+	}
+	if n.EstimatedRows >= 0 {
+		parts = append(parts, fmt.Sprintf("estimated rows %d", n.EstimatedRows))
+	}
+	if n.KVBytesRead >= 0 {
+		parts = append(parts, "KV bytes read "+n.Attrs["KV bytes read"])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SummarizeOperators returns a numbered list of the n most expensive
+// operators in the plan.
+func (p *Plan) SummarizeOperators(n int) string {
+	var buf strings.Builder
+	for i, op := range p.MostExpensive(n) {
+		fmt.Fprintf(&buf, "%d. %s\n", i+1, op)
+	}
+	return buf.String()
+}
+
+// CostTable returns an aligned table of every operator in the plan, ordered
+// from most to least expensive, with its estimated and actual rows and its
+// time. Values that are unknown, such as the actual rows of a plan from
+// EXPLAIN without ANALYZE, are shown as dashes.
+func (p *Plan) CostTable() string {
+	ops := p.MostExpensive(math.MaxInt)
+	rows := [][4]string{{"OPERATOR", "EST. ROWS", "ACTUAL ROWS", "TIME"}}
+	for _, op := range ops {
+		rows = append(rows, [4]string{op.Label(), countOrDash(op.EstimatedRows), countOrDash(op.ActualRows), durationOrDash(op.Time())})
+	}
+	var widths [4]int
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	// The operator is aligned left and the numbers right.
+	var buf strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&buf, "%-*s  %*s  %*s  %*s\n", widths[0], row[0], widths[1], row[1], widths[2], row[2], widths[3], row[3])
+	}
+	return buf.String()
+}
+
+// countOrDash formats a row count, or returns "-" if it is unknown.
+func countOrDash(n int64) string {
+	if n < 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// durationOrDash formats a duration, or returns "-" if it is unknown.
+func durationOrDash(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.String()
+}
+
+// parseCount parses a row count such as "1,234" or "9 (missing stats)",
+// returning -1 if it is invalid.
+func parseCount(value string) int64 {
+	value, _, _ = strings.Cut(value, " ")
+	n, err := strconv.ParseInt(strings.ReplaceAll(value, ",", ""), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// parseDuration parses a duration such as "9µs", returning -1 if it is
+// invalid.
+func parseDuration(value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return -1
+	}
+	return d
+}
+
+// byteUnits are the units used by CockroachDB to format byte counts.
+var byteUnits = map[string]float64{
+	"B":   1,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+	"PiB": 1 << 50,
+}
+
+// parseBytes parses a byte count such as "20 KiB", returning -1 if it is
+// invalid.
+func parseBytes(value string) int64 {
+	num, unit, ok := strings.Cut(value, " ")
+	if !ok {
+		return -1
+	}
+	mult, ok := byteUnits[unit]
+	if !ok {
+		return -1
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return -1
+	}
+	return int64(f * mult)
+}
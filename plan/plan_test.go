@@ -0,0 +1,328 @@
+package plan
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// analyzePlan is the plan.txt of a bundle of a join, from EXPLAIN ANALYZE
+// (VERBOSE).
+const analyzePlan = `planning time: 417µs
+execution time: 5ms
+distribution: full
+vectorized: true
+rows decoded from KV: 2,000 (156 KiB, 2 gRPC calls)
+cumulative time spent in KV: 3ms
+maximum memory usage: 190 KiB
+network usage: 0 B (0 messages)
+
+• hash join
+│ columns: (id, customer_id, id, name)
+│ nodes: n1
+│ actual row count: 1,000
+│ execution time: 2ms
+│ estimated max memory allocated: 130 KiB
+│ estimated row count: 1,000
+│ equality: (customer_id) = (id)
+│ right cols are key
+│
+├── • scan
+│     columns: (id, customer_id)
+│     nodes: n1
+│     actual row count: 1,000
+│     KV time: 1ms
+│     KV contention time: 0µs
+│     KV rows decoded: 1,000
+│     KV bytes read: 78 KiB
+│     estimated row count: 1,000 (100% of the table; stats collected 2 days ago)
+│     table: orders@orders_pkey
+│     spans: FULL SCAN
+│
+└── • scan
+      columns: (id, name)
+      nodes: n1
+      actual row count: 1,000
+      KV time: 2ms
+      KV rows decoded: 1,000
+      KV bytes read: 1.5 MiB
+      estimated row count: 1,000 (100% of the table; stats collected 2 days ago)
+      table: customers@customers_pkey
+      spans: FULL SCAN
+`
+
+// explainPlan is the plan.txt of a bundle from EXPLAIN without ANALYZE, which
+// has estimates but no actual rows or times. The scan has no estimate, since
+// its table has no statistics.
+const explainPlan = `distribution: local
+vectorized: true
+
+• sort
+│ estimated row count: 333
+│ order: +name
+│
+└── • filter
+    │ filter: name > 'a'
+    │
+    └── • scan
+          missing stats
+          table: users@users_pkey
+          spans: FULL SCAN
+`
+
+// describeTree returns the operators of the tree rooted at n, one per line
+// indented by depth, with the costs parsed for each.
+func describeTree(n *Node) string {
+	var buf strings.Builder
+	var walk func(n *Node, depth int)
+	walk = func(n *Node, depth int) {
+		fmt.Fprintf(&buf, "%s%s est=%s actual=%s time=%s kv=%s bytes=%s\n", strings.Repeat("  ", depth), n.Label(),
+			countOrDash(n.EstimatedRows), countOrDash(n.ActualRows), durationOrDash(n.ExecTime), durationOrDash(n.KVTime),
+			countOrDash(n.KVBytesRead))
+		for _, c := range n.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(n, 0)
+	return buf.String()
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		text   string
+		header map[string]string
+		tree   string
+		err    string
+	}{
+		{
+			name: "explain analyze",
+			text: analyzePlan,
+			header: map[string]string{
+				"planning time":        "417µs",
+				"distribution":         "full",
+				"vectorized":           "true",
+				"rows decoded from KV": "2,000 (156 KiB, 2 gRPC calls)",
+			},
+			tree: `hash join est=1000 actual=1000 time=2ms kv=- bytes=-
+  scan (orders@orders_pkey) est=1000 actual=1000 time=- kv=1ms bytes=79872
+  scan (customers@customers_pkey) est=1000 actual=1000 time=- kv=2ms bytes=1572864
+`,
+		},
+		{
+			name:   "explain without estimates",
+			text:   explainPlan,
+			header: map[string]string{"distribution": "local", "vectorized": "true"},
+			tree: `sort est=333 actual=- time=- kv=- bytes=-
+  filter est=- actual=- time=- kv=- bytes=-
+    scan (users@users_pkey) est=- actual=- time=- kv=- bytes=-
+`,
+		},
+		{
+			name: "deeply nested",
+			text: `• top-k
+│ estimated row count: 9 (missing stats)
+│ k: 25
+│
+└── • filter
+    │ estimated row count: 9 (missing stats)
+    │
+    └── • index join (streamer)
+        │ actual row count: 0
+        │ KV time: 7µs
+        │ KV bytes read: 0 B
+        │ table: users@users_pkey
+        │
+        └── • scan
+              actual row count: 0
+              KV time: 1ms
+              table: users@users_last_name_idx
+`,
+			header: map[string]string{},
+			tree: `top-k est=9 actual=- time=- kv=- bytes=-
+  filter est=9 actual=- time=- kv=- bytes=-
+    index join (streamer) (users@users_pkey) est=- actual=0 time=- kv=7µs bytes=0
+      scan (users@users_last_name_idx) est=- actual=0 time=- kv=1ms bytes=-
+`,
+		},
+		{
+			// The subquery is a sibling of the main query's operators, and
+			// an operator that follows a deeper one returns to its depth.
+			name: "siblings after nested children",
+			text: `• root
+│
+├── • render
+│   │
+│   └── • scan
+│         table: a@a_pkey
+│
+└── • subquery
+    │ id: @S1
+    │
+    └── • scan
+          table: b@b_pkey
+`,
+			header: map[string]string{},
+			tree: `root est=- actual=- time=- kv=- bytes=-
+  render est=- actual=- time=- kv=- bytes=-
+    scan (a@a_pkey) est=- actual=- time=- kv=- bytes=-
+  subquery est=- actual=- time=- kv=- bytes=-
+    scan (b@b_pkey) est=- actual=- time=- kv=- bytes=-
+`,
+		},
+		{
+			name: "several roots",
+			text: `• scan
+  table: a@a_pkey
+
+• scan
+  table: b@b_pkey
+`,
+			header: map[string]string{},
+			tree: `scan (a@a_pkey) est=- actual=- time=- kv=- bytes=-
+  scan (b@b_pkey) est=- actual=- time=- kv=- bytes=-
+`,
+		},
+		{
+			// A bullet in a value isn't an operator, and lines without a
+			// key, or with values that can't be parsed, are skipped.
+			name: "malformed lines",
+			text: `planning time: 1ms
+not a header line
+
+• filter
+│ filter: name = '• not an operator'
+│ estimated row count: lots
+│ actual row count: -
+│ execution time: soon
+│ ???
+│
+└── • scan
+      KV bytes read: 12 parsecs
+      KV time: 3
+      table: users@users_pkey
+`,
+			header: map[string]string{"planning time": "1ms"},
+			tree: `filter est=- actual=- time=- kv=- bytes=-
+  scan (users@users_pkey) est=- actual=- time=- kv=- bytes=-
+`,
+		},
+		{
+			name: "empty",
+			text: "",
+			err:  "no operators found in plan",
+		},
+		{
+			name: "header only",
+			text: "planning time: 1ms\nexecution time: 2ms\n",
+			err:  "no operators found in plan",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Parse(tc.text)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tc.header {
+				if got := p.Header[key]; got != want {
+					t.Errorf("header %q = %q, want %q", key, got, want)
+				}
+			}
+			if len(tc.header) == 0 && len(p.Header) != 0 {
+				t.Errorf("got header %v, want none", p.Header)
+			}
+			if got := describeTree(p.Root); got != tc.tree {
+				t.Errorf("got tree:\n%s\nwant:\n%s", got, tc.tree)
+			}
+		})
+	}
+}
+
+func TestParseAttrs(t *testing.T) {
+	p, err := Parse(analyzePlan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := p.Root.Children[0]
+	for key, want := range map[string]string{
+		"table":               "orders@orders_pkey",
+		"spans":               "FULL SCAN",
+		"estimated row count": "1,000 (100% of the table; stats collected 2 days ago)",
+		"KV bytes read":       "78 KiB",
+	} {
+		if got := scan.Attrs[key]; got != want {
+			t.Errorf("attribute %q = %q, want %q", key, got, want)
+		}
+	}
+	if _, ok := p.Root.Attrs["table"]; ok {
+		t.Errorf("attributes of a child were given to its parent: %v", p.Root.Attrs)
+	}
+}
+
+func TestMostExpensive(t *testing.T) {
+	p, err := Parse(analyzePlan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels []string
+	for _, op := range p.MostExpensive(2) {
+		labels = append(labels, op.Label())
+	}
+	// The scans report KV time instead of execution time.
+	if got, want := strings.Join(labels, ", "), "hash join, scan (customers@customers_pkey)"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	want := `1. hash join, time 2ms, actual rows 1000, estimated rows 1000
+2. scan (customers@customers_pkey), KV time 2ms, actual rows 1000, estimated rows 1000, KV bytes read 1.5 MiB
+3. scan (orders@orders_pkey), KV time 1ms, actual rows 1000, estimated rows 1000, KV bytes read 78 KiB
+`
+	if got := p.SummarizeOperators(10); got != want {
+		t.Errorf("got summary:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCostTable(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "explain analyze",
+			text: analyzePlan,
+			want: `OPERATOR                         EST. ROWS  ACTUAL ROWS  TIME
+hash join                             1000         1000   2ms
+scan (customers@customers_pkey)       1000         1000   2ms
+scan (orders@orders_pkey)             1000         1000   1ms
+`,
+		},
+		{
+			// The actual rows and times of EXPLAIN without ANALYZE, and
+			// the missing estimate of the scan, are dashes.
+			name: "explain",
+			text: explainPlan,
+			want: `OPERATOR                 EST. ROWS  ACTUAL ROWS  TIME
+sort                           333            -     -
+filter                           -            -     -
+scan (users@users_pkey)          -            -     -
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := Parse(tc.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.CostTable(); got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// Stages of analyzing a bundle whose wall-clock time is recorded by -profile.
+const (
+	// stageRead reads the bundle from a file, URL, directory, or stdin.
+	stageRead = "read"
+	// stageExtract unzips the bundle and checks its files.
+	stageExtract = "extract"
+	// stageParse parses the plan, schema, and statistics before the
+	// prompt is built.
+	stageParse  = "parse"
+	stagePrompt = "prompt"
+	// stageWait waits for -rpm or the API's rate limit.
+	stageWait = "wait"
+	// stageAPI waits for the model's replies, including the follow-up
+	// requests for a correction, the best candidate, or a summary.
+	stageAPI = "api"
+)
+
+// profileStages are the stages in the order they are printed.
+var profileStages = [...]string{stageRead, stageExtract, stageParse, stagePrompt, stageWait, stageAPI}
+
+// profile is the wall-clock time spent in each stage of analyzing one or more
+// bundles.
+type profile struct {
+	mu     sync.Mutex
+	stages map[string]time.Duration
+	// total is the time from starting to finishing each bundle, which
+	// includes the time spent outside of the stages, such as formatting
+	// the analysis.
+	total time.Duration
+}
+
+type profileKey struct{}
+
+// profileFrom returns the profile of the context, or nil if it has none.
+func profileFrom(ctx context.Context) *profile {
+	p, _ := ctx.Value(profileKey{}).(*profile)
+	return p
+}
+
+// timeStage starts timing the stage for the context's profile and returns the
+// function that stops it, which is a no-op if the context has no profile:
+//
+//	defer timeStage(ctx, stageRead)()
+func timeStage(ctx context.Context, stage string) func() {
+	p := profileFrom(ctx)
+	if p == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { p.add(stage, time.Since(start)) }
+}
+
+// add adds d to the time spent in the stage.
+func (p *profile) add(stage string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stages == nil {
+		p.stages = make(map[string]time.Duration)
+	}
+	p.stages[stage] += d
+}
+
+// addProfile adds the times of other, the profile of a single bundle, to p.
+func (p *profile) addProfile(other *profile) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	for stage, d := range other.stages {
+		p.add(stage, d)
+	}
+	p.mu.Lock()
+	p.total += other.total
+	p.mu.Unlock()
+}
+
+// String returns the time spent in each stage that was reached and in total,
+// such as "read 2ms, extract 5ms, parse 1ms, prompt 310µs, api 4.21s, total
+// 4.22s".
+func (p *profile) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var parts []string
+	for _, stage := range profileStages {
+		if d, ok := p.stages[stage]; ok {
+			parts = append(parts, stage+" "+roundDuration(d).String())
+		}
+	}
+	parts = append(parts, "total "+roundDuration(p.total).String())
+	return strings.Join(parts, ", ")
+}
+
+// roundDuration rounds d to three significant digits or so, which is plenty to
+// tell where the time went.
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// withProfile runs the analysis of a bundle and, if enabled, records the time
+// spent in each of its stages in the result's profile.
+func withProfile(ctx context.Context, enabled bool, run func(context.Context) (bundleResult, error)) (bundleResult, error) {
+	if !enabled {
+		return run(ctx)
+	}
+	p := &profile{}
+	start := time.Now()
+	res, err := run(context.WithValue(ctx, profileKey{}, p))
+	p.total = time.Since(start)
+	res.profile = p
+	return res, err
+}
+
+// profileAnalyzer is an analyze.Analyzer that records the time spent waiting
+// for the model in the profile of the request's context.
+type profileAnalyzer struct {
+	analyze.Analyzer
+}
+
+// Analyze implements the analyze.Analyzer interface.
+func (a profileAnalyzer) Analyze(ctx context.Context, prompt string) (*analyze.Completion, error) {
+	defer timeStage(ctx, stageAPI)()
+	return a.Analyzer.Analyze(ctx, prompt)
+}
+
+// Chat implements the analyze.Analyzer interface.
+func (a profileAnalyzer) Chat(ctx context.Context, messages []analyze.Message) (*analyze.Completion, error) {
+	defer timeStage(ctx, stageAPI)()
+	return a.Analyzer.Chat(ctx, messages)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket that paces the requests made to the API by
+// all of the bundles being analyzed. A nil *rateLimiter does not limit
+// requests, and the zero value only holds them while paused.
+type rateLimiter struct {
+	mu sync.Mutex
+	// interval is the time it takes to add a token to the bucket, or 0 if
+	// requests are not paced.
+	interval time.Duration
+	burst    float64
+	// tokens is the number of tokens in the bucket as of last. It is
+	// negative when requests are waiting for tokens that have been reserved
+	// but not yet added.
+	tokens float64
+	last   time.Time
+	// resume is the time until which requests are paused, such as when the
+	// API reports that its own rate limit is nearly exhausted.
+	resume time.Time
+}
+
+// newRateLimiter returns a rateLimiter that allows perMinute requests per
+// minute, with bursts of up to burst requests.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until a request may be made, returning the time spent waiting,
+// or until ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	delay := time.Duration(0)
+	if l.interval > 0 {
+		l.tokens = min(l.burst, l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+		l.last = now
+		// Reserve a token, waiting for it to be added if the bucket is
+		// empty.
+		l.tokens--
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens * float64(l.interval))
+		}
+	}
+	delay = max(delay, l.resume.Sub(now))
+	l.mu.Unlock()
+	if delay == 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		// Return the reserved token so that it isn't wasted.
+		l.mu.Lock()
+		if l.interval > 0 {
+			l.tokens++
+		}
+		l.mu.Unlock()
+		return 0, ctx.Err()
+	}
+}
+
+// pauseUntil holds the requests that wait for l until t, unless they are
+// already held until later.
+func (l *rateLimiter) pauseUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.resume) {
+		l.resume = t
+	}
+}
@@ -0,0 +1,216 @@
+package schema
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Index is an index of a table, defined in its CREATE TABLE statement or by a
+// CREATE INDEX statement.
+type Index struct {
+	// Name is the index's name, or "" if it wasn't named.
+	Name string
+	// Columns are the indexed columns, with " DESC" appended to those in
+	// descending order.
+	Columns []string
+	// Storing are the columns stored in the index with STORING or INCLUDE.
+	Storing []string
+	Primary bool
+	Unique  bool
+	// Special is true for an inverted, vector, hash-sharded, partial, or
+	// expression index, whose entries aren't simply ordered by its columns.
+	Special bool
+}
+
+// String returns the index's name, or what kind of index it is if it has no
+// name, and its columns, such as "users_last_name_idx (last_name, created_at
+// DESC)" or "unique constraint (email)".
+func (i *Index) String() string {
+	name := i.Name
+	switch {
+	case name != "":
+	case i.Primary:
+		name = "primary key"
+	case i.Unique:
+		name = "unique constraint"
+	default:
+		name = "unnamed index"
+	}
+	return name + " (" + strings.Join(i.Columns, ", ") + ")"
+}
+
+// indexDefRE matches the beginning of an index or a PRIMARY KEY or UNIQUE
+// constraint in a CREATE TABLE statement, up to the opening parenthesis of its
+// columns. It captures the constraint's name, the kind of index, the index's
+// name, and its USING method.
+var indexDefRE = regexp.MustCompile(`(?is)^\s*(?:CONSTRAINT\s+([\w"]+)\s+)?(PRIMARY\s+KEY|UNIQUE(?:\s+INDEX)?|INVERTED\s+INDEX|VECTOR\s+INDEX|INDEX)(?:\s+(?:IF\s+NOT\s+EXISTS\s+)?([\w"]+))??(?:\s+USING\s+(\w+))?\s*\(`)
+
+// createIndexDefRE matches the beginning of a CREATE INDEX statement, up to
+// the opening parenthesis of its columns. It captures the kind of index, the
+// index's name, the table's name, and its USING method.
+var createIndexDefRE = regexp.MustCompile(`(?is)^CREATE\s+(UNIQUE\s+|INVERTED\s+|VECTOR\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(?:([\w"]+)\s+)?ON\s+([\w."]+)\s*(?:USING\s+(\w+)\s*)?\(`)
+
+// Clauses that follow the columns of an index.
+var (
+	usingHashRE = regexp.MustCompile(`(?i)^\s*USING\s+HASH\b`)
+	storingRE   = regexp.MustCompile(`(?i)\b(?:STORING|INCLUDE|COVERING)\s*\(`)
+	whereRE     = regexp.MustCompile(`(?i)\bWHERE\b`)
+	// columnPrimaryKeyRE and columnUniqueRE match the constraints of a
+	// column definition that index the column.
+	columnPrimaryKeyRE = regexp.MustCompile(`(?i)\bPRIMARY\s+KEY\b`)
+	columnUniqueRE     = regexp.MustCompile(`(?i)\bUNIQUE\b`)
+)
+
+// parseIndexDef returns the index defined by elem, an element of a CREATE
+// TABLE statement, or nil if it doesn't define one.
+func parseIndexDef(elem string) *Index {
+	m := indexDefRE.FindStringSubmatchIndex(elem)
+	if m == nil {
+		return nil
+	}
+	group := func(n int) string {
+		if m[2*n] < 0 {
+			return ""
+		}
+		return elem[m[2*n]:m[2*n+1]]
+	}
+	kind := strings.ToUpper(strings.Join(strings.Fields(group(2)), " "))
+	name := group(1)
+	if name == "" {
+		name = group(3)
+	}
+	idx := &Index{
+		Name:    unquote(name),
+		Primary: kind == "PRIMARY KEY",
+		Unique:  strings.HasPrefix(kind, "UNIQUE"),
+		Special: kind == "INVERTED INDEX" || kind == "VECTOR INDEX" || isSpecialMethod(group(4)),
+	}
+	idx.parseColumns(elem, m[1]-1)
+	return idx
+}
+
+// parseCreateIndex returns the index defined by the CREATE INDEX statement
+// stmt and the name of its table, or nil if stmt isn't one.
+func parseCreateIndex(stmt string) (*Index, string) {
+	m := createIndexDefRE.FindStringSubmatchIndex(stmt)
+	if m == nil {
+		return nil, ""
+	}
+	group := func(n int) string {
+		if m[2*n] < 0 {
+			return ""
+		}
+		return stmt[m[2*n]:m[2*n+1]]
+	}
+	kind := strings.ToUpper(strings.TrimSpace(group(1)))
+	idx := &Index{
+		Name:    unquote(group(2)),
+		Unique:  kind == "UNIQUE",
+		Special: kind == "INVERTED" || kind == "VECTOR" || isSpecialMethod(group(4)),
+	}
+	idx.parseColumns(stmt, m[1]-1)
+	return idx, unquote(group(3))
+}
+
+// isSpecialMethod returns true if the USING method of an index, such as gin
+// or hnsw, makes it something other than an ordinary ordered index.
+func isSpecialMethod(method string) bool {
+	return method != "" && !strings.EqualFold(method, "btree")
+}
+
+// parseColumns parses the columns of the index from the parenthesis at
+// def[open], and the clauses that follow them.
+func (i *Index) parseColumns(def string, open int) {
+	cols := enclosed(def, open)
+	for _, col := range splitTopLevel(cols) {
+		fields := strings.Fields(col)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.ContainsAny(col, "()") {
+			// An expression, such as lower(email).
+			i.Special = true
+		}
+		name := unquote(fields[0])
+		if len(fields) > 1 && strings.EqualFold(fields[1], "DESC") {
+			name += " DESC"
+		}
+		i.Columns = append(i.Columns, name)
+	}
+	rest := def[min(open+len(cols)+2, len(def)):]
+	if usingHashRE.MatchString(rest) || whereRE.MatchString(rest) {
+		i.Special = true
+	}
+	if loc := storingRE.FindStringIndex(rest); loc != nil {
+		for _, col := range splitTopLevel(enclosed(rest, loc[1]-1)) {
+			if fields := strings.Fields(col); len(fields) > 0 {
+				i.Storing = append(i.Storing, unquote(fields[0]))
+			}
+		}
+	}
+}
+
+// coveredBy returns true if every lookup and scan that i serves can be served
+// by other, so that i is redundant with it: i's columns are a prefix of other's,
+// other stores or indexes every column that i stores, and if i is unique,
+// other is unique on the same columns.
+func (i *Index) coveredBy(other *Index) bool {
+	if i.Special || other.Special || i.Primary || len(i.Columns) > len(other.Columns) {
+		return false
+	}
+	for n, col := range i.Columns {
+		if !strings.EqualFold(col, other.Columns[n]) {
+			return false
+		}
+	}
+	if i.Unique && (!(other.Unique || other.Primary) || len(i.Columns) != len(other.Columns)) {
+		return false
+	}
+	if other.Primary {
+		// The primary index stores every column.
+		return true
+	}
+	for _, col := range i.Storing {
+		if !slices.ContainsFunc(other.Columns, func(c string) bool { return strings.EqualFold(strings.TrimSuffix(c, " DESC"), col) }) &&
+			!slices.ContainsFunc(other.Storing, func(c string) bool { return strings.EqualFold(c, col) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// RedundantIndex is an index that another index of the same table makes
+// redundant.
+type RedundantIndex struct {
+	Table string
+	Index *Index
+	// CoveredBy is the index whose leading columns cover Index.
+	CoveredBy *Index
+}
+
+// RedundantIndexes returns the indexes in s whose columns are a prefix of the
+// columns of another index of the same table, in the order the tables and
+// indexes are defined. Of two identical indexes, only the one defined last is
+// returned. Inverted, vector, hash-sharded, partial, and expression indexes
+// are never compared, and a unique index is only redundant with an index that
+// is unique on the same columns.
+func (s *Schema) RedundantIndexes() []RedundantIndex {
+	var redundant []RedundantIndex
+	for _, t := range s.Tables {
+		for n, idx := range t.Indexes {
+			for m, other := range t.Indexes {
+				if m == n || !idx.coveredBy(other) {
+					continue
+				}
+				if other.coveredBy(idx) && m > n {
+					// Report the later of two identical indexes.
+					continue
+				}
+				redundant = append(redundant, RedundantIndex{Table: t.Name, Index: idx, CoveredBy: other})
+				break
+			}
+		}
+	}
+	return redundant
+}
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRedundantIndexes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ddl  string
+		// want are the redundant indexes, as "index (columns) < covering
+		// index (columns)".
+		want []string
+	}{
+		{
+			name: "prefix of another index",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, last_name STRING, created_at TIMESTAMP,
+				INDEX users_last_name_idx (last_name),
+				INDEX users_last_name_created_idx (last_name, created_at DESC));`,
+			want: []string{"users_last_name_idx (last_name) < users_last_name_created_idx (last_name, created_at DESC)"},
+		},
+		{
+			name: "not a prefix",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, a INT, b INT,
+				INDEX users_b_idx (b),
+				INDEX users_a_b_idx (a, b));`,
+		},
+		{
+			name: "covered by the primary key",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, email STRING);
+				CREATE INDEX users_id_idx ON users (id) STORING (email);`,
+			want: []string{"users_id_idx (id) < primary key (id)"},
+		},
+		{
+			name: "duplicate CREATE INDEX reports the later one",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, email STRING);
+				CREATE INDEX users_email_idx ON users (email);
+				CREATE INDEX IF NOT EXISTS users_email_idx2 ON public.users (email ASC);`,
+			want: []string{"users_email_idx2 (email) < users_email_idx (email)"},
+		},
+		{
+			name: "direction differs",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, a INT, b INT);
+				CREATE INDEX users_a_b_idx ON users (a, b);
+				CREATE INDEX users_a_b_desc_idx ON users (a, b DESC);`,
+		},
+		{
+			name: "stored columns covered",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, a INT, b INT, c INT,
+				INDEX users_a_idx (a) STORING (c),
+				INDEX users_a_b_idx (a, b) STORING (c));`,
+			want: []string{"users_a_idx (a) < users_a_b_idx (a, b)"},
+		},
+		{
+			name: "stored column in the other index's columns",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, a INT, b INT,
+				INDEX users_a_idx (a) INCLUDE (b),
+				INDEX users_a_b_idx (a, b));`,
+			want: []string{"users_a_idx (a) < users_a_b_idx (a, b)"},
+		},
+		{
+			name: "stored column not covered",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, a INT, b INT, c INT,
+				INDEX users_a_idx (a) STORING (c),
+				INDEX users_a_b_idx (a, b));`,
+		},
+		{
+			name: "unique index on a prefix",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, a INT, b INT,
+				UNIQUE INDEX users_a_key (a),
+				INDEX users_a_b_idx (a, b));`,
+		},
+		{
+			name: "duplicate unique constraints",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, email STRING UNIQUE,
+				CONSTRAINT users_email_key UNIQUE (email));`,
+			want: []string{"users_email_key (email) < unique constraint (email)"},
+		},
+		{
+			name: "non-unique index covered by a unique one",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, email STRING,
+				UNIQUE INDEX users_email_key (email),
+				INDEX users_email_idx (email));`,
+			want: []string{"users_email_idx (email) < users_email_key (email)"},
+		},
+		{
+			name: "special indexes are not compared",
+			ddl: `CREATE TABLE users (id INT PRIMARY KEY, a INT, tags STRING[], email STRING,
+				INDEX users_a_idx (a),
+				INDEX users_a_partial_idx (a) WHERE a > 0,
+				INDEX users_a_hash_idx (a) USING HASH,
+				INVERTED INDEX users_tags_idx (tags));
+				CREATE INDEX users_lower_email_idx ON users (lower(email));
+				CREATE INDEX users_email_idx ON users (email);
+				CREATE INDEX users_tags_gin_idx ON users USING GIN (tags);`,
+		},
+		{
+			name: "indexes of different tables",
+			ddl: `CREATE TABLE a (id INT PRIMARY KEY, x INT, INDEX a_x_idx (x));
+				CREATE TABLE b (id INT PRIMARY KEY, x INT, INDEX b_x_idx (x));`,
+		},
+	} {
+		var got []string
+		for _, r := range Parse(tc.ddl).RedundantIndexes() {
+			got = append(got, r.Index.String()+" < "+r.CoveredBy.String())
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: RedundantIndexes() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
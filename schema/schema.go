@@ -0,0 +1,318 @@
+// Package schema parses the CREATE TABLE statements in a statement bundle's
+// schema.sql.
+package schema
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Schema is the set of tables defined in a bundle's schema.sql.
+type Schema struct {
+	Tables []*Table
+}
+
+// Table is a table defined by a CREATE TABLE statement.
+type Table struct {
+	// Name is the table's name as written in the statement, such as
+	// "public.users".
+	Name    string
+	Columns []string
+	// References are the names of the tables that the table's foreign keys
+	// reference, as written in the statements.
+	References []string
+	// Indexes are the table's indexes, including its primary key and unique
+	// constraints, in the order they are defined.
+	Indexes []*Index
+}
+
+// constraintKeywords are the words that begin a table element that is not a
+// column definition.
+var constraintKeywords = map[string]bool{
+	"CONSTRAINT": true,
+	"PRIMARY":    true,
+	"UNIQUE":     true,
+	"INDEX":      true,
+	"INVERTED":   true,
+	"VECTOR":     true,
+	"FAMILY":     true,
+	"CHECK":      true,
+	"FOREIGN":    true,
+}
+
+// createTableRE matches the beginning of a CREATE TABLE statement, up to the
+// opening parenthesis of its table elements.
+var createTableRE = regexp.MustCompile(`(?i)\bCREATE\s+TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([\w."]+)\s*\(`)
+
+// referencesRE matches the REFERENCES clause of a foreign key, capturing the
+// name of the referenced table.
+var referencesRE = regexp.MustCompile(`(?i)\bREFERENCES\s+([\w."]+)`)
+
+// Parse parses the CREATE TABLE statements in sql, the foreign keys added to
+// the tables by ALTER TABLE statements, and the indexes added to them by
+// CREATE INDEX statements. Other statements, such as USE and CREATE TYPE, are
+// ignored.
+func Parse(sql string) *Schema {
+	s := &Schema{}
+	for _, m := range createTableRE.FindAllStringSubmatchIndex(sql, -1) {
+		body := enclosed(sql, m[1]-1)
+		t := &Table{Name: unquote(sql[m[2]:m[3]])}
+		for _, elem := range splitTopLevel(body) {
+			fields := strings.Fields(elem)
+			if len(fields) == 0 {
+				continue
+			}
+			if constraintKeywords[strings.ToUpper(fields[0])] {
+				if idx := parseIndexDef(elem); idx != nil {
+					t.Indexes = append(t.Indexes, idx)
+				}
+				continue
+			}
+			col := unquote(fields[0])
+			t.Columns = append(t.Columns, col)
+			if columnPrimaryKeyRE.MatchString(elem) {
+				t.Indexes = append(t.Indexes, &Index{Columns: []string{col}, Primary: true})
+			} else if columnUniqueRE.MatchString(elem) {
+				t.Indexes = append(t.Indexes, &Index{Columns: []string{col}, Unique: true})
+			}
+		}
+		t.addReferences(body)
+		s.Tables = append(s.Tables, t)
+	}
+	for _, stmt := range splitStatements(sql) {
+		stmt = withoutLeadingComments(stmt)
+		if m := alterTableRE.FindStringSubmatch(stmt); m != nil {
+			if t := s.Table(m[1]); t != nil {
+				t.addReferences(stmt)
+			}
+		} else if idx, table := parseCreateIndex(stmt); idx != nil {
+			if t := s.Table(table); t != nil {
+				t.Indexes = append(t.Indexes, idx)
+			}
+		}
+	}
+	return s
+}
+
+// addReferences adds the tables referenced by the foreign keys in sql to
+// t.References.
+func (t *Table) addReferences(sql string) {
+	for _, m := range referencesRE.FindAllStringSubmatch(sql, -1) {
+		if name := unquote(m[1]); !slices.Contains(t.References, name) {
+			t.References = append(t.References, name)
+		}
+	}
+}
+
+// identifierRE matches a possibly qualified identifier, or a string literal so
+// that the words in it can be skipped.
+var identifierRE = regexp.MustCompile(`'(?:[^']|'')*'|(?:[A-Za-z_][\w$]*|"[^"]*")(?:\.(?:[A-Za-z_][\w$]*|"[^"]*"))*`)
+
+// RelevantTables returns the names of the tables in s that are referenced in
+// the statement sql, followed by the tables that their foreign keys
+// reference, in the order the tables are defined. A table is referenced if
+// any identifier in the statement matches its name as in Schema.Table, which
+// may include a table whose name is also used as a column name.
+func (s *Schema) RelevantTables(sql string) []string {
+	relevant := make(map[*Table]bool)
+	for _, ident := range identifierRE.FindAllString(sql, -1) {
+		if strings.HasPrefix(ident, "'") {
+			continue
+		}
+		if t := s.Table(ident); t != nil {
+			relevant[t] = true
+		}
+	}
+	// Follow the foreign keys one level deep, since the model may need to
+	// know about a referenced table to judge a join or a cascade, but not
+	// about the tables beyond it.
+	var referenced []*Table
+	for t := range relevant {
+		for _, name := range t.References {
+			if ref := s.Table(name); ref != nil {
+				referenced = append(referenced, ref)
+			}
+		}
+	}
+	for _, t := range referenced {
+		relevant[t] = true
+	}
+
+	var names []string
+	for _, t := range s.Tables {
+		if relevant[t] {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+// Table returns the table with the given name, or nil if there is none. The
+// name may be qualified or not; only the last component of the name is
+// compared, ignoring case.
+func (s *Schema) Table(name string) *Table {
+	want := baseName(unquote(name))
+	for _, t := range s.Tables {
+		if strings.EqualFold(baseName(t.Name), want) {
+			return t
+		}
+	}
+	return nil
+}
+
+// HasColumn returns true if the table has the named column, ignoring case.
+func (t *Table) HasColumn(name string) bool {
+	name = unquote(name)
+	for _, c := range t.Columns {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseName returns the last component of a qualified name.
+func baseName(name string) string {
+	return name[strings.LastIndexByte(name, '.')+1:]
+}
+
+// unquote removes the double quotes from a possibly qualified identifier.
+func unquote(name string) string {
+	return strings.ReplaceAll(name, `"`, "")
+}
+
+// enclosed returns the text between the parenthesis at s[open] and its
+// matching closing parenthesis. Parentheses in quoted strings are ignored.
+func enclosed(s string, open int) string {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s[open+1 : i]
+			}
+		case '\'', '"':
+			if end := strings.IndexByte(s[i+1:], s[i]); end >= 0 {
+				i += end + 1
+			}
+		}
+	}
+	return s[open+1:]
+}
+
+// splitTopLevel splits s on the commas that are not nested in parentheses or
+// quoted strings.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		case '\'', '"':
+			if end := strings.IndexByte(s[i+1:], s[i]); end >= 0 {
+				i += end + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// alterTableRE matches the beginning of an ALTER TABLE statement, capturing
+// the table's name.
+var alterTableRE = regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?([\w."]+)`)
+
+// createIndexRE matches the beginning of a CREATE INDEX statement, capturing
+// the indexed table's name.
+var createIndexRE = regexp.MustCompile(`(?i)^CREATE\s+(?:UNIQUE\s+|INVERTED\s+|VECTOR\s+)?INDEX\b[^;]*?\bON\s+([\w."]+)`)
+
+// Filter returns the statements in sql with the CREATE TABLE, ALTER TABLE, and
+// CREATE INDEX statements of tables other than the named ones removed, and the
+// names of the tables whose CREATE TABLE statements were not found. Other
+// statements, such as USE and CREATE TYPE, are kept. Names are compared as in
+// Schema.Table.
+func Filter(sql string, names []string) (filtered string, missing []string) {
+	found := make(map[string]bool)
+	wanted := func(table string) (string, bool) {
+		for _, name := range names {
+			if strings.EqualFold(baseName(unquote(table)), baseName(unquote(name))) {
+				return name, true
+			}
+		}
+		return "", false
+	}
+	var buf strings.Builder
+	for _, stmt := range splitStatements(sql) {
+		// The statement is matched without the comments that precede it,
+		// such as the one before the VALIDATE CONSTRAINT statements, but
+		// written with them.
+		bare := withoutLeadingComments(stmt)
+		if m := createTableRE.FindStringSubmatch(bare); m != nil && strings.HasPrefix(bare, m[0]) {
+			name, ok := wanted(m[1])
+			if !ok {
+				continue
+			}
+			found[name] = true
+		} else if m := alterTableRE.FindStringSubmatch(bare); m != nil {
+			if _, ok := wanted(m[1]); !ok {
+				continue
+			}
+		} else if m := createIndexRE.FindStringSubmatch(bare); m != nil {
+			if _, ok := wanted(m[1]); !ok {
+				continue
+			}
+		}
+		buf.WriteString(strings.TrimSpace(stmt))
+		buf.WriteByte('\n')
+	}
+	for _, name := range names {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	return buf.String(), missing
+}
+
+// withoutLeadingComments returns stmt without the -- comments on the lines
+// before it and its surrounding whitespace.
+func withoutLeadingComments(stmt string) string {
+	stmt = strings.TrimSpace(stmt)
+	for strings.HasPrefix(stmt, "--") {
+		_, rest, _ := strings.Cut(stmt, "\n")
+		stmt = strings.TrimSpace(rest)
+	}
+	return stmt
+}
+
+// splitStatements splits sql into its statements, each including its
+// terminating semicolon. Semicolons in quoted strings are ignored.
+func splitStatements(sql string) []string {
+	var stmts []string
+	start := 0
+	for i := 0; i < len(sql); i++ {
+		switch sql[i] {
+		case ';':
+			stmts = append(stmts, sql[start:i+1])
+			start = i + 1
+		case '\'', '"':
+			if end := strings.IndexByte(sql[i+1:], sql[i]); end >= 0 {
+				i += end + 1
+			}
+		}
+	}
+	if strings.TrimSpace(sql[start:]) != "" {
+		stmts = append(stmts, sql[start:])
+	}
+	return stmts
+}
@@ -0,0 +1,198 @@
+package schema
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// schemaSQL is a schema.sql with quoted and schema-qualified names, foreign
+// keys added by ALTER TABLE, and statements that don't belong to any table.
+const schemaSQL = `USE defaultdb;
+CREATE TYPE public.status AS ENUM ('open', 'closed');
+CREATE TABLE public.users (
+	id INT8 NOT NULL,
+	name STRING NULL,
+	CONSTRAINT users_pkey PRIMARY KEY (id ASC)
+);
+CREATE TABLE public."Orders" (
+	id INT8 NOT NULL,
+	user_id INT8 NULL,
+	note STRING NULL DEFAULT 'a; b',
+	status public.status NULL,
+	CONSTRAINT orders_pkey PRIMARY KEY (id ASC)
+);
+CREATE TABLE public.items (
+	id INT8 NOT NULL,
+	order_id INT8 NULL,
+	CONSTRAINT items_pkey PRIMARY KEY (id ASC)
+);
+ALTER TABLE public."Orders" ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+ALTER TABLE public.items ADD CONSTRAINT items_order_id_fkey FOREIGN KEY (order_id) REFERENCES public."Orders"(id);
+CREATE INDEX orders_user_id_idx ON public."Orders" (user_id);
+-- Validate foreign key constraints. These can fail if there was unvalidated data during the dump.
+ALTER TABLE public."Orders" VALIDATE CONSTRAINT orders_user_id_fkey;
+ALTER TABLE public.items VALIDATE CONSTRAINT items_order_id_fkey;
+`
+
+func TestFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tables  []string
+		want    string
+		missing []string
+	}{
+		{
+			name:   "unqualified",
+			tables: []string{"users"},
+			want: `USE defaultdb;
+CREATE TYPE public.status AS ENUM ('open', 'closed');
+CREATE TABLE public.users (
+	id INT8 NOT NULL,
+	name STRING NULL,
+	CONSTRAINT users_pkey PRIMARY KEY (id ASC)
+);
+`,
+		},
+		{
+			// A quoted name is matched without its quotes and ignoring
+			// case, and its ALTER TABLE and CREATE INDEX statements are
+			// kept. A semicolon in a string doesn't end the statement.
+			name:   "quoted",
+			tables: []string{"orders"},
+			want: `USE defaultdb;
+CREATE TYPE public.status AS ENUM ('open', 'closed');
+CREATE TABLE public."Orders" (
+	id INT8 NOT NULL,
+	user_id INT8 NULL,
+	note STRING NULL DEFAULT 'a; b',
+	status public.status NULL,
+	CONSTRAINT orders_pkey PRIMARY KEY (id ASC)
+);
+ALTER TABLE public."Orders" ADD CONSTRAINT orders_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id);
+CREATE INDEX orders_user_id_idx ON public."Orders" (user_id);
+-- Validate foreign key constraints. These can fail if there was unvalidated data during the dump.
+ALTER TABLE public."Orders" VALIDATE CONSTRAINT orders_user_id_fkey;
+`,
+		},
+		{
+			// Only the last component of a qualified name is compared.
+			name:   "qualified",
+			tables: []string{"defaultdb.public.items", `"public"."USERS"`},
+			want: `USE defaultdb;
+CREATE TYPE public.status AS ENUM ('open', 'closed');
+CREATE TABLE public.users (
+	id INT8 NOT NULL,
+	name STRING NULL,
+	CONSTRAINT users_pkey PRIMARY KEY (id ASC)
+);
+CREATE TABLE public.items (
+	id INT8 NOT NULL,
+	order_id INT8 NULL,
+	CONSTRAINT items_pkey PRIMARY KEY (id ASC)
+);
+ALTER TABLE public.items ADD CONSTRAINT items_order_id_fkey FOREIGN KEY (order_id) REFERENCES public."Orders"(id);
+ALTER TABLE public.items VALIDATE CONSTRAINT items_order_id_fkey;
+`,
+		},
+		{
+			// Missing tables are returned as they were named.
+			name:   "missing",
+			tables: []string{"Users", "public.accounts", "status"},
+			want: `USE defaultdb;
+CREATE TYPE public.status AS ENUM ('open', 'closed');
+CREATE TABLE public.users (
+	id INT8 NOT NULL,
+	name STRING NULL,
+	CONSTRAINT users_pkey PRIMARY KEY (id ASC)
+);
+`,
+			missing: []string{"public.accounts", "status"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, missing := Filter(schemaSQL, tc.tables)
+			if got != tc.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+			if !slices.Equal(missing, tc.missing) {
+				t.Errorf("got missing %q, want %q", missing, tc.missing)
+			}
+		})
+	}
+}
+
+func TestFilterEmpty(t *testing.T) {
+	got, missing := Filter("", []string{"users"})
+	if got != "" || !slices.Equal(missing, []string{"users"}) {
+		t.Errorf("got %q, %q, want \"\", [users]", got, missing)
+	}
+	if got, _ := Filter(schemaSQL, nil); strings.Contains(got, "CREATE TABLE") {
+		t.Errorf("got CREATE TABLE statements without any tables named:\n%s", got)
+	}
+}
+
+func TestRelevantTables(t *testing.T) {
+	s := Parse(schemaSQL)
+	for _, tc := range []struct {
+		name      string
+		statement string
+		want      []string
+	}{
+		{
+			// The foreign key of items to Orders is followed, but not
+			// the one of Orders to users beyond it.
+			name:      "foreign keys one level deep",
+			statement: "SELECT * FROM items WHERE id = 1",
+			want:      []string{"public.Orders", "public.items"},
+		},
+		{
+			name:      "quoted and aliased",
+			statement: `SELECT o.id FROM public."Orders" AS o JOIN users AS u ON u.id = o.user_id`,
+			want:      []string{"public.users", "public.Orders"},
+		},
+		{
+			name:      "qualified",
+			statement: "UPDATE defaultdb.public.users SET name = 'x' WHERE id = 1",
+			want:      []string{"public.users"},
+		},
+		{
+			// accounts is referenced but isn't in the schema.
+			name:      "not in the schema",
+			statement: "SELECT * FROM accounts JOIN users ON accounts.user_id = users.id",
+			want:      []string{"public.users"},
+		},
+		{
+			name:      "string literal",
+			statement: "SELECT * FROM users WHERE name = 'items'",
+			want:      []string{"public.users"},
+		},
+		{
+			// A column with the name of a table makes it relevant too.
+			name:      "column named like a table",
+			statement: "SELECT items FROM accounts",
+			want:      []string{"public.Orders", "public.items"},
+		},
+		{
+			name:      "no tables",
+			statement: "SELECT 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := s.RelevantTables(tc.statement); !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCommentedForeignKey(t *testing.T) {
+	s := Parse(`CREATE TABLE parent (id INT PRIMARY KEY);
+CREATE TABLE child (id INT PRIMARY KEY, parent_id INT);
+-- Added after the tables were created.
+ALTER TABLE child ADD CONSTRAINT child_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES parent (id);
+`)
+	if got, want := s.RelevantTables("SELECT * FROM child"), []string{"parent", "child"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
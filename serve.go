@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+const (
+	// defaultServeAddr is the default address that serve listens on. Only
+	// local clients can connect, since every analysis is paid for with the
+	// server's API key.
+	defaultServeAddr = "localhost:8080"
+	// defaultRequestTimeout is the default maximum time to handle a request
+	// to serve, from reading the upload to writing the analysis.
+	defaultRequestTimeout = 5 * time.Minute
+	// multipartOverhead is the room allowed in the body of a request for
+	// the multipart headers and boundaries around the bundle.
+	multipartOverhead = 64 << 10
+	// bundleField is the name of the multipart form field holding the
+	// bundle.
+	bundleField = "bundle"
+)
+
+// server handles the HTTP requests of the serve subcommand.
+type server struct {
+	// text analyzes bundles whose analysis is returned as text, and
+	// structured those returned as JSON or Markdown, which are requested in
+	// JSON mode.
+	text       analyze.Analyzer
+	structured analyze.Analyzer
+	cfg        config
+	// sem limits the number of bundles analyzed at once. Other requests
+	// wait for their turn.
+	sem            chan struct{}
+	requestTimeout time.Duration
+}
+
+// serveResponse is the body of a JSON response to POST /analyze.
+type serveResponse struct {
+	jsonLine
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// runServe implements the serve subcommand, which listens on addr and
+// analyzes the bundles uploaded to it until ctx is cancelled.
+func runServe(ctx context.Context, addr string, s *server) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /analyze", s.handleAnalyze)
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       s.requestTimeout,
+		// Cancelling ctx abandons the analyses in flight.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	log.Printf("Listening on %s", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Failed to serve: %v", err)
+	}
+	exitCancelled()
+}
+
+// handleHealthz reports that the server is up.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// handleAnalyze analyzes the bundle uploaded in the bundle field of a
+// multipart form, and responds with the analysis in the format given by the
+// format query parameter or the Accept header.
+func (s *server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	format, err := responseFormat(r, s.cfg.format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	if s.cfg.maxBundleBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.maxBundleBytes+multipartOverhead)
+	}
+	name, data, err := s.readUpload(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) || errors.Is(err, errUploadTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeServeError(w, format, name, status, err)
+		return
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-r.Context().Done():
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.requestTimeout)
+	defer cancel()
+
+	prefix := name + ": "
+	var warnings []string
+	warnf := func(format string, args ...any) {
+		log.Printf(prefix+format, args...)
+		warnings = append(warnings, strings.TrimPrefix(fmt.Sprintf(format, args...), "warning: "))
+	}
+	cfg := s.cfg
+	analyzer := s.text
+	cfg.format = format
+	if format == formatJSON {
+		// The analysis is encoded along with the bundle's name, warnings,
+		// and usage, like a line of -format jsonl.
+		cfg.format = formatJSONL
+	}
+	if cfg.structured() {
+		analyzer = s.structured
+	}
+	files, err := extractBundleFiles(name, data, cfg, prefix, warnf)
+	if err != nil {
+		writeServeError(w, format, name, http.StatusUnprocessableEntity, err)
+		return
+	}
+	res, err := withDebugDump(ctx, name, cfg, func(ctx context.Context) (bundleResult, error) {
+		return analyzeFiles(ctx, name, files, analyzer, cfg, prefix, warnf)
+	})
+	switch {
+	case r.Context().Err() != nil:
+		// The client is gone.
+		return
+	case ctx.Err() != nil:
+		writeServeError(w, format, name, http.StatusGatewayTimeout, fmt.Errorf("analysis timed out after %s", s.requestTimeout))
+		return
+	case err != nil:
+		log.Printf("%s%v", prefix, err)
+		writeServeError(w, format, name, http.StatusBadGateway, err)
+		return
+	}
+
+	switch format {
+	case formatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(serveResponse{
+			jsonLine: jsonLine{Bundle: name, Analysis: res.analysis, Usage: res.usage},
+			Warnings: warnings,
+		})
+	case formatMarkdown:
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		io.WriteString(w, res.output)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, res.output)
+	}
+}
+
+// errUploadTooLarge is returned by readUpload for a bundle larger than
+// -max-bundle-bytes.
+var errUploadTooLarge = errors.New("bundle is too large")
+
+// readUpload returns the file name and contents of the bundle in the bundle
+// field of the request's multipart form.
+func (s *server) readUpload(r *http.Request) (string, []byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", nil, fmt.Errorf("expected a multipart/form-data upload: %w", err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return "", nil, fmt.Errorf("missing %s field in the upload", bundleField)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read upload: %w", err)
+		}
+		if part.FormName() != bundleField {
+			continue
+		}
+		name := filepath.Base(part.FileName())
+		if name == "." || name == string(filepath.Separator) {
+			name = "bundle.zip"
+		}
+		r := io.Reader(part)
+		if max := s.cfg.maxBundleBytes; max > 0 {
+			r = io.LimitReader(part, max+1)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return name, nil, fmt.Errorf("failed to read upload: %w", err)
+		}
+		if max := s.cfg.maxBundleBytes; max > 0 && int64(len(data)) > max {
+			return name, nil, fmt.Errorf("%w: larger than the maximum bundle size of %d bytes", errUploadTooLarge, max)
+		}
+		return name, data, nil
+	}
+}
+
+// responseFormat returns the format of the analysis requested by the format
+// query parameter, or failing that by the Accept header, or def if neither
+// asks for a format.
+func responseFormat(r *http.Request, def string) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		switch f {
+		case formatText, formatJSON, formatMarkdown:
+			return f, nil
+		}
+		return "", fmt.Errorf("unknown format %q, expected text, json, or markdown", f)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return formatJSON, nil
+		case "text/markdown":
+			return formatMarkdown, nil
+		case "text/plain":
+			return formatText, nil
+		}
+	}
+	return def, nil
+}
+
+// writeServeError responds with err and the HTTP status code, as JSON if that
+// is the requested format.
+func writeServeError(w http.ResponseWriter, format, name string, status int, err error) {
+	if format != formatJSON {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(jsonLine{Bundle: name, Error: err.Error()})
+}
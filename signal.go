@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// exitStatusCancelled is the exit status after SIGINT or SIGTERM, following the
+// shell convention of 128 plus the signal number of SIGINT.
+const exitStatusCancelled = 130
+
+// cancelGracePeriod is how long to wait after a signal for the in-flight
+// requests to return before exiting anyway, such as while reading a question
+// from stdin in interactive mode.
+const cancelGracePeriod = 2 * time.Second
+
+// cancelOnSignal returns a context that is cancelled on SIGINT or SIGTERM, so
+// that the in-flight requests are abandoned and their connections closed. The
+// caller should call exitCancelled once it notices the cancellation. A second
+// signal exits immediately.
+func cancelOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		// Restore the default behavior, so that a second signal kills the
+		// process.
+		signal.Stop(sigs)
+		cancel()
+		time.Sleep(cancelGracePeriod)
+		exitCancelled()
+	}()
+	return ctx
+}
+
+var exitCancelledOnce sync.Once
+
+// exitCancelled prints "cancelled" and exits with status exitStatusCancelled.
+func exitCancelled() {
+	exitCancelledOnce.Do(func() {
+		fmt.Fprintln(os.Stderr, "\ncancelled")
+		os.Exit(exitStatusCancelled)
+	})
+}
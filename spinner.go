@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// spinnerFrames are the frames of the spinner animation.
+var spinnerFrames = []rune(`|/-\`)
+
+// spinnerInterval is the time between frames of the spinner.
+const spinnerInterval = 100 * time.Millisecond
+
+// startSpinner displays a spinner and the elapsed time on stderr while
+// waiting for the API, and returns a function that stops and clears it. The
+// spinner is not displayed if stderr is not a terminal, if -quiet is set, if
+// the response is being streamed, or if more than one bundle is being
+// analyzed at once.
+func startSpinner(cfg config) (stop func()) {
+	if cfg.quiet || cfg.stream || cfg.batch || !isTerminal(os.Stderr, false) {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		start := time.Now()
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(os.Stderr, "\r%c Waiting for the API... %ds",
+				spinnerFrames[i%len(spinnerFrames)], int(time.Since(start).Seconds()))
+			select {
+			case <-ticker.C:
+			case <-done:
+				// Clear the line.
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
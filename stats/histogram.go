@@ -0,0 +1,144 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SkewFactor is how many times more often than in a uniform distribution the
+// most common value of a column must appear for the column to be considered
+// skewed.
+const SkewFactor = 10
+
+// Bucket is a single bucket of a histogram. It counts the rows equal to its
+// upper bound, and the rows between it and the upper bound of the previous
+// bucket.
+type Bucket struct {
+	UpperBound    string  `json:"upper_bound"`
+	NumEq         float64 `json:"num_eq"`
+	NumRange      float64 `json:"num_range"`
+	DistinctRange float64 `json:"distinct_range"`
+}
+
+// Distribution summarizes the histogram of a column.
+type Distribution struct {
+	// Column is the column's name, qualified by its table's name without
+	// the database and schema, such as "users.email".
+	Column        string
+	RowCount      int64
+	DistinctCount int64
+	NullCount     int64
+	// MostCommon is the value with the most rows equal to it among the
+	// histogram's upper bounds, and MostCommonCount is the estimated number
+	// of those rows.
+	MostCommon      string
+	MostCommonCount float64
+}
+
+// NullFraction returns the fraction of rows in which the column is NULL.
+func (d Distribution) NullFraction() float64 {
+	if d.RowCount == 0 {
+		return 0
+	}
+	return float64(d.NullCount) / float64(d.RowCount)
+}
+
+// MostCommonFraction returns the fraction of rows in which the column has its
+// most common value.
+func (d Distribution) MostCommonFraction() float64 {
+	if d.RowCount == 0 {
+		return 0
+	}
+	return d.MostCommonCount / float64(d.RowCount)
+}
+
+// Skew returns how many times more often the most common value appears than
+// each value would in a uniform distribution of the column's distinct values.
+func (d Distribution) Skew() float64 {
+	return d.MostCommonFraction() * float64(max(d.DistinctCount, 1))
+}
+
+// Skewed returns true if the most common value appears at least SkewFactor
+// times more often than in a uniform distribution.
+func (d Distribution) Skewed() bool {
+	return d.Skew() >= SkewFactor
+}
+
+// String returns a single line describing the distribution, such as
+// "users.status: 1000 rows, 3 distinct values, 0.0% NULL; most common value
+// 'active' in 98.0% of rows (2.9x uniform)".
+func (d Distribution) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%s: %d rows, %d distinct values, %.1f%% NULL", d.Column, d.RowCount, d.DistinctCount, 100*d.NullFraction())
+	if d.MostCommonCount > 0 {
+		fmt.Fprintf(&buf, "; most common value %s in %.1f%% of rows (%.1fx uniform)", d.MostCommon, 100*d.MostCommonFraction(), d.Skew())
+	}
+	if d.Skewed() {
+		buf.WriteString(", skewed")
+	}
+	return buf.String()
+}
+
+// Distributions returns a summary of the most recent histogram of each column
+// of the table, in the order the columns first appear in the statistics.
+// Columns without a histogram are left out.
+func (t *Table) Distributions() []Distribution {
+	name := t.Name
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	var columns []string
+	latest := make(map[string]Statistic)
+	for _, s := range t.Statistics {
+		if len(s.Columns) != 1 || len(s.Histogram) == 0 {
+			continue
+		}
+		col := s.Columns[0]
+		prev, ok := latest[col]
+		if !ok {
+			columns = append(columns, col)
+		}
+		if !ok || s.CreatedAt.After(prev.CreatedAt) {
+			latest[col] = s
+		}
+	}
+	dists := make([]Distribution, 0, len(columns))
+	for _, col := range columns {
+		s := latest[col]
+		d := Distribution{
+			Column:        name + "." + col,
+			RowCount:      s.RowCount,
+			DistinctCount: s.DistinctCount,
+			NullCount:     s.NullCount,
+		}
+		for _, b := range s.Histogram {
+			if b.NumEq > d.MostCommonCount {
+				d.MostCommon, d.MostCommonCount = quoteValue(b.UpperBound), b.NumEq
+			}
+		}
+		dists = append(dists, d)
+	}
+	return dists
+}
+
+// quoteValue returns the histogram bound v as a SQL literal, truncated if it
+// is long.
+func quoteValue(v string) string {
+	const maxLen = 40
+	if r := []rune(v); len(r) > maxLen {
+		v = string(r[:maxLen]) + "..."
+	}
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// SummarizeDistributions returns the distributions of the columns of tables,
+// one per line, or "" if none of them have histograms.
+func SummarizeDistributions(tables []*Table) string {
+	var buf strings.Builder
+	for _, t := range tables {
+		for _, d := range t.Distributions() {
+			fmt.Fprintf(&buf, "%s\n", d)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+// statsSQL is a stats-*.sql file with a histogram on status, two on
+// tenant_id of which the later one is used, and none on id or (id, status).
+const statsSQL = `ALTER TABLE defaultdb.public.orders INJECT STATISTICS '[
+	{"name": "__auto__", "columns": ["id"], "created_at": "2024-05-01 10:00:00.000000",
+	 "row_count": 1000, "distinct_count": 1000, "null_count": 0},
+	{"name": "__auto__", "columns": ["status"], "created_at": "2024-05-01 10:00:00.000000",
+	 "row_count": 1000, "distinct_count": 3, "null_count": 100,
+	 "histo_buckets": [
+		{"upper_bound": "active", "num_eq": 600, "num_range": 0, "distinct_range": 0},
+		{"upper_bound": "closed", "num_eq": 200, "num_range": 0, "distinct_range": 0},
+		{"upper_bound": "pending", "num_eq": 100, "num_range": 0, "distinct_range": 0}]},
+	{"name": "__auto__", "columns": ["tenant_id"], "created_at": "2024-04-01 10:00:00.000000",
+	 "row_count": 500, "distinct_count": 50, "null_count": 0,
+	 "histo_buckets": [{"upper_bound": "7", "num_eq": 10, "num_range": 490, "distinct_range": 49}]},
+	{"name": "__auto__", "columns": ["tenant_id"], "created_at": "2024-05-01 10:00:00.000000",
+	 "row_count": 1000, "distinct_count": 100, "null_count": 0,
+	 "histo_buckets": [
+		{"upper_bound": "1", "num_eq": 10, "num_range": 0, "distinct_range": 0},
+		{"upper_bound": "O''Brien Ltd", "num_eq": 500, "num_range": 490, "distinct_range": 98}]},
+	{"name": "__auto__", "columns": ["id", "status"], "created_at": "2024-05-01 10:00:00.000000",
+	 "row_count": 1000, "distinct_count": 1000, "null_count": 0}
+]'`
+
+func TestDistributions(t *testing.T) {
+	table, err := Parse(statsSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dists := table.Distributions()
+	if len(dists) != 2 {
+		t.Fatalf("got %d distributions, want 2: %v", len(dists), dists)
+	}
+
+	for _, tc := range []struct {
+		dist               Distribution
+		column, mostCommon string
+		rowCount           int64
+		nullFraction       float64
+		mostCommonFraction float64
+		skew               float64
+		skewed             bool
+	}{
+		{
+			dist: dists[0], column: "orders.status", mostCommon: "'active'",
+			rowCount: 1000, nullFraction: 0.1, mostCommonFraction: 0.6, skew: 1.8,
+		},
+		{
+			// The later of the two histograms on tenant_id.
+			dist: dists[1], column: "orders.tenant_id", mostCommon: "'O''Brien Ltd'",
+			rowCount: 1000, nullFraction: 0, mostCommonFraction: 0.5, skew: 50, skewed: true,
+		},
+	} {
+		d := tc.dist
+		if d.Column != tc.column || d.MostCommon != tc.mostCommon || d.RowCount != tc.rowCount {
+			t.Errorf("got %s, %s, %d rows; want %s, %s, %d rows", d.Column, d.MostCommon, d.RowCount, tc.column, tc.mostCommon, tc.rowCount)
+		}
+		if got := d.NullFraction(); !approxEqual(got, tc.nullFraction) {
+			t.Errorf("%s: NullFraction() = %v, want %v", d.Column, got, tc.nullFraction)
+		}
+		if got := d.MostCommonFraction(); !approxEqual(got, tc.mostCommonFraction) {
+			t.Errorf("%s: MostCommonFraction() = %v, want %v", d.Column, got, tc.mostCommonFraction)
+		}
+		if got := d.Skew(); !approxEqual(got, tc.skew) {
+			t.Errorf("%s: Skew() = %v, want %v", d.Column, got, tc.skew)
+		}
+		if got := d.Skewed(); got != tc.skewed {
+			t.Errorf("%s: Skewed() = %t, want %t", d.Column, got, tc.skewed)
+		}
+	}
+}
+
+func TestDistributionEmpty(t *testing.T) {
+	var d Distribution
+	if d.NullFraction() != 0 || d.MostCommonFraction() != 0 || d.Skew() != 0 || d.Skewed() {
+		t.Errorf("empty distribution: %s", d)
+	}
+}
+
+func TestSummarizeDistributions(t *testing.T) {
+	table, err := Parse(statsSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "orders.status: 1000 rows, 3 distinct values, 10.0% NULL; most common value 'active' in 60.0% of rows (1.8x uniform)\n" +
+		"orders.tenant_id: 1000 rows, 100 distinct values, 0.0% NULL; most common value 'O''Brien Ltd' in 50.0% of rows (50.0x uniform), skewed\n"
+	if got := SummarizeDistributions([]*Table{table}); got != want {
+		t.Errorf("SummarizeDistributions() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Without histograms, the section is left out of the prompt.
+	noHistograms, err := Parse(`ALTER TABLE t INJECT STATISTICS '[{"columns": ["a"], "created_at": "2024-05-01 10:00:00", "row_count": 10, "distinct_count": 10}]'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := SummarizeDistributions([]*Table{noHistograms}); got != "" {
+		t.Errorf("SummarizeDistributions() without histograms = %q, want \"\"", got)
+	}
+	if got := SummarizeDistributions(nil); got != "" {
+		t.Errorf("SummarizeDistributions(nil) = %q, want \"\"", got)
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
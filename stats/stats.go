@@ -0,0 +1,180 @@
+// Package stats parses the table statistics in a statement bundle's
+// stats-*.sql files.
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StaleAfter is the age after which a table's statistics are considered
+// stale. CockroachDB refreshes statistics automatically as tables change, so
+// statistics this old usually mean that automatic collection is disabled or
+// failing.
+const StaleAfter = 7 * 24 * time.Hour
+
+// Table is the statistics injected for a table by a stats-*.sql file.
+type Table struct {
+	// Name is the table's name as written in the statement, such as
+	// "defaultdb.public.users".
+	Name       string
+	Statistics []Statistic
+}
+
+// Statistic is a single statistic collected on a set of columns.
+type Statistic struct {
+	Name          string
+	Columns       []string
+	CreatedAt     time.Time
+	RowCount      int64
+	DistinctCount int64
+	NullCount     int64
+	// Histogram is the statistic's histogram, or nil if none was
+	// collected. Only statistics on a single column have histograms.
+	Histogram []Bucket
+}
+
+// injectRE matches an ALTER TABLE ... INJECT STATISTICS statement, capturing
+// the table name and the quoted JSON array of statistics.
+var injectRE = regexp.MustCompile(`(?is)\bALTER\s+TABLE\s+([\w."]+)\s+INJECT\s+STATISTICS\s+'(.*)'`)
+
+// createdAtLayouts are the formats of the created_at timestamps of
+// statistics.
+var createdAtLayouts = []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano}
+
+// IsStatsFile returns true if name is a statistics file, such as
+// "stats-defaultdb.public.users.sql".
+func IsStatsFile(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(base, "stats-") && strings.HasSuffix(base, ".sql")
+}
+
+// Parse parses the ALTER TABLE ... INJECT STATISTICS statement in the text of
+// a stats-*.sql file.
+func Parse(sql string) (*Table, error) {
+	m := injectRE.FindStringSubmatch(sql)
+	if m == nil {
+		return nil, fmt.Errorf("no INJECT STATISTICS statement found")
+	}
+	var raw []struct {
+		Name          string   `json:"name"`
+		Columns       []string `json:"columns"`
+		CreatedAt     string   `json:"created_at"`
+		RowCount      int64    `json:"row_count"`
+		DistinctCount int64    `json:"distinct_count"`
+		NullCount     int64    `json:"null_count"`
+		Histogram     []Bucket `json:"histo_buckets"`
+	}
+	if err := json.Unmarshal([]byte(strings.ReplaceAll(m[2], "''", "'")), &raw); err != nil {
+		return nil, fmt.Errorf("invalid statistics: %w", err)
+	}
+	t := &Table{Name: strings.ReplaceAll(m[1], `"`, "")}
+	for _, r := range raw {
+		createdAt, err := parseCreatedAt(r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		t.Statistics = append(t.Statistics, Statistic{
+			Name:          r.Name,
+			Columns:       r.Columns,
+			CreatedAt:     createdAt,
+			RowCount:      r.RowCount,
+			DistinctCount: r.DistinctCount,
+			NullCount:     r.NullCount,
+			Histogram:     r.Histogram,
+		})
+	}
+	return t, nil
+}
+
+// ParseFiles parses every statistics file in a bundle's files, sorted by table
+// name. Files that fail to parse are returned in errs, keyed by file name.
+func ParseFiles(files map[string]string) (tables []*Table, errs map[string]error) {
+	for name, content := range files {
+		if !IsStatsFile(name) {
+			continue
+		}
+		t, err := Parse(content)
+		if err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[name] = err
+			continue
+		}
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return tables, errs
+}
+
+func parseCreatedAt(value string) (time.Time, error) {
+	for _, layout := range createdAtLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid created_at %q", value)
+}
+
+// LastCollected returns the time the table's most recent statistic was
+// collected, or false if it has no statistics.
+func (t *Table) LastCollected() (time.Time, bool) {
+	var last time.Time
+	for _, s := range t.Statistics {
+		if s.CreatedAt.After(last) {
+			last = s.CreatedAt
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// Stale returns true if the table has no statistics, or if they were last
+// collected more than StaleAfter before the bundle was captured. The age of
+// the statistics is measured against the capture time rather than the current
+// time so that an old bundle doesn't make every table look stale. A zero
+// captured, for a bundle whose capture time is unknown, only counts a table
+// without statistics as stale.
+func (t *Table) Stale(captured time.Time) bool {
+	last, ok := t.LastCollected()
+	return !ok || !captured.IsZero() && captured.Sub(last) > StaleAfter
+}
+
+// Freshness returns a sentence describing how long before the bundle was
+// captured the table's statistics were last collected. If captured is zero,
+// only the time they were collected is given.
+func (t *Table) Freshness(captured time.Time) string {
+	last, ok := t.LastCollected()
+	if !ok {
+		return fmt.Sprintf("No statistics have been collected for table %s.", t.Name)
+	}
+	collected := last.UTC().Format("2006-01-02 15:04 MST")
+	if captured.IsZero() {
+		return fmt.Sprintf("Statistics for table %s were last collected at %s.", t.Name, collected)
+	}
+	return fmt.Sprintf("Statistics for table %s were last collected %s the bundle was captured (%s).",
+		t.Name, age(captured.Sub(last)), collected)
+}
+
+// age formats d as a rough number of days or hours before a time.
+func age(d time.Duration) string {
+	switch days := int(d / (24 * time.Hour)); {
+	case d < 0:
+		return "after"
+	case days == 1:
+		return "1 day before"
+	case days > 1:
+		return fmt.Sprintf("%d days before", days)
+	case d < time.Hour:
+		return "less than an hour before"
+	case d < 2*time.Hour:
+		return "1 hour before"
+	default:
+		return fmt.Sprintf("%d hours before", int(d/time.Hour))
+	}
+}
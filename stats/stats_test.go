@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFiles(t *testing.T) {
+	files := map[string]string{
+		"stats-defaultdb.public.orders.sql": statsSQL,
+		"stats-defaultdb.public.users.sql":  `ALTER TABLE "defaultdb"."public"."users" INJECT STATISTICS '[]';`,
+		"stats-broken.sql":                  "SELECT 1;",
+		"stats-invalid.sql":                 `ALTER TABLE t INJECT STATISTICS '[{"created_at": "yesterday"}]';`,
+		"schema.sql":                        "CREATE TABLE t (a INT);",
+		"nested/stats-defaultdb.public.items.sql": `ALTER TABLE defaultdb.public.items INJECT STATISTICS '[
+			{"name": "__auto__", "columns": ["id"], "created_at": "2024-05-01T10:00:00Z", "row_count": 5}]';`,
+	}
+	tables, errs := ParseFiles(files)
+
+	var names []string
+	for _, table := range tables {
+		names = append(names, table.Name)
+	}
+	if got, want := strings.Join(names, ", "), "defaultdb.public.items, defaultdb.public.orders, defaultdb.public.users"; got != want {
+		t.Errorf("got tables %s, want %s", got, want)
+	}
+	if len(errs) != 2 || errs["stats-broken.sql"] == nil || errs["stats-invalid.sql"] == nil {
+		t.Errorf("got errors %v, want errors for stats-broken.sql and stats-invalid.sql", errs)
+	}
+	if got := len(tables[0].Statistics); got != 1 {
+		t.Errorf("got %d statistics for items, want 1", got)
+	}
+	if got := len(tables[2].Statistics); got != 0 {
+		t.Errorf("got %d statistics for users, want 0", got)
+	}
+}
+
+func TestLastCollected(t *testing.T) {
+	table, err := Parse(statsSQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, ok := table.LastCollected()
+	if want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC); !ok || !last.Equal(want) {
+		t.Errorf("got %v, %t, want %v, true", last, ok, want)
+	}
+
+	if last, ok := (&Table{Name: "t"}).LastCollected(); ok {
+		t.Errorf("got %v, true for a table without statistics, want false", last)
+	}
+}
+
+func TestStaleAndFreshness(t *testing.T) {
+	collected := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	table := &Table{Name: "users", Statistics: []Statistic{
+		{Columns: []string{"id"}, CreatedAt: collected.Add(-48 * time.Hour)},
+		{Columns: []string{"name"}, CreatedAt: collected},
+	}}
+	for _, tc := range []struct {
+		name     string
+		table    *Table
+		captured time.Time
+		stale    bool
+		want     string
+	}{
+		{
+			name: "minutes", table: table, captured: collected.Add(30 * time.Minute),
+			want: "Statistics for table users were last collected less than an hour before the bundle was captured (2024-05-01 10:00 UTC).",
+		},
+		{
+			name: "one hour", table: table, captured: collected.Add(90 * time.Minute),
+			want: "Statistics for table users were last collected 1 hour before the bundle was captured (2024-05-01 10:00 UTC).",
+		},
+		{
+			name: "hours", table: table, captured: collected.Add(5 * time.Hour),
+			want: "Statistics for table users were last collected 5 hours before the bundle was captured (2024-05-01 10:00 UTC).",
+		},
+		{
+			name: "one day", table: table, captured: collected.Add(36 * time.Hour),
+			want: "Statistics for table users were last collected 1 day before the bundle was captured (2024-05-01 10:00 UTC).",
+		},
+		{
+			name: "a week", table: table, captured: collected.Add(StaleAfter),
+			want: "Statistics for table users were last collected 7 days before the bundle was captured (2024-05-01 10:00 UTC).",
+		},
+		{
+			name: "stale", table: table, captured: collected.Add(9*24*time.Hour + time.Hour), stale: true,
+			want: "Statistics for table users were last collected 9 days before the bundle was captured (2024-05-01 10:00 UTC).",
+		},
+		{
+			// The clocks of the nodes may disagree.
+			name: "after capture", table: table, captured: collected.Add(-time.Minute),
+			want: "Statistics for table users were last collected after the bundle was captured (2024-05-01 10:00 UTC).",
+		},
+		{
+			name: "unknown capture time", table: table,
+			want: "Statistics for table users were last collected at 2024-05-01 10:00 UTC.",
+		},
+		{
+			name: "no statistics", table: &Table{Name: "orders"}, captured: collected, stale: true,
+			want: "No statistics have been collected for table orders.",
+		},
+		{
+			name: "no statistics, unknown capture time", table: &Table{Name: "orders"}, stale: true,
+			want: "No statistics have been collected for table orders.",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.table.Stale(tc.captured); got != tc.stale {
+				t.Errorf("Stale() = %t, want %t", got, tc.stale)
+			}
+			if got := tc.table.Freshness(tc.captured); got != tc.want {
+				t.Errorf("Freshness() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import "os"
+
+// terminalSize returns false, since the size of the terminal can't be read on
+// this platform, so the output is never paged.
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,256 @@
+// Package trace summarizes the execution trace in a statement bundle's
+// trace.json, which is far too large to send to the model as is.
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileName is the name of the trace in a bundle.
+const FileName = "trace.json"
+
+// fileNames are the files of a bundle holding the trace in full, in
+// trace.json's format, Jaeger's, or as text.
+var fileNames = [...]string{FileName, "trace-jaeger.json", "trace.txt"}
+
+// IsTraceFile returns true if name is one of the files of a bundle holding the
+// trace in full, such as trace.json or trace.txt.
+func IsTraceFile(name string) bool {
+	return slices.Contains(fileNames[:], path.Base(name))
+}
+
+// Span is a span of the trace, an operation such as "optimizer" or "dist
+// sender send", with the spans of the operations it started.
+type Span struct {
+	Operation string
+	Start     time.Time
+	Duration  time.Duration
+	Logs      []Log
+	Children  []*Span
+}
+
+// Log is a message logged during a span.
+type Log struct {
+	Time    time.Time
+	Message string
+}
+
+// rawSpan is a span as it appears in trace.json.
+type rawSpan struct {
+	Operation string    `json:"operation"`
+	StartTime time.Time `json:"startTime"`
+	// Duration is in seconds with a unit, such as "0.005474666s".
+	Duration string `json:"duration"`
+	Logs     []struct {
+		Time    time.Time `json:"time"`
+		Message string    `json:"message"`
+	} `json:"logs"`
+	Children []rawSpan `json:"children"`
+}
+
+// Parse parses the trace.json of a bundle, returning its root span. A trace
+// holding several root spans, in a JSON array, is returned as the children of
+// a root span named "trace" that lasts as long as they do.
+func Parse(data string) (*Span, error) {
+	trimmed := strings.TrimSpace(data)
+	if trimmed == "" {
+		return nil, fmt.Errorf("empty trace")
+	}
+	if strings.HasPrefix(trimmed, "[") {
+		var raws []rawSpan
+		if err := json.Unmarshal([]byte(trimmed), &raws); err != nil {
+			return nil, fmt.Errorf("invalid trace: %w", err)
+		}
+		if len(raws) == 0 {
+			return nil, fmt.Errorf("empty trace")
+		}
+		root := &Span{Operation: "trace"}
+		var end time.Time
+		for _, raw := range raws {
+			s, err := convert(raw)
+			if err != nil {
+				return nil, err
+			}
+			if root.Start.IsZero() || s.Start.Before(root.Start) {
+				root.Start = s.Start
+			}
+			if e := s.Start.Add(s.Duration); e.After(end) {
+				end = e
+			}
+			root.Children = append(root.Children, s)
+		}
+		root.Duration = end.Sub(root.Start)
+		return root, nil
+	}
+	var raw rawSpan
+	if err := json.Unmarshal([]byte(trimmed), &raw); err != nil {
+		return nil, fmt.Errorf("invalid trace: %w", err)
+	}
+	return convert(raw)
+}
+
+// convert returns the Span of raw and its children.
+func convert(raw rawSpan) (*Span, error) {
+	if raw.Operation == "" {
+		return nil, fmt.Errorf("invalid trace: span without an operation")
+	}
+	s := &Span{Operation: raw.Operation, Start: raw.StartTime}
+	if raw.Duration != "" {
+		d, err := time.ParseDuration(raw.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration of span %q: %w", raw.Operation, err)
+		}
+		s.Duration = d
+	}
+	for _, l := range raw.Logs {
+		s.Logs = append(s.Logs, Log{Time: l.Time, Message: l.Message})
+	}
+	for _, c := range raw.Children {
+		child, err := convert(c)
+		if err != nil {
+			return nil, err
+		}
+		s.Children = append(s.Children, child)
+	}
+	return s, nil
+}
+
+// walk calls fn for s and each of its descendants, parents before children.
+func (s *Span) walk(fn func(*Span)) {
+	fn(s)
+	for _, c := range s.Children {
+		c.walk(fn)
+	}
+}
+
+// LongestSpans returns up to n of the descendants of s with the longest
+// durations, longest first. Spans of equal duration are in the order they
+// appear in the trace.
+func (s *Span) LongestSpans(n int) []*Span {
+	var spans []*Span
+	for _, c := range s.Children {
+		c.walk(func(d *Span) { spans = append(spans, d) })
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Duration > spans[j].Duration })
+	return spans[:min(n, len(spans))]
+}
+
+// Kinds of notable events.
+const (
+	KindRetry      = "retry"
+	KindContention = "contention"
+	KindWait       = "wait"
+)
+
+// Event is a message logged during the trace that may explain why the
+// statement was slow, such as a transaction retry or lock contention.
+type Event struct {
+	// Kind is KindRetry, KindContention, or KindWait.
+	Kind string
+	// Span is the operation of the span the message was logged in.
+	Span string
+	// Offset is the time from the start of the trace to the message.
+	Offset  time.Duration
+	Message string
+}
+
+// String returns the event as "contention in dist sender send at +3.1ms:
+// pushing txn 9dc9bfbe".
+func (e Event) String() string {
+	return fmt.Sprintf("%s in %s at +%s: %s", e.Kind, e.Span, e.Offset.Round(time.Microsecond), e.Message)
+}
+
+// eventREs match the messages of each kind of notable event, checked in
+// order.
+var eventREs = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{KindRetry, regexp.MustCompile(`(?i)\b(?:retrying|restarting|TransactionRetry\w*|ReadWithinUncertaintyInterval\w*|WriteTooOld\w*)\b|\bafter [1-9]\d* retries\b`)},
+	{KindContention, regexp.MustCompile(`(?i)\b(?:contention|contended|pushing (?:txn|transaction)|pushee|WriteIntentError|LockConflictError|conflicting (?:intents?|locks?) (?:found|encountered))\b`)},
+	{KindWait, regexp.MustCompile(`(?i)\b(?:waiting|waited)\b`)},
+}
+
+// negligibleRE matches messages that mention a kind of event only to say it
+// took no time.
+var negligibleRE = regexp.MustCompile(`(?i)\b(?:waited|contention time:?) 0s\b`)
+
+// logPrefixRE matches the source location and tags that precede a logged
+// message, such as "sql/conn_executor_exec.go:1087 [n1,client=...] ".
+var logPrefixRE = regexp.MustCompile(`^\S+\.go:\d+ (?:\[[^\]]*\] )?`)
+
+// maxEventLen is the length in characters after which the message of an
+// event is cut.
+const maxEventLen = 200
+
+// NotableEvents returns the retries, contention, and waits logged during s and
+// its descendants, in the order they were logged.
+func (s *Span) NotableEvents() []Event {
+	var events []Event
+	s.walk(func(d *Span) {
+		for _, l := range d.Logs {
+			msg := cleanMessage(l.Message)
+			if negligibleRE.MatchString(msg) {
+				continue
+			}
+			for _, e := range eventREs {
+				if !e.re.MatchString(msg) {
+					continue
+				}
+				var offset time.Duration
+				if !l.Time.IsZero() && !s.Start.IsZero() {
+					offset = max(l.Time.Sub(s.Start), 0)
+				}
+				events = append(events, Event{Kind: e.kind, Span: d.Operation, Offset: offset, Message: msg})
+				break
+			}
+		}
+	})
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Offset < events[j].Offset })
+	return events
+}
+
+// cleanMessage returns a logged message without its source location, tags,
+// and redaction markers, cut to maxEventLen characters.
+func cleanMessage(msg string) string {
+	msg = logPrefixRE.ReplaceAllString(strings.TrimSpace(msg), "")
+	msg = strings.NewReplacer("‹", "", "›", "").Replace(msg)
+	if runes := []rune(msg); len(runes) > maxEventLen {
+		msg = string(runes[:maxEventLen-1]) + "…"
+	}
+	return msg
+}
+
+// Summarize returns a compact summary of the trace rooted at s: its duration,
+// its n longest spans, and up to maxEvents of its notable events, followed by
+// the number of events left out.
+func (s *Span) Summarize(n, maxEvents int) string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "The traced statement took %s.\n", s.Duration.Round(time.Microsecond))
+	if spans := s.LongestSpans(n); len(spans) > 0 {
+		buf.WriteString("Longest spans:\n")
+		for i, span := range spans {
+			fmt.Fprintf(&buf, "%d. %s, %s\n", i+1, span.Operation, span.Duration.Round(time.Microsecond))
+		}
+	}
+	events := s.NotableEvents()
+	if len(events) == 0 {
+		buf.WriteString("No retries, contention, or waits were logged.\n")
+		return buf.String()
+	}
+	buf.WriteString("Notable events:\n")
+	for _, e := range events[:min(maxEvents, len(events))] {
+		fmt.Fprintf(&buf, "- %s\n", e)
+	}
+	if omitted := len(events) - maxEvents; omitted > 0 {
+		fmt.Fprintf(&buf, "- and %d more\n", omitted)
+	}
+	return buf.String()
+}
@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"strings"
+	"testing"
+)
+
+// traceJSON is a minimal trace.json, with a retry, contention, and a wait
+// logged among the messages that aren't notable.
+const traceJSON = `{
+	"operation": "traced statement",
+	"startTime": "2025-12-03T16:39:05.000000Z",
+	"duration": "0.010s",
+	"logs": [
+		{"time": "2025-12-03T16:39:05.001000Z", "message": "sql/conn_executor_exec.go:1087 [n1,client=127.0.0.1:51230,user=‹demo›] executing after 0 retries, last retry reason: <nil>"},
+		{"time": "2025-12-03T16:39:05.009000Z", "message": "sql/conn_executor_exec.go:1087 [n1] executing after 1 retries, last retry reason: TransactionRetryWithProtoRefreshError: WriteTooOld"}
+	],
+	"children": [
+		{"operation": "optimizer", "startTime": "2025-12-03T16:39:05.000500Z", "duration": "0.002s"},
+		{
+			"operation": "flow",
+			"startTime": "2025-12-03T16:39:05.003000Z",
+			"duration": "0.005s",
+			"children": [{
+				"operation": "dist sender send",
+				"startTime": "2025-12-03T16:39:05.003500Z",
+				"duration": "0.004s",
+				"logs": [
+					{"time": "2025-12-03T16:39:05.004000Z", "message": "kv/kvserver/concurrency/lock_table_waiter.go:500 [n1] pushing txn ‹9dc9bfbe› to abort"},
+					{"time": "2025-12-03T16:39:05.005000Z", "message": "kv/kvserver/spanlatch/manager.go:600 [n1] waited 0s to acquire latches"},
+					{"time": "2025-12-03T16:39:05.006000Z", "message": "kv/kvserver/spanlatch/manager.go:600 [n1] waited 1.5ms to acquire latches"},
+					{"time": "2025-12-03T16:39:05.006500Z", "message": "kv/kvserver/replica_read.go:100 [n1] scanning lock table for conflicting locks"}
+				]
+			}]
+		}
+	]
+}`
+
+func TestParse(t *testing.T) {
+	root, err := Parse(traceJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Operation != "traced statement" || root.Duration.String() != "10ms" || len(root.Children) != 2 {
+		t.Errorf("root = %s, %s, %d children", root.Operation, root.Duration, len(root.Children))
+	}
+
+	var longest []string
+	for _, s := range root.LongestSpans(2) {
+		longest = append(longest, s.Operation)
+	}
+	if got := strings.Join(longest, ", "); got != "flow, dist sender send" {
+		t.Errorf("LongestSpans(2) = %s", got)
+	}
+
+	want := "The traced statement took 10ms.\n" +
+		"Longest spans:\n" +
+		"1. flow, 5ms\n" +
+		"2. dist sender send, 4ms\n" +
+		"3. optimizer, 2ms\n" +
+		"Notable events:\n" +
+		"- contention in dist sender send at +4ms: pushing txn 9dc9bfbe to abort\n" +
+		"- wait in dist sender send at +6ms: waited 1.5ms to acquire latches\n" +
+		"- and 1 more\n"
+	if got := root.Summarize(5, 2); got != want {
+		t.Errorf("Summarize(5, 2) =\n%s\nwant\n%s", got, want)
+	}
+
+	events := root.NotableEvents()
+	if len(events) != 3 || events[2].Kind != KindRetry || events[2].Span != "traced statement" {
+		t.Errorf("NotableEvents() = %v", events)
+	}
+}
+
+func TestParseArray(t *testing.T) {
+	root, err := Parse(`[
+		{"operation": "a", "startTime": "2025-12-03T16:39:05Z", "duration": "0.002s"},
+		{"operation": "b", "startTime": "2025-12-03T16:39:05.001Z", "duration": "0.003s"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Operation != "trace" || root.Duration.String() != "4ms" || len(root.Children) != 2 {
+		t.Errorf("root = %s, %s, %d children", root.Operation, root.Duration, len(root.Children))
+	}
+	want := "The traced statement took 4ms.\nLongest spans:\n1. b, 3ms\n2. a, 2ms\nNo retries, contention, or waits were logged.\n"
+	if got := root.Summarize(5, 10); got != want {
+		t.Errorf("Summarize(5, 10) =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		data, wantErr string
+	}{
+		{data: "", wantErr: "empty trace"},
+		{data: "[]", wantErr: "empty trace"},
+		{data: `{"operation": "x", "duration": "0.1s"`, wantErr: "invalid trace"},
+		{data: `{"duration": "0.1s"}`, wantErr: "span without an operation"},
+		{data: `{"operation": "x", "duration": "bogus"}`, wantErr: `invalid duration of span "x"`},
+		{data: `{"operation": "x", "children": [{"operation": "y", "duration": "1"}]}`, wantErr: `invalid duration of span "y"`},
+		{data: `{"operation": "x", "startTime": "yesterday"}`, wantErr: "invalid trace"},
+	} {
+		_, err := Parse(tc.data)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("Parse(%q) = %v, want an error containing %q", tc.data, err, tc.wantErr)
+		}
+	}
+}
+
+func TestIsTraceFile(t *testing.T) {
+	for name, want := range map[string]bool{
+		"trace.json":            true,
+		"bundle/trace.txt":      true,
+		"trace-jaeger.json":     true,
+		"plan.txt":              false,
+		"trace.json.statements": false,
+	} {
+		if got := IsTraceFile(name); got != want {
+			t.Errorf("IsTraceFile(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
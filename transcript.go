@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// transcript is the record of every exchange with the model made while
+// analyzing a bundle, written by -transcript.
+type transcript struct {
+	Bundle string `json:"bundle"`
+	// Timestamp is when the analysis started.
+	Timestamp time.Time  `json:"timestamp"`
+	Exchanges []exchange `json:"exchanges"`
+	// systemPrompt is the system message sent before the messages of each
+	// exchange.
+	systemPrompt string
+	mu           sync.Mutex
+}
+
+// exchange is a single request to the model and its reply.
+type exchange struct {
+	Timestamp time.Time `json:"timestamp"`
+	Model     string    `json:"model"`
+	// Messages are the messages sent, including the system message.
+	Messages []analyze.Message `json:"messages"`
+	Reply    string            `json:"reply"`
+	// Choices are the candidate replies, if more than one was requested.
+	Choices           []string       `json:"choices,omitempty"`
+	FinishReason      string         `json:"finish_reason,omitempty"`
+	SystemFingerprint string         `json:"system_fingerprint,omitempty"`
+	Usage             *analyze.Usage `json:"usage,omitempty"`
+	// Cached is true if the reply was read from the cache rather than
+	// requested.
+	Cached bool   `json:"cached,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type transcriptKey struct{}
+
+// transcriptFrom returns the transcript of the context, or nil if it has none.
+func transcriptFrom(ctx context.Context) *transcript {
+	t, _ := ctx.Value(transcriptKey{}).(*transcript)
+	return t
+}
+
+// add appends e, whose messages are those sent after the system message, to
+// the transcript.
+func (t *transcript) add(e exchange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e.Messages = append([]analyze.Message{{Role: "system", Content: t.systemPrompt}}, e.Messages...)
+	t.Exchanges = append(t.Exchanges, e)
+}
+
+// transcriptAnalyzer is an analyze.Analyzer that records the exchanges made
+// with a context holding a transcript.
+type transcriptAnalyzer struct {
+	analyze.Analyzer
+}
+
+// Analyze implements the analyze.Analyzer interface.
+func (a transcriptAnalyzer) Analyze(ctx context.Context, prompt string) (*analyze.Completion, error) {
+	comp, err := a.Analyzer.Analyze(ctx, prompt)
+	a.record(ctx, []analyze.Message{{Role: analyze.RoleUser, Content: prompt}}, comp, err)
+	return comp, err
+}
+
+// Chat implements the analyze.Analyzer interface.
+func (a transcriptAnalyzer) Chat(ctx context.Context, messages []analyze.Message) (*analyze.Completion, error) {
+	comp, err := a.Analyzer.Chat(ctx, messages)
+	a.record(ctx, messages, comp, err)
+	return comp, err
+}
+
+// record adds the exchange to the context's transcript, if any.
+func (a transcriptAnalyzer) record(ctx context.Context, messages []analyze.Message, comp *analyze.Completion, err error) {
+	t := transcriptFrom(ctx)
+	if t == nil {
+		return
+	}
+	e := exchange{
+		Timestamp: time.Now().UTC(),
+		Model:     a.Model(),
+		Messages:  messages,
+	}
+	if comp != nil {
+		e.Model, e.Reply, e.Choices, e.FinishReason = comp.Model, comp.Content, comp.Choices, comp.FinishReason
+		e.SystemFingerprint = comp.SystemFingerprint
+		usage := comp.Usage
+		e.Usage = &usage
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	t.add(e)
+}
+
+// recordCached adds the cached reply to prompt to the context's transcript, if
+// any.
+func recordCached(ctx context.Context, model, prompt, reply string) {
+	if t := transcriptFrom(ctx); t != nil {
+		t.add(exchange{
+			Timestamp: time.Now().UTC(),
+			Model:     model,
+			Messages:  []analyze.Message{{Role: analyze.RoleUser, Content: prompt}},
+			Reply:     reply,
+			Cached:    true,
+		})
+	}
+}
+
+// withTranscript runs the analysis of the bundle at path and, if file is set,
+// writes the transcript of the exchanges it made to file, whether or not it
+// succeeded.
+func withTranscript(ctx context.Context, path, file string, cfg config, run func(context.Context) (bundleResult, error)) (bundleResult, error) {
+	if file == "" {
+		return run(ctx)
+	}
+	t := &transcript{Bundle: path, Timestamp: time.Now().UTC(), Exchanges: []exchange{}, systemPrompt: cfg.systemPrompt}
+	res, err := run(context.WithValue(ctx, transcriptKey{}, t))
+	if len(t.Exchanges) > 0 {
+		if writeErr := writeTranscript(file, t, cfg.secrets); writeErr != nil {
+			log.Printf("%s: warning: failed to write transcript: %v", path, writeErr)
+		}
+	}
+	return res, err
+}
+
+// writeTranscript writes t to file as indented JSON, with each of the secrets,
+// such as API keys, redacted in case they appear anywhere in it.
+func writeTranscript(file string, t *transcript, secrets []string) error {
+	t.mu.Lock()
+	data, err := json.MarshalIndent(t, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	out := string(data)
+	for _, secret := range secrets {
+		if secret != "" {
+			out = strings.ReplaceAll(out, secret, "REDACTED")
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, []byte(out+"\n"), 0o600)
+}
+
+// apiKeys returns the API keys that may be sent to the model's provider: key,
+// given with -api-key-file, and those set in the environment.
+func apiKeys(key string) []string {
+	keys := []string{key, os.Getenv("OPENAI_API_KEY"), os.Getenv("ANTHROPIC_API_KEY"), os.Getenv("GEMINI_API_KEY")}
+	if path := os.Getenv("OPENAI_API_KEY_FILE"); path != "" {
+		if k, err := analyze.ReadAPIKeyFile(path); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	for i, k := range keys {
+		keys[i] = strings.TrimSpace(k)
+	}
+	return keys
+}
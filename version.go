@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// Build information, set at build time with, for example:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// printVersion prints the build information and the defaults built into the
+// binary. If the commit and build date weren't set at build time, they are
+// read from the version control information embedded by the Go toolchain.
+func printVersion(w io.Writer) {
+	rev, built := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && rev == "":
+				rev = s.Value
+			case s.Key == "vcs.time" && built == "":
+				built = s.Value
+			}
+		}
+	}
+	if rev == "" {
+		rev = "unknown"
+	}
+	if built == "" {
+		built = "unknown"
+	}
+
+	fmt.Fprintf(w, "bundlebot %s\n", version)
+	fmt.Fprintf(w, "commit: %s\n", rev)
+	fmt.Fprintf(w, "built: %s\n", built)
+	fmt.Fprintf(w, "go: %s\n", runtime.Version())
+	fmt.Fprintf(w, "default provider: %s\n", analyze.ProviderOpenAI)
+	fmt.Fprintf(w, "default model: %s (openai), %s (anthropic), %s (gemini)\n", analyze.DefaultOpenAIModel, analyze.DefaultAnthropicModel, analyze.DefaultGeminiModel)
+	fmt.Fprintf(w, "default endpoint: %s (openai), %s (anthropic), %s (gemini)\n", analyze.DefaultOpenAIEndpoint, analyze.DefaultAnthropicEndpoint, analyze.DefaultGeminiEndpoint)
+}
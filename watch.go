@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mgartner/bundlebot/analyze"
+)
+
+// defaultPollInterval is the default time between scans of the directory
+// watched by analyze-dir.
+const defaultPollInterval = 5 * time.Second
+
+// watcher analyzes the bundles that appear in a directory, for the
+// analyze-dir subcommand.
+type watcher struct {
+	dir      string
+	analyzer analyze.Analyzer
+	cfg      config
+	// sem limits the number of bundles analyzed at once.
+	sem chan struct{}
+	// seen is the size and modification time of each bundle that hasn't
+	// been analyzed yet when the directory was last scanned. A bundle is
+	// only analyzed once they are unchanged between two scans, so that a
+	// bundle still being copied into the directory isn't read.
+	seen map[string]fileState
+	// mu protects running, which holds the bundles being analyzed or
+	// waiting for their turn.
+	mu      sync.Mutex
+	running map[string]bool
+	// wg waits for the analyses in flight.
+	wg sync.WaitGroup
+}
+
+// fileState is the size and modification time of a file.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// analysisExt returns the extension of the file an analysis in the format is
+// written to.
+func analysisExt(format string) string {
+	switch format {
+	case formatJSON:
+		return ".analysis.json"
+	case formatMarkdown:
+		return ".analysis.md"
+	default:
+		return ".analysis.txt"
+	}
+}
+
+// runWatch implements the analyze-dir subcommand, which scans w.dir every
+// interval and analyzes each new *.zip bundle in it, until ctx is cancelled.
+// The analysis of a bundle is written next to it, such as to
+// stmt-bundle-1.analysis.txt, and a bundle that can't be analyzed gets a
+// .analysis.err file holding the error instead. Bundles with either file are
+// skipped, so that they aren't analyzed again after a restart; deleting the
+// file analyzes the bundle again.
+func runWatch(ctx context.Context, interval time.Duration, w *watcher) {
+	log.Printf("Watching %s for new bundles", w.dir)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.scan(ctx); err != nil {
+			log.Printf("warning: failed to scan %s: %v", w.dir, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// Wait for the analyses in flight, which are cancelled too,
+			// so that no partial analysis is left behind.
+			w.wg.Wait()
+			exitCancelled()
+		}
+	}
+}
+
+// scan starts analyzing each bundle in the directory that hasn't been
+// analyzed and hasn't changed since the previous scan.
+func (w *watcher) scan(ctx context.Context) error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		names[e.Name()] = true
+	}
+	var ready []string
+	seen := make(map[string]fileState)
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !strings.EqualFold(filepath.Ext(name), ".zip") || w.isRunning(name) {
+			continue
+		}
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if names[base+analysisExt(w.cfg.format)] || names[base+".analysis.err"] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			// The bundle was removed since the directory was read.
+			continue
+		}
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		if prev, ok := w.seen[name]; ok && prev == state {
+			ready = append(ready, name)
+			continue
+		}
+		seen[name] = state
+	}
+	w.seen = seen
+	sort.Strings(ready)
+
+	for _, name := range ready {
+		w.mu.Lock()
+		w.running[name] = true
+		w.mu.Unlock()
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			defer func() {
+				w.mu.Lock()
+				delete(w.running, name)
+				w.mu.Unlock()
+			}()
+			select {
+			case w.sem <- struct{}{}:
+				defer func() { <-w.sem }()
+			case <-ctx.Done():
+				return
+			}
+			w.analyze(ctx, name)
+		}()
+	}
+	return nil
+}
+
+// isRunning returns true if the bundle is being analyzed.
+func (w *watcher) isRunning(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.running[name]
+}
+
+// analyze analyzes the bundle with the given name in the directory, writing
+// the analysis, or the error, next to it.
+func (w *watcher) analyze(ctx context.Context, name string) {
+	path := filepath.Join(w.dir, name)
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	log.Printf("%s: analyzing", path)
+	res, err := withDebugDump(ctx, path, w.cfg, func(ctx context.Context) (bundleResult, error) {
+		return analyzeBundle(ctx, path, w.analyzer, w.cfg)
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	out, content := base+analysisExt(w.cfg.format), res.output
+	if err != nil {
+		log.Printf("%s: %v", path, err)
+		out, content = base+".analysis.err", err.Error()+"\n"
+	}
+	if err := writeFileAtomic(out, []byte(content)); err != nil {
+		log.Printf("%s: warning: failed to write %s: %v", path, out, err)
+		return
+	}
+	log.Printf("%s: wrote %s", path, out)
+}
+
+// writeFileAtomic writes data to path by way of a temporary file in the same
+// directory, so that path is never left partially written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// checkWatchDir returns an error if dir isn't a directory that can be
+// watched.
+func checkWatchDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}